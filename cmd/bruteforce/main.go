@@ -1,13 +1,11 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
-	"time"
 
 	"github.com/mtm/guardian/internal/bruteforce"
 	"github.com/mtm/guardian/internal/config"
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mtm/guardian/internal/allowlist"
+	"github.com/mtm/guardian/internal/backup"
+	"github.com/mtm/guardian/internal/config"
+	"github.com/mtm/guardian/internal/expiry"
+	"github.com/mtm/guardian/internal/firewall"
+	"github.com/mtm/guardian/internal/ledger"
+)
+
+// openState abre o firewall, a lista de permitidos, a agenda de expiração e
+// o registro de banimentos como o serviço faz, para os comandos que os
+// alteram diretamente
+func openState(cfg *config.Config) backup.State {
+	fw, err := firewall.New(cfg)
+	if err != nil {
+		log.Fatalf("Erro ao inicializar o firewall: %v", err)
+	}
+
+	dataDir := filepath.Join(cfg.InstallDir, "data")
+	expiryPath := filepath.Join(dataDir, "expiry.json")
+	ledgerPath := filepath.Join(dataDir, "ledger.json")
+	if cfg.DryRun {
+		dryRunDir := filepath.Join(dataDir, "dry-run")
+		fw = firewall.NewDryRun(fw, filepath.Join(dryRunDir, "actions.jsonl"))
+		expiryPath = filepath.Join(dryRunDir, "expiry.json")
+		ledgerPath = filepath.Join(dryRunDir, "ledger.json")
+	}
+
+	allow, err := allowlist.New(filepath.Join(dataDir, "allowlist.json"), cfg.Allowlist, cfg.IP)
+	if err != nil {
+		log.Fatalf("Erro ao carregar lista de permitidos: %v", err)
+	}
+	fw = allowlist.Protect(fw, allow)
+
+	sched, err := expiry.NewScheduler(expiryPath, fw)
+	if err != nil {
+		log.Fatalf("Erro ao carregar agenda de expiração: %v", err)
+	}
+
+	led, err := ledger.Open(ledgerPath)
+	if err != nil {
+		log.Fatalf("Erro ao carregar registro de banimentos: %v", err)
+	}
+	return backup.State{Firewall: fw, Allowlist: allow, Expiry: sched, Ledger: led}
+}
+
+// exportCommand grava o documento de exportação com os banimentos e a lista
+// de permitidos no arquivo informado em -o ou na saída padrão
+func exportCommand(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("o", "", "arquivo de saída (padrão: saída padrão)")
+	flags.Parse(args)
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Erro ao carregar configurações: %v", err)
+	}
+
+	doc, err := backup.Export(openState(cfg))
+	if err != nil {
+		log.Fatalf("Erro ao exportar: %v", err)
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Fatalf("Erro ao serializar documento: %v", err)
+	}
+	data = append(data, '\n')
+
+	if *output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*output, data, 0600); err != nil {
+		log.Fatalf("Erro ao gravar %s: %v", *output, err)
+	}
+	fmt.Printf("%d banimento(s) e %d entrada(s) da lista de permitidos exportados para %s\n", len(doc.Bans), len(doc.Allowlist), *output)
+}
+
+// importCommand aplica um documento exportado (de um arquivo ou, com "-", da
+// entrada padrão) ao firewall deste servidor. Deve ser executado com o
+// serviço parado; com ele em execução, use POST /guardian/import, para que
+// a agenda de expiração, a lista de permitidos e o registro de banimentos
+// do serviço não sobrescrevam os importados.
+func importCommand(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	replace := flags.Bool("replace", false, "remove os banimentos e as entradas da API ausentes do documento")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatalf("Uso: guardian import [--replace] <arquivo | ->")
+	}
+
+	var in io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Erro ao abrir %s: %v", path, err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	doc, err := backup.Read(in)
+	if err != nil {
+		log.Fatalf("Erro ao ler documento: %v", err)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Erro ao carregar configurações: %v", err)
+	}
+
+	state := openState(cfg)
+	result, err := backup.Import(doc, state, backup.Options{Replace: *replace})
+	if err != nil {
+		log.Fatalf("Erro ao importar: %v", err)
+	}
+
+	fmt.Printf("Documento de %s (%s) importado no %s\n", doc.Hostname, doc.Firewall, state.Firewall.Type())
+	fmt.Printf("Banidos: %d, desbanidos: %d, permitidos: %d, removidos da lista: %d\n",
+		len(result.Banned), len(result.Unbanned), len(result.Allowed), len(result.Removed))
+	for _, issue := range result.Skipped {
+		fmt.Printf("Ignorado %s: %s\n", issue.IP, issue.Reason)
+	}
+	for _, issue := range result.Failed {
+		fmt.Printf("Falha em %s: %s\n", issue.IP, issue.Reason)
+	}
+	if len(result.Failed) > 0 {
+		os.Exit(1)
+	}
+}
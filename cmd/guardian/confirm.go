@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/mtm/guardian/internal/commit"
+	"github.com/mtm/guardian/internal/config"
+)
+
+// confirmPath é o arquivo que o comando confirm cria para o serviço
+func confirmPath(cfg *config.Config) string {
+	return filepath.Join(cfg.InstallDir, "data", "confirm")
+}
+
+// confirmCommand confirma a ativação do firewall pendente no serviço em
+// execução, evitando que ela seja revertida
+func confirmCommand() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Erro ao carregar configurações: %v", err)
+	}
+
+	confirmed, err := commit.RequestConfirm(confirmPath(cfg), 5*time.Second)
+	if err != nil {
+		log.Fatalf("Erro ao confirmar a ativação: %v", err)
+	}
+	if !confirmed {
+		log.Fatalf("Nenhuma ativação do firewall aguardando confirmação (o serviço está em execução?)")
+	}
+
+	fmt.Println("Ativação do firewall confirmada")
+}
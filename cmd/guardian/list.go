@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mtm/guardian/internal/config"
+	"github.com/mtm/guardian/internal/firewall"
+)
+
+// listCommand exibe os banimentos presentes no firewall
+func listCommand() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Erro ao carregar configurações: %v", err)
+	}
+
+	fw, err := firewall.New(cfg)
+	if err != nil {
+		log.Fatalf("Erro ao inicializar o firewall: %v", err)
+	}
+
+	bans, err := fw.ListBanned()
+	if err != nil {
+		log.Fatalf("Erro ao listar banimentos: %v", err)
+	}
+
+	fmt.Printf("Firewall: %s - %d banimento(s)\n\n", fw.Type(), len(bans))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "IP\tFAMÍLIA\tAÇÃO\tPROTOCOLOS\tPORTAS\tREGRAS")
+	for _, ban := range bans {
+		ports := "todas"
+		if len(ban.Ports) > 0 {
+			list := make([]string, len(ban.Ports))
+			for i, port := range ban.Ports {
+				list[i] = strconv.Itoa(port)
+			}
+			ports = strings.Join(list, ",")
+		}
+		protocols := "todos"
+		if len(ban.Protocols) > 0 {
+			protocols = strings.Join(ban.Protocols, ",")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", ban.IP, ban.Family, ban.Action, protocols, ports, len(ban.Rules))
+	}
+	w.Flush()
+}
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
+	"github.com/mtm/guardian/internal/allowlist"
 	"github.com/mtm/guardian/internal/api"
 	"github.com/mtm/guardian/internal/bruteforce"
+	"github.com/mtm/guardian/internal/commit"
 	"github.com/mtm/guardian/internal/config"
+	"github.com/mtm/guardian/internal/expiry"
 	"github.com/mtm/guardian/internal/firewall"
+	"github.com/mtm/guardian/internal/hits"
+	"github.com/mtm/guardian/internal/ledger"
+	"github.com/mtm/guardian/internal/reconcile"
 )
 
 func main() {
@@ -20,6 +29,31 @@ func main() {
 		return
 	}
 
+	// Listar os banimentos presentes no firewall
+	if len(os.Args) > 1 && os.Args[1] == "list" {
+		listCommand()
+		return
+	}
+
+	// Confirmar a ativação do firewall feita em modo commit-confirm
+	if len(os.Args) > 1 && os.Args[1] == "confirm" {
+		confirmCommand()
+		return
+	}
+
+	// Exportar e importar os banimentos e a lista de permitidos
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		exportCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		importCommand(os.Args[2:])
+		return
+	}
+
+	dryRun := flag.Bool("dry-run", false, "registra as alterações no firewall em vez de aplicá-las")
+	flag.Parse()
+
 	log.Println("Iniciando Guardian - Gerenciador de Firewall")
 
 	// Carregar configurações
@@ -27,20 +61,49 @@ func main() {
 	if err != nil {
 		log.Fatalf("Erro ao carregar configurações: %v", err)
 	}
+	cfg.DryRun = cfg.DryRun || *dryRun
 
 	// Verificar e configurar o firewall
-	fw, err := firewall.New(cfg)
+	fw, detection, err := firewall.NewWithDetection(cfg, firewall.ExecRunner{})
+	if detection != nil {
+		for _, line := range detection.Lines() {
+			log.Printf("Detecção do firewall: %s", line)
+		}
+	}
 	if err != nil {
 		log.Fatalf("Erro ao inicializar o firewall: %v", err)
 	}
 
+	// No modo dry-run, nenhuma alteração chega ao firewall: elas são
+	// registradas em data/dry-run, junto com a agenda de expiração, o
+	// registro de banimentos e as decisões do detector
+	dataDir := filepath.Join(cfg.InstallDir, "data")
+	expiryPath := filepath.Join(dataDir, "expiry.json")
+	ledgerPath := filepath.Join(dataDir, "ledger.json")
+	if cfg.DryRun {
+		dryRunDir := filepath.Join(dataDir, "dry-run")
+		log.Printf("ATENÇÃO: modo dry-run ativo. O firewall (%s) não será alterado; as ações serão registradas em %s", fw.Type(), dryRunDir)
+		fw = firewall.NewDryRun(fw, filepath.Join(dryRunDir, "actions.jsonl"))
+		expiryPath = filepath.Join(dryRunDir, "expiry.json")
+		ledgerPath = filepath.Join(dryRunDir, "ledger.json")
+	}
+
 	// Verificar se o firewall está habilitado
 	enabled, err := fw.IsEnabled()
 	if err != nil {
 		log.Fatalf("Erro ao verificar status do firewall: %v", err)
 	}
 
-	if !enabled {
+	var pending *commit.Pending
+	if !enabled && cfg.EnableConfirm > 0 {
+		log.Println("Firewall não está habilitado. Ativando em modo commit-confirm...")
+		pending, err = commit.Enable(fw, cfg.EnableConfirm, confirmPath(cfg))
+		if err != nil {
+			log.Fatalf("Erro ao ativar o firewall: %v", err)
+		}
+		log.Printf("Firewall ativado. Confirme até %s com 'guardian confirm' ou POST /guardian/confirm; caso contrário, a ativação será revertida",
+			pending.Status().Deadline.Local().Format(time.RFC3339))
+	} else if !enabled {
 		log.Println("Firewall não está habilitado. Ativando...")
 		if err := fw.Enable(); err != nil {
 			log.Fatalf("Erro ao ativar o firewall: %v", err)
@@ -50,8 +113,67 @@ func main() {
 		log.Printf("Firewall já está habilitado (%s)", fw.Type())
 	}
 
+	// Reaplicar os banimentos ao tráfego dos containers do Docker (no modo
+	// dry-run, fw é o decorador e nada é alterado)
+	if err := firewall.SyncDocker(fw); err != nil {
+		log.Printf("Erro ao aplicar os banimentos no Docker: %v", err)
+	}
+
+	// Carregar a lista de permitidos e protegê-la em todos os caminhos de
+	// banimento (API e detector)
+	allow, err := allowlist.New(filepath.Join(dataDir, "allowlist.json"), cfg.Allowlist, cfg.IP)
+	if err != nil {
+		log.Fatalf("Erro ao carregar lista de permitidos: %v", err)
+	}
+	log.Printf("Lista de permitidos carregada: %d entrada(s)", len(allow.Entries()))
+	fw = allowlist.Protect(fw, allow)
+
+	// Carregar o registro local dos banimentos feitos pela API e pelo
+	// detector
+	led, err := ledger.Open(ledgerPath)
+	if err != nil {
+		log.Fatalf("Erro ao carregar registro de banimentos: %v", err)
+	}
+	log.Printf("Registro de banimentos carregado: %d banimento(s) ativo(s)", len(led.Active()))
+
+	// Carregar a agenda de banimentos temporários
+	sched, err := expiry.NewScheduler(expiryPath, fw)
+	if err != nil {
+		log.Fatalf("Erro ao carregar agenda de expiração: %v", err)
+	}
+	sched.OnExpire(func(ip string) {
+		if err := led.Close(ip, ledger.StatusExpired); err != nil {
+			log.Printf("Erro ao registrar expiração do banimento de %s: %v", ip, err)
+		}
+	})
+	go sched.Start()
+
+	// Reconciliar o registro de banimentos com o firewall: depois de um
+	// reinício do host ou de um "ufw reset", os banimentos registrados são
+	// reaplicados
+	reconciler := reconcile.New(fw, led, sched, cfg.ReconcileOrphans)
+	reconciler.RunAndLog(time.Now())
+	if cfg.ReconcileInterval > 0 {
+		go reconciler.Start(cfg.ReconcileInterval)
+	}
+
+	// Acompanhar os contadores dos banimentos, para saber quais bloqueiam
+	// tráfego
+	tracker, err := hits.NewTracker(filepath.Join(dataDir, "hits.json"), fw)
+	if err != nil {
+		log.Fatalf("Erro ao carregar contadores dos banimentos: %v", err)
+	}
+	go tracker.Start()
+
 	// Iniciar o servidor API
-	server := api.NewServer(cfg, fw)
+	server := api.NewServer(cfg, fw, sched, allow)
+	server.SetDetection(detection)
+	server.SetHits(tracker)
+	server.SetLedger(led)
+	server.SetReconciler(reconciler)
+	if pending != nil {
+		server.SetCommit(pending)
+	}
 	go func() {
 		if err := server.Start(); err != nil {
 			log.Fatalf("Erro ao iniciar o servidor API: %v", err)
@@ -59,7 +181,8 @@ func main() {
 	}()
 
 	// Iniciar o detector de força bruta
-	detector := bruteforce.NewDetector(cfg)
+	detector := bruteforce.NewDetector(cfg, fw, sched)
+	detector.SetLedger(led)
 	go detector.Start()
 
 	fmt.Printf("Guardian está em execução em http://%s:%d/guardian\n", cfg.IP, cfg.Port)
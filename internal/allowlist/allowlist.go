@@ -0,0 +1,314 @@
+package allowlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/mtm/guardian/internal/firewall"
+)
+
+// Origens das entradas da lista
+const (
+	SourceSystem = "sistema" // Loopback, link-local e endereços do próprio servidor
+	SourceConfig = "config"  // GUARDIAN_ALLOWLIST
+	SourceAPI    = "api"     // Adicionadas pela API, persistidas em disco
+)
+
+// interfaceAddrs retorna os endereços das interfaces de rede do servidor
+var interfaceAddrs = net.InterfaceAddrs
+
+// systemRanges são as redes que nunca podem ser banidas: loopback e link-local
+var systemRanges = []string{"127.0.0.0/8", "::1/128", "169.254.0.0/16", "fe80::/10"}
+
+// Entry é uma entrada da lista de permitidos: um IP ou rede CIDR
+type Entry struct {
+	Target  string `json:"ip"`
+	Source  string `json:"source"`
+	Comment string `json:"comment,omitempty"`
+
+	prefix netip.Prefix
+}
+
+// List é a lista de IPs e redes que nunca podem ser banidos. As entradas do
+// sistema e da configuração são fixas; as adicionadas pela API são
+// persistidas em um arquivo JSON.
+type List struct {
+	mu      sync.Mutex
+	path    string
+	entries []Entry
+}
+
+// New cria a lista com as entradas do sistema (loopback, link-local e os
+// endereços do servidor, incluindo serverIP), as da configuração e as
+// salvas em path
+func New(path string, configured []string, serverIP string) (*List, error) {
+	l := &List{path: path}
+
+	for _, target := range append(systemRanges, systemAddrs(serverIP)...) {
+		if _, err := l.add(Entry{Target: target, Source: SourceSystem}); err != nil {
+			return nil, err
+		}
+	}
+	for _, target := range configured {
+		if _, err := l.add(Entry{Target: target, Source: SourceConfig}); err != nil {
+			return nil, fmt.Errorf("erro na lista de permitidos (GUARDIAN_ALLOWLIST): %w", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return l, nil
+		}
+		return nil, fmt.Errorf("erro ao ler lista de permitidos: %w", err)
+	}
+
+	var saved []Entry
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("erro ao decodificar lista de permitidos %s: %w", path, err)
+	}
+	for _, e := range saved {
+		e.Source = SourceAPI
+		if _, err := l.add(e); err != nil {
+			return nil, fmt.Errorf("erro na lista de permitidos %s: %w", path, err)
+		}
+	}
+
+	return l, nil
+}
+
+// Add adiciona um IP ou rede à lista e persiste as entradas da API. Um alvo
+// já presente não é alterado e a entrada existente é retornada.
+func (l *List) Add(target, comment string) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, err := l.add(Entry{Target: target, Source: SourceAPI, Comment: comment})
+	if err != nil || e.Source != SourceAPI || e.Comment != comment {
+		return e, err
+	}
+	return e, l.save()
+}
+
+// Remove remove uma entrada adicionada pela API. Entradas do sistema e da
+// configuração não podem ser removidas.
+func (l *List) Remove(target string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	prefix, err := firewall.ParseTarget(target)
+	if err != nil {
+		return err
+	}
+
+	for i, e := range l.entries {
+		if e.prefix != prefix {
+			continue
+		}
+		if e.Source != SourceAPI {
+			return &FixedEntryError{Entry: e}
+		}
+		l.entries = append(l.entries[:i], l.entries[i+1:]...)
+		return l.save()
+	}
+
+	return &NotFoundError{Target: firewall.FormatTarget(prefix)}
+}
+
+// Entries retorna as entradas da lista
+func (l *List) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]Entry(nil), l.entries...)
+}
+
+// Match retorna a entrada que impede o banimento do alvo: uma entrada
+// contida no alvo ou que o contém. Banir uma rede que inclui um endereço
+// permitido também é recusado.
+func (l *List) Match(target string) (Entry, bool) {
+	prefix, err := firewall.ParseTarget(target)
+	if err != nil {
+		return Entry{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, e := range l.entries {
+		if e.prefix.Overlaps(prefix) {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// add valida e adiciona uma entrada, retornando a entrada existente para
+// alvos já presentes. Deve ser chamado com o mutex travado (ou durante a
+// construção).
+func (l *List) add(e Entry) (Entry, error) {
+	prefix, err := firewall.ParseTarget(e.Target)
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, other := range l.entries {
+		if other.prefix == prefix {
+			return other, nil
+		}
+	}
+
+	e.prefix = prefix
+	e.Target = firewall.FormatTarget(prefix)
+	l.entries = append(l.entries, e)
+	return e, nil
+}
+
+// save grava as entradas da API no disco de forma atômica. Deve ser chamado
+// com o mutex travado.
+func (l *List) save() error {
+	saved := []Entry{}
+	for _, e := range l.entries {
+		if e.Source == SourceAPI {
+			saved = append(saved, e)
+		}
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Target < saved[j].Target })
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("erro ao serializar lista de permitidos: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("erro ao criar diretório da lista de permitidos: %w", err)
+	}
+
+	tmp := l.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("erro ao salvar lista de permitidos: %w", err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return fmt.Errorf("erro ao salvar lista de permitidos: %w", err)
+	}
+
+	return nil
+}
+
+// systemAddrs retorna os endereços do próprio servidor: o IP configurado e
+// os endereços das interfaces de rede
+func systemAddrs(serverIP string) []string {
+	var addrs []string
+	if serverIP != "" {
+		addrs = append(addrs, serverIP)
+	}
+
+	ifaceAddrs, err := interfaceAddrs()
+	if err != nil {
+		return addrs
+	}
+	for _, a := range ifaceAddrs {
+		if ipNet, ok := a.(*net.IPNet); ok {
+			addrs = append(addrs, ipNet.IP.String())
+		}
+	}
+	return addrs
+}
+
+// NotFoundError indica que o alvo não está na lista de permitidos
+type NotFoundError struct {
+	Target string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s não está na lista de permitidos", e.Target)
+}
+
+// FixedEntryError indica uma tentativa de remover uma entrada do sistema ou
+// da configuração
+type FixedEntryError struct {
+	Entry Entry
+}
+
+func (e *FixedEntryError) Error() string {
+	return fmt.Sprintf("a entrada %s vem de %s e não pode ser removida pela API", e.Entry.Target, e.Entry.Source)
+}
+
+// AllowedError indica que o banimento foi recusado porque o alvo está na
+// lista de permitidos
+type AllowedError struct {
+	Target string
+	Entry  Entry
+}
+
+func (e *AllowedError) Error() string {
+	if e.Entry.Target == e.Target {
+		return fmt.Sprintf("%s está na lista de permitidos (origem: %s) e não pode ser banido", e.Target, e.Entry.Source)
+	}
+	return fmt.Sprintf("%s não pode ser banido: sobrepõe a entrada %s da lista de permitidos (origem: %s)", e.Target, e.Entry.Target, e.Entry.Source)
+}
+
+// guardedFirewall recusa banimentos de alvos presentes na lista de permitidos
+type guardedFirewall struct {
+	firewall.Firewall
+	list *List
+}
+
+// Protect retorna o firewall com o BanIP e o Batch protegidos pela lista de
+// permitidos.
+// Todos os caminhos de banimento (API, detector) devem usar o firewall
+// retornado.
+func Protect(fw firewall.Firewall, list *List) firewall.Firewall {
+	return &guardedFirewall{Firewall: fw, list: list}
+}
+
+// BanIP recusa o alvo quando ele sobrepõe uma entrada da lista
+func (f *guardedFirewall) BanIP(ip string, profile firewall.BanProfile) error {
+	if err := f.check(ip); err != nil {
+		return err
+	}
+	return f.Firewall.BanIP(ip, profile)
+}
+
+// Batch recusa os banimentos do lote que sobrepõem uma entrada da lista e
+// repassa os demais itens ao firewall
+func (f *guardedFirewall) Batch(ops []firewall.BatchOp) []error {
+	errs := make([]error, len(ops))
+	var allowed []firewall.BatchOp
+	var index []int
+	for i, op := range ops {
+		if !op.Unban {
+			if errs[i] = f.check(op.IP); errs[i] != nil {
+				continue
+			}
+		}
+		allowed = append(allowed, op)
+		index = append(index, i)
+	}
+
+	if len(allowed) > 0 {
+		for j, err := range f.Firewall.Batch(allowed) {
+			errs[index[j]] = err
+		}
+	}
+	return errs
+}
+
+// check retorna um AllowedError quando o alvo sobrepõe uma entrada da lista
+func (f *guardedFirewall) check(ip string) error {
+	entry, ok := f.list.Match(ip)
+	if !ok {
+		return nil
+	}
+	target, err := firewall.NormalizeTarget(ip)
+	if err != nil {
+		target = ip
+	}
+	return &AllowedError{Target: target, Entry: entry}
+}
@@ -0,0 +1,107 @@
+package allowlist
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/mtm/guardian/internal/config"
+	"github.com/mtm/guardian/internal/firewall"
+)
+
+// TestList testa as entradas automáticas, da configuração e da API
+func TestList(t *testing.T) {
+	interfaceAddrs = func() ([]net.Addr, error) {
+		_, ipNet, _ := net.ParseCIDR("10.20.30.40/24")
+		ipNet.IP = net.ParseIP("10.20.30.40")
+		return []net.Addr{ipNet}, nil
+	}
+	defer func() { interfaceAddrs = net.InterfaceAddrs }()
+
+	path := filepath.Join(t.TempDir(), "allowlist.json")
+	l, err := New(path, []string{"198.51.100.0/24"}, "203.0.113.5")
+	if err != nil {
+		t.Fatalf("Erro ao criar lista: %v", err)
+	}
+
+	matches := map[string]string{
+		"127.0.0.1":        "127.0.0.0/8",
+		"::1":              "::1",
+		"fe80::1":          "fe80::/10",
+		"169.254.10.1":     "169.254.0.0/16",
+		"203.0.113.5":      "203.0.113.5",
+		"203.0.113.0/24":   "203.0.113.5", // A rede contém o IP do servidor
+		"198.51.100.77":    "198.51.100.0/24",
+		"198.51.0.0/16":    "198.51.100.0/24",
+		"::ffff:192.0.2.2": "",
+		"10.20.30.0/24":    "10.20.30.40", // Endereço de uma interface
+		"192.0.2.1":        "",
+	}
+	for target, want := range matches {
+		entry, ok := l.Match(target)
+		if ok != (want != "") || entry.Target != want {
+			t.Errorf("Match(%q) = %q, %v; esperado %q", target, entry.Target, ok, want)
+		}
+	}
+
+	if _, err := l.Add("192.0.2.10", "monitoramento"); err != nil {
+		t.Fatalf("Erro ao adicionar entrada: %v", err)
+	}
+	if _, ok := l.Remove("127.0.0.0/8").(*FixedEntryError); !ok {
+		t.Error("Entradas do sistema não deveriam ser removidas")
+	}
+	if _, ok := l.Remove("192.0.2.99").(*NotFoundError); !ok {
+		t.Error("Remover entrada inexistente deveria retornar NotFoundError")
+	}
+
+	// As entradas da API devem sobreviver a um reinício
+	l, err = New(path, nil, "")
+	if err != nil {
+		t.Fatalf("Erro ao recarregar lista: %v", err)
+	}
+	entry, ok := l.Match("192.0.2.10")
+	if !ok || entry.Source != SourceAPI || entry.Comment != "monitoramento" {
+		t.Errorf("Entrada da API não foi recarregada: %+v", entry)
+	}
+	if err := l.Remove("192.0.2.10"); err != nil {
+		t.Fatalf("Erro ao remover entrada: %v", err)
+	}
+	if _, ok := l.Match("192.0.2.10"); ok {
+		t.Error("Entrada removida ainda consta na lista")
+	}
+}
+
+// TestProtect testa a recusa de banimentos de alvos permitidos
+func TestProtect(t *testing.T) {
+	l, err := New(filepath.Join(t.TempDir(), "allowlist.json"), []string{"198.51.100.7"}, "")
+	if err != nil {
+		t.Fatalf("Erro ao criar lista: %v", err)
+	}
+	mock := firewall.NewMockFirewall()
+	fw := Protect(mock, l)
+
+	err = fw.BanIP("198.51.100.0/24", config.DefaultBanProfile())
+	allowed, ok := err.(*AllowedError)
+	if !ok || allowed.Entry.Target != "198.51.100.7" || allowed.Entry.Source != SourceConfig {
+		t.Fatalf("Erro esperado AllowedError para 198.51.100.7, obtido: %v", err)
+	}
+	if banned, _ := mock.IsBanned("198.51.100.0/24"); banned {
+		t.Error("Rede não deveria ter sido banida")
+	}
+
+	if err := fw.BanIP("192.0.2.1", config.DefaultBanProfile()); err != nil {
+		t.Errorf("Erro ao banir IP fora da lista: %v", err)
+	}
+	// No lote, apenas os itens permitidos são recusados
+	errs := fw.Batch([]firewall.BatchOp{
+		{IP: "198.51.100.7", Profile: config.DefaultBanProfile()},
+		{IP: "203.0.113.9", Profile: config.DefaultBanProfile()},
+		{IP: "192.0.2.1", Unban: true},
+	})
+	if _, ok := errs[0].(*AllowedError); !ok || errs[1] != nil || errs[2] != nil {
+		t.Errorf("Erros inesperados no lote: %v", errs)
+	}
+	if banned, _ := mock.IsBanned("203.0.113.9"); !banned {
+		t.Error("IP fora da lista deveria ter sido banido no lote")
+	}
+}
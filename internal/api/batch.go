@@ -0,0 +1,188 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mtm/guardian/internal/config"
+	"github.com/mtm/guardian/internal/firewall"
+)
+
+// Operações de um item do lote
+const (
+	batchBan   = "ban"
+	batchUnban = "unban"
+)
+
+// BatchRequest representa um lote de banimentos e desbanimentos em
+// POST /v1/bans:batch
+type BatchRequest struct {
+	Entries []BatchEntry `json:"entries"`
+}
+
+// BatchEntry é um item do lote: a operação e os campos de BanRequest (apenas
+// o ip, nos desbanimentos)
+type BatchEntry struct {
+	Op string `json:"op,omitempty"` // ban (padrão) ou unban
+	BanRequest
+}
+
+// BatchResult é o resultado de um item do lote, com o status HTTP que a
+// operação teria isoladamente em /v1/bans
+type BatchResult struct {
+	IP        string     `json:"ip"`
+	Op        string     `json:"op"`
+	Status    int        `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// KilledConnections é o número de conexões estabelecidas encerradas no
+	// banimento (em dry-run, as que seriam encerradas)
+	KilledConnections *int   `json:"killed_connections,omitempty"`
+	Warning           string `json:"warning,omitempty"`
+}
+
+// BatchResponse representa o resultado de um lote. Success indica que todos
+// os itens foram aplicados.
+type BatchResponse struct {
+	Success bool          `json:"success"`
+	Results []BatchResult `json:"results"`
+}
+
+// handleV1Batch aplica um lote de banimentos e desbanimentos. Os itens são
+// validados como em /v1/bans e aplicados ao firewall de uma vez, com o
+// resultado de cada um na ordem do lote; a falha de um item não impede os
+// demais.
+func (s *Server) handleV1Batch(w http.ResponseWriter, r *http.Request) {
+	if !s.validateToken(r.Header.Get("Authorization")) {
+		writeError(w, http.StatusUnauthorized, "Não autorizado")
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "Método não permitido")
+		return
+	}
+
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Formato de requisição inválido")
+		return
+	}
+	limit := s.cfg.BatchLimit
+	if limit <= 0 {
+		limit = config.DefaultBatchLimit
+	}
+	if len(req.Entries) == 0 {
+		writeError(w, http.StatusBadRequest, "O lote não tem itens")
+		return
+	}
+	if len(req.Entries) > limit {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("O lote tem %d itens; o máximo é %d (GUARDIAN_BATCH_LIMIT)", len(req.Entries), limit))
+		return
+	}
+
+	bans, err := s.fw.ListBanned()
+	if err != nil {
+		writeFailure(w, fmt.Errorf("erro ao listar banimentos: %w", err))
+		return
+	}
+
+	results := make([]BatchResult, len(req.Entries))
+	fail := func(i int, err error) {
+		results[i].Status, results[i].Error = errorStatus(err), err.Error()
+		if results[i].Status == http.StatusInternalServerError {
+			log.Printf("Erro ao processar o item %s do lote: %v", results[i].IP, err)
+		}
+	}
+
+	// Validação de cada item, contra os banimentos atuais
+	var ops []firewall.BatchOp
+	var index []int
+	pending := make(map[int]*pendingBan)
+	for i, entry := range req.Entries {
+		op := strings.ToLower(entry.Op)
+		if op == "" {
+			op = batchBan
+		}
+		results[i] = BatchResult{IP: entry.IP, Op: op}
+
+		target, err := firewall.NormalizeTarget(entry.IP)
+		if err != nil {
+			fail(i, &requestError{http.StatusBadRequest, "Endereço IP ou rede inválidos"})
+			continue
+		}
+		results[i].IP = target
+
+		switch op {
+		case batchBan:
+			if firewall.FindBan(bans, target) != nil {
+				fail(i, &requestError{http.StatusConflict, fmt.Sprintf("%s já está banido", target)})
+				continue
+			}
+			entry.IP = target
+			p, err := s.prepareBan(r, entry.banInput())
+			if err != nil {
+				fail(i, err)
+				continue
+			}
+			pending[i] = p
+			ops = append(ops, firewall.BatchOp{IP: target, Profile: p.profile})
+		case batchUnban:
+			if banned, _ := firewall.BannedIn(bans, target); !banned {
+				fail(i, &requestError{http.StatusNotFound, fmt.Sprintf("%s não está banido", target)})
+				continue
+			}
+			ops = append(ops, firewall.BatchOp{IP: target, Unban: true})
+		default:
+			fail(i, &requestError{http.StatusBadRequest, "Operação inválida. Use 'ban' ou 'unban'"})
+			continue
+		}
+		index = append(index, i)
+	}
+
+	// Aplicação ao firewall, de uma vez, e conclusão dos itens aplicados
+	var errs []error
+	if len(ops) > 0 {
+		errs = s.fw.Batch(ops)
+	}
+	for j, err := range errs {
+		i := index[j]
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+
+		if ops[j].Unban {
+			if err := s.completeUnban(ops[j].IP); err != nil {
+				fail(i, err)
+				continue
+			}
+			results[i].Status = http.StatusNoContent
+			continue
+		}
+
+		result, err := s.completeBan(r, pending[i])
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+		results[i].Status = http.StatusCreated
+		results[i].ExpiresAt = result.ExpiresAt
+		results[i].KilledConnections = result.Killed
+		if result.KillErr != nil {
+			results[i].Warning = fmt.Sprintf("não foi possível encerrar as conexões estabelecidas: %v", result.KillErr)
+		}
+	}
+
+	resp := BatchResponse{Success: true, Results: results}
+	for _, result := range results {
+		if result.Error != "" {
+			resp.Success = false
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
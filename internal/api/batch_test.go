@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/mtm/guardian/internal/config"
+)
+
+// TestV1Batch testa o lote de banimentos e desbanimentos, com o resultado de
+// cada item
+func TestV1Batch(t *testing.T) {
+	mockFw, handler := newV1Server(t)
+
+	if rr := call(handler, "POST", "/v1/bans", BanRequest{IP: "203.0.113.7"}); rr.Code != http.StatusCreated {
+		t.Fatalf("Status code esperado: %d, obtido: %d (%s)", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	rr := call(handler, "POST", "/v1/bans:batch", BatchRequest{Entries: []BatchEntry{
+		{BanRequest: BanRequest{IP: "198.51.100.1", Duration: "1h", Reason: "lote"}},
+		{Op: "ban", BanRequest: BanRequest{IP: "203.0.113.7"}},
+		{BanRequest: BanRequest{IP: "invalido"}},
+		{Op: "unban", BanRequest: BanRequest{IP: "203.0.113.7"}},
+		{Op: "unban", BanRequest: BanRequest{IP: "192.0.2.1"}},
+		{Op: "renomear", BanRequest: BanRequest{IP: "192.0.2.2"}},
+		{BanRequest: BanRequest{IP: "198.51.100.3", Source: "feed"}},
+		{BanRequest: BanRequest{IP: "198.51.100.4", Source: "detector"}},
+	}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Status code esperado: %d, obtido: %d (%s)", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var resp BatchResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	expected := []int{
+		http.StatusCreated,
+		http.StatusConflict,
+		http.StatusBadRequest,
+		http.StatusNoContent,
+		http.StatusNotFound,
+		http.StatusBadRequest,
+		http.StatusCreated,
+		http.StatusBadRequest,
+	}
+	if resp.Success || len(resp.Results) != len(expected) {
+		t.Fatalf("Resposta inesperada: %s", rr.Body.String())
+	}
+	for i, status := range expected {
+		if resp.Results[i].Status != status {
+			t.Errorf("Item %d: status esperado %d, obtido %d (%s)", i, status, resp.Results[i].Status, resp.Results[i].Error)
+		}
+	}
+	if resp.Results[0].ExpiresAt == nil {
+		t.Error("Banimento do lote deveria ter expiração")
+	}
+
+	if banned, _ := mockFw.IsBanned("198.51.100.1"); !banned {
+		t.Error("IP deveria ter sido banido pelo lote")
+	}
+	if banned, _ := mockFw.IsBanned("203.0.113.7"); banned {
+		t.Error("IP deveria ter sido desbanido pelo lote")
+	}
+	rr = call(handler, "GET", "/v1/bans/198.51.100.1", nil)
+	var ban BanResponse
+	json.Unmarshal(rr.Body.Bytes(), &ban)
+	if ban.Ban == nil || ban.Ban.Reason != "lote" || ban.Ban.ExpiresAt == nil || ban.Ban.Source != "api" {
+		t.Errorf("Banimento inesperado: %s", rr.Body.String())
+	}
+	rr = call(handler, "GET", "/v1/bans/198.51.100.3", nil)
+	ban = BanResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &ban)
+	if ban.Ban == nil || ban.Ban.Source != "feed" {
+		t.Errorf("Banimento inesperado: %s", rr.Body.String())
+	}
+
+	// Lotes vazios ou acima do limite são recusados por inteiro
+	if rr := call(handler, "POST", "/v1/bans:batch", BatchRequest{}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusBadRequest, rr.Code)
+	}
+	entries := make([]BatchEntry, config.DefaultBatchLimit+1)
+	if rr := call(handler, "POST", "/v1/bans:batch", BatchRequest{Entries: entries}); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+	if rr := call(handler, "GET", "/v1/bans:batch", nil); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
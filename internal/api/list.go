@@ -0,0 +1,296 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mtm/guardian/internal/firewall"
+	"github.com/mtm/guardian/internal/ledger"
+)
+
+// maxListLimit é o maior tamanho de página aceito em GET /v1/bans
+const maxListLimit = 1000
+
+// Campos de ordenação de GET /v1/bans
+const (
+	sortIP        = "ip"
+	sortCreatedAt = "created_at"
+	sortExpiresAt = "expires_at"
+)
+
+// banQuery são os filtros, a ordenação e a paginação de GET /v1/bans
+type banQuery struct {
+	sources []string
+	// within restringe a lista aos banimentos contidos na rede
+	within        string
+	createdAfter  time.Time
+	createdBefore time.Time
+	expiresAfter  time.Time
+	expiresBefore time.Time
+	reason        string // Em minúsculas
+	sort          string
+	desc          bool
+	limit         int // 0 retorna todos os banimentos
+	after         *BanResource
+}
+
+// banCursor é a posição do último banimento de uma página: o valor do campo
+// de ordenação e o alvo, que desempata. Por não depender da posição na
+// lista, continua válido quando banimentos são criados ou removidos entre
+// as páginas.
+type banCursor struct {
+	Sort string     `json:"s"`
+	Desc bool       `json:"d,omitempty"`
+	At   *time.Time `json:"t,omitempty"`
+	IP   string     `json:"ip"`
+}
+
+// parseBanQuery lê os parâmetros de GET /v1/bans
+func parseBanQuery(values url.Values) (*banQuery, error) {
+	q := &banQuery{sort: sortIP}
+
+	for _, source := range strings.Split(values.Get("source"), ",") {
+		source = strings.ToLower(strings.TrimSpace(source))
+		if source == "" {
+			continue
+		}
+		if !ledger.ValidSource(source) {
+			return nil, &requestError{http.StatusBadRequest, fmt.Sprintf("Origem inválida em source: %s. Use %s", source, strings.Join(ledger.Sources, ", "))}
+		}
+		q.sources = append(q.sources, source)
+	}
+
+	if v := values.Get("cidr"); v != "" {
+		prefix, err := firewall.ParseTarget(v)
+		if err != nil {
+			return nil, &requestError{http.StatusBadRequest, fmt.Sprintf("Rede inválida em cidr: %s", v)}
+		}
+		q.within = firewall.FormatTarget(prefix)
+	}
+
+	times := []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"created_after", &q.createdAfter},
+		{"created_before", &q.createdBefore},
+		{"expires_after", &q.expiresAfter},
+		{"expires_before", &q.expiresBefore},
+	}
+	for _, t := range times {
+		v := values.Get(t.name)
+		if v == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, &requestError{http.StatusBadRequest, fmt.Sprintf("Data inválida em %s (use RFC 3339, ex.: 2024-01-02T15:04:05Z): %s", t.name, v)}
+		}
+		*t.dst = at
+	}
+
+	q.reason = strings.ToLower(values.Get("reason"))
+
+	if v := values.Get("sort"); v != "" {
+		q.sort, q.desc = strings.TrimPrefix(v, "-"), strings.HasPrefix(v, "-")
+		if q.sort != sortIP && q.sort != sortCreatedAt && q.sort != sortExpiresAt {
+			return nil, &requestError{http.StatusBadRequest, fmt.Sprintf("Ordenação inválida: %s. Use ip, created_at ou expires_at, com - para a ordem decrescente", v)}
+		}
+	}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			return nil, &requestError{http.StatusBadRequest, fmt.Sprintf("Limite inválido: %s. Use um número de 1 a %d", v, maxListLimit)}
+		}
+		q.limit = limit
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		after, err := q.decodeCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		q.after = after
+	}
+
+	return q, nil
+}
+
+// match indica se o banimento atende aos filtros. Os filtros de criação
+// excluem os banimentos sem registro, e os de expiração, os permanentes.
+func (q *banQuery) match(ban BanResource) bool {
+	if len(q.sources) > 0 && !containsString(q.sources, ban.Source) {
+		return false
+	}
+	if q.within != "" && !firewall.Covers(q.within, ban.IP) {
+		return false
+	}
+	if !inWindow(ban.CreatedAt, q.createdAfter, q.createdBefore) ||
+		!inWindow(ban.ExpiresAt, q.expiresAfter, q.expiresBefore) {
+		return false
+	}
+	return q.reason == "" || strings.Contains(strings.ToLower(ban.Reason), q.reason)
+}
+
+// inWindow indica se at está no intervalo [after, before). Limites zerados
+// não restringem; sem data, apenas um intervalo sem limites é atendido.
+func inWindow(at *time.Time, after, before time.Time) bool {
+	if after.IsZero() && before.IsZero() {
+		return true
+	}
+	if at == nil {
+		return false
+	}
+	return (after.IsZero() || !at.Before(after)) && (before.IsZero() || at.Before(before))
+}
+
+// compare ordena dois banimentos pelo campo da consulta, desempatando pelo
+// alvo. Banimentos sem o campo (sem registro ou permanentes) ficam no fim
+// nas duas direções.
+func (q *banQuery) compare(a, b BanResource) int {
+	c := 0
+	switch q.sort {
+	case sortCreatedAt:
+		c = compareTimes(a.CreatedAt, b.CreatedAt, q.desc)
+	case sortExpiresAt:
+		c = compareTimes(a.ExpiresAt, b.ExpiresAt, q.desc)
+	}
+	if c != 0 {
+		return c
+	}
+	c = compareTargets(a.IP, b.IP)
+	if q.desc {
+		c = -c
+	}
+	return c
+}
+
+// compareTimes compara duas datas, com as ausentes depois das demais
+func compareTimes(a, b *time.Time, desc bool) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	c := a.Compare(*b)
+	if desc {
+		c = -c
+	}
+	return c
+}
+
+// compareTargets ordena os alvos pelo endereço e, no mesmo endereço, pelo
+// tamanho do prefixo. Endereços IPv4 vêm antes dos IPv6.
+func compareTargets(a, b string) int {
+	pa, errA := firewall.ParseTarget(a)
+	pb, errB := firewall.ParseTarget(b)
+	if errA != nil || errB != nil {
+		return strings.Compare(a, b)
+	}
+	if c := pa.Addr().Compare(pb.Addr()); c != 0 {
+		return c
+	}
+	return pa.Bits() - pb.Bits()
+}
+
+// page ordena os banimentos filtrados e retorna os da página pedida, com o
+// cursor da próxima quando houver
+func (q *banQuery) page(bans []BanResource) ([]BanResource, string) {
+	sort.SliceStable(bans, func(i, j int) bool { return q.compare(bans[i], bans[j]) < 0 })
+
+	if q.after != nil {
+		start := sort.Search(len(bans), func(i int) bool { return q.compare(*q.after, bans[i]) < 0 })
+		bans = bans[start:]
+	}
+	if q.limit == 0 || len(bans) <= q.limit {
+		return bans, ""
+	}
+	bans = bans[:q.limit]
+	return bans, q.encodeCursor(bans[len(bans)-1])
+}
+
+// encodeCursor codifica a posição do banimento na ordenação da consulta
+func (q *banQuery) encodeCursor(ban BanResource) string {
+	cursor := banCursor{Sort: q.sort, Desc: q.desc, IP: ban.IP}
+	switch q.sort {
+	case sortCreatedAt:
+		cursor.At = ban.CreatedAt
+	case sortExpiresAt:
+		cursor.At = ban.ExpiresAt
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor lê um cursor de encodeCursor, que só é válido com a mesma
+// ordenação
+func (q *banQuery) decodeCursor(v string) (*BanResource, error) {
+	var cursor banCursor
+	data, err := base64.RawURLEncoding.DecodeString(v)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil || cursor.IP == "" {
+		return nil, &requestError{http.StatusBadRequest, "Cursor inválido"}
+	}
+	if cursor.Sort != q.sort || cursor.Desc != q.desc {
+		return nil, &requestError{http.StatusBadRequest, "Cursor inválido para esta ordenação"}
+	}
+
+	ban := &BanResource{IP: cursor.IP}
+	switch q.sort {
+	case sortCreatedAt:
+		ban.CreatedAt = cursor.At
+	case sortExpiresAt:
+		ban.ExpiresAt = cursor.At
+	}
+	return ban, nil
+}
+
+// listBans lista os banimentos do firewall com os filtros, a ordenação e a
+// paginação da consulta
+func (s *Server) listBans(w http.ResponseWriter, r *http.Request) {
+	q, err := parseBanQuery(r.URL.Query())
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	bans, err := s.fw.ListBanned()
+	if err != nil {
+		writeFailure(w, fmt.Errorf("erro ao listar banimentos: %w", err))
+		return
+	}
+
+	records := s.activeRecords()
+	matched := []BanResource{}
+	for _, ban := range bans {
+		if resource := s.resource(ban, records); q.match(resource) {
+			matched = append(matched, resource)
+		}
+	}
+
+	page, next := q.page(matched)
+	writeJSON(w, http.StatusOK, BanListResponse{Success: true, Bans: page, Total: len(matched), NextCursor: next})
+}
+
+// containsString indica se a lista contém o valor
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
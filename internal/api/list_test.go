@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mtm/guardian/internal/config"
+)
+
+// TestV1BansQuery testa os filtros, a ordenação e a paginação de GET /v1/bans
+func TestV1BansQuery(t *testing.T) {
+	mockFw, handler := newV1Server(t)
+
+	for _, req := range []BanRequest{
+		{IP: "203.0.113.7", Duration: "1h", Reason: "Varredura de portas"},
+		{IP: "203.0.113.8", Duration: "48h", Reason: "força bruta no SSH"},
+		{IP: "198.18.0.0/24", Reason: "varredura"},
+		{IP: "198.18.1.9", Reason: "lista de bloqueio", Source: "feed"},
+	} {
+		if rr := call(handler, "POST", "/v1/bans", req); rr.Code != http.StatusCreated {
+			t.Fatalf("Status code esperado: %d, obtido: %d (%s)", http.StatusCreated, rr.Code, rr.Body.String())
+		}
+	}
+	// Banimento feito fora da API, sem registro
+	if err := mockFw.BanIP("192.0.2.5", config.DefaultBanProfile()); err != nil {
+		t.Fatalf("Erro ao banir IP: %v", err)
+	}
+
+	list := func(query string) BanListResponse {
+		t.Helper()
+		rr := call(handler, "GET", "/v1/bans?"+query, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: status code esperado: %d, obtido: %d (%s)", query, http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var resp BanListResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp
+	}
+	ips := func(resp BanListResponse) []string {
+		var ips []string
+		for _, ban := range resp.Bans {
+			ips = append(ips, ban.IP)
+		}
+		return ips
+	}
+	assertIPs := func(query string, expected ...string) {
+		t.Helper()
+		resp := list(query)
+		got := ips(resp)
+		if resp.Total != len(expected) || len(got) != len(expected) {
+			t.Errorf("%s: esperados %v, obtidos %v (total %d)", query, expected, got, resp.Total)
+			return
+		}
+		for i := range expected {
+			if got[i] != expected[i] {
+				t.Errorf("%s: esperados %v, obtidos %v", query, expected, got)
+				return
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	at := func(d time.Duration) string { return url.QueryEscape(now.Add(d).Format(time.RFC3339)) }
+
+	// O banimento da lista de bloqueio fica fora das demais consultas
+	assertIPs("source=feed", "198.18.1.9")
+	if rr := call(handler, "DELETE", "/v1/bans/198.18.1.9", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("Status code esperado: %d, obtido: %d", http.StatusNoContent, rr.Code)
+	}
+
+	assertIPs("", "192.0.2.5", "198.18.0.0/24", "203.0.113.7", "203.0.113.8")
+	assertIPs("source=api", "198.18.0.0/24", "203.0.113.7", "203.0.113.8")
+	assertIPs("source=detector")
+	assertIPs("cidr=203.0.113.0/24", "203.0.113.7", "203.0.113.8")
+	assertIPs("reason=VARREDURA", "198.18.0.0/24", "203.0.113.7")
+	assertIPs("expires_before="+at(2*time.Hour), "203.0.113.7")
+	assertIPs("created_after="+at(-time.Hour)+"&expires_after="+at(2*time.Hour), "203.0.113.8")
+	assertIPs("created_after=" + at(time.Hour))
+	// Permanentes ficam no fim também na ordem decrescente
+	assertIPs("sort=-expires_at", "203.0.113.8", "203.0.113.7", "198.18.0.0/24", "192.0.2.5")
+
+	// Paginação pelo cursor, com o total de todas as páginas
+	first := list("limit=3")
+	if len(first.Bans) != 3 || first.Total != 4 || first.NextCursor == "" {
+		t.Fatalf("Primeira página inesperada: %+v", first)
+	}
+	second := list("limit=3&cursor=" + first.NextCursor)
+	if got := ips(second); len(got) != 1 || got[0] != "203.0.113.8" || second.Total != 4 || second.NextCursor != "" {
+		t.Errorf("Segunda página inesperada: %+v", second)
+	}
+
+	// Um banimento removido entre as páginas não desloca as seguintes
+	first = list("limit=2&sort=-ip")
+	if rr := call(handler, "DELETE", "/v1/bans/203.0.113.8", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("Status code esperado: %d, obtido: %d", http.StatusNoContent, rr.Code)
+	}
+	second = list("limit=2&sort=-ip&cursor=" + first.NextCursor)
+	if got := ips(second); len(got) != 2 || got[0] != "198.18.0.0/24" || got[1] != "192.0.2.5" {
+		t.Errorf("Página inesperada depois da remoção: %v", got)
+	}
+
+	for _, query := range []string{
+		"sort=motivo",
+		"source=fed",
+		"source=api,fed",
+		"limit=0",
+		"limit=5000",
+		"cidr=invalido",
+		"created_after=ontem",
+		"cursor=invalido",
+		"cursor=" + first.NextCursor, // Cursor de outra ordenação
+	} {
+		if rr := call(handler, "GET", "/v1/bans?"+query, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status code esperado: %d, obtido: %d", query, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/mtm/guardian/internal/firewall"
+	"github.com/mtm/guardian/internal/sessions"
+)
+
+// LockoutError indica que o banimento bloquearia o acesso do próprio
+// administrador: a conexão de quem fez a requisição ou sessões SSH abertas
+type LockoutError struct {
+	Target   string
+	Caller   string             // Endereço de quem fez a requisição, se afetado
+	Sessions []sessions.Session // Sessões SSH afetadas
+}
+
+func (e *LockoutError) Error() string {
+	var affected []string
+	if e.Caller != "" {
+		affected = append(affected, fmt.Sprintf("sua conexão com a API (%s)", e.Caller))
+	}
+	for _, s := range e.Sessions {
+		affected = append(affected, fmt.Sprintf("sessão SSH %s", s))
+	}
+	return fmt.Sprintf("o banimento de %s bloquearia %s; envie \"force\": true para banir mesmo assim",
+		e.Target, strings.Join(affected, ", "))
+}
+
+// checkLockout verifica se banir o alvo com o perfil informado derrubaria a
+// conexão de quem fez a requisição ou alguma sessão SSH estabelecida
+func (s *Server) checkLockout(r *http.Request, target string, profile firewall.BanProfile) error {
+	prefix, err := firewall.ParseTarget(target)
+	if err != nil {
+		return err
+	}
+
+	lockout := &LockoutError{Target: target}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if addr, err := netip.ParseAddr(host); err == nil {
+			addr = addr.Unmap().WithZone("")
+			if prefix.Contains(addr) && profile.Blocks("tcp", s.cfg.Port) {
+				lockout.Caller = addr.String()
+			}
+		}
+	}
+
+	established, err := s.sessions(s.cfg.SSHPorts)
+	if err != nil {
+		// Sem a tabela de conexões, apenas a conexão da requisição é verificada
+		log.Printf("Aviso: não foi possível verificar as sessões SSH: %v", err)
+	}
+	for _, session := range established {
+		if prefix.Contains(session.Remote.Addr()) && profile.Blocks("tcp", int(session.Local.Port())) {
+			lockout.Sessions = append(lockout.Sessions, session)
+		}
+	}
+
+	if lockout.Caller == "" && len(lockout.Sessions) == 0 {
+		return nil
+	}
+	return lockout
+}
@@ -3,56 +3,208 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/mtm/guardian/internal/allowlist"
+	"github.com/mtm/guardian/internal/backup"
+	"github.com/mtm/guardian/internal/commit"
 	"github.com/mtm/guardian/internal/config"
+	"github.com/mtm/guardian/internal/expiry"
 	"github.com/mtm/guardian/internal/firewall"
+	"github.com/mtm/guardian/internal/hits"
+	"github.com/mtm/guardian/internal/ledger"
+	"github.com/mtm/guardian/internal/reconcile"
+	"github.com/mtm/guardian/internal/sessions"
 )
 
 // Request representa uma solicitação para a API
 type Request struct {
 	Acao string `json:"acao"`
 	IP   string `json:"ip"`
+	// Duracao opcional do banimento (ex.: "30m", "24h", "7d"). Sem duração
+	// o banimento é permanente.
+	Duracao string `json:"duracao,omitempty"`
+	// Perfil opcional do banimento. Campos omitidos usam o perfil configurado
+	// (GUARDIAN_BAN_*).
+	Portas     string `json:"portas,omitempty"`     // ex.: "22,80" ou "all"
+	Protocolos string `json:"protocolos,omitempty"` // ex.: "tcp", "tcp,udp" ou "all"
+	Bloqueio   string `json:"bloqueio,omitempty"`   // drop, reject ou tarpit
+	// Force confirma um banimento que bloquearia a conexão de quem faz a
+	// requisição ou sessões SSH abertas
+	Force bool `json:"force,omitempty"`
+	// EncerrarConexoes encerra as conexões já estabelecidas do IP ao bani-lo.
+	// Omitido, vale GUARDIAN_KILL_CONNECTIONS.
+	EncerrarConexoes *bool `json:"encerrar_conexoes,omitempty"`
+	// Motivo e Autor opcionais, gravados no registro de banimentos. Sem
+	// autor, é registrado o endereço de quem faz a requisição.
+	Motivo string `json:"motivo,omitempty"`
+	Autor  string `json:"autor,omitempty"`
 }
 
 // Response representa uma resposta da API
 type Response struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+	Success   bool       `json:"success"`
+	Message   string     `json:"message"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// KilledConnections é o número de conexões estabelecidas encerradas no
+	// banimento (em dry-run, as que seriam encerradas)
+	KilledConnections *int `json:"killed_connections,omitempty"`
+}
+
+// BansResponse representa a lista de banimentos retornada pela API
+type BansResponse struct {
+	Success bool           `json:"success"`
+	Bans    []firewall.Ban `json:"bans"`
+}
+
+// AllowlistRequest representa uma alteração na lista de permitidos
+type AllowlistRequest struct {
+	IP         string `json:"ip"`
+	Comentario string `json:"comentario,omitempty"`
+}
+
+// AllowlistResponse representa a lista de permitidos retornada pela API
+type AllowlistResponse struct {
+	Success bool              `json:"success"`
+	Entries []allowlist.Entry `json:"entries"`
+}
+
+// ConfirmResponse representa o estado da ativação do firewall em modo
+// commit-confirm
+type ConfirmResponse struct {
+	Success bool          `json:"success"`
+	Status  commit.Status `json:"status"`
+}
+
+// StatusResponse representa o estado do Guardian e do firewall em uso
+type StatusResponse struct {
+	Success   bool                `json:"success"`
+	Firewall  string              `json:"firewall"`
+	Enabled   bool                `json:"enabled"`
+	DryRun    bool                `json:"dry_run"`
+	Detection *firewall.Detection `json:"detection,omitempty"`
+	Confirm   *commit.Status      `json:"confirm,omitempty"`
+}
+
+// CountersResponse representa os contadores dos banimentos retornados pela API
+type CountersResponse struct {
+	Success  bool        `json:"success"`
+	Counters []hits.Stat `json:"counters"`
+}
+
+// ImportResponse representa o resultado da importação de um documento
+// exportado
+type ImportResponse struct {
+	Success bool           `json:"success"`
+	Result  *backup.Result `json:"result"`
+}
+
+// ReconcileResponse representa o relatório de divergências entre o registro
+// de banimentos e o firewall
+type ReconcileResponse struct {
+	Success bool              `json:"success"`
+	Report  *reconcile.Report `json:"report"`
 }
 
 // Server representa o servidor da API
 type Server struct {
-	cfg      *config.Config
-	fw       firewall.Firewall
-	server   *http.Server
+	cfg       *config.Config
+	fw        firewall.Firewall
+	expiry    *expiry.Scheduler
+	allowlist *allowlist.List
+	commit    *commit.Pending
+	detection *firewall.Detection
+	hits      *hits.Tracker
+	ledger    *ledger.Ledger
+	reconcile *reconcile.Reconciler
+	server    *http.Server
+	// sessions retorna as sessões SSH estabelecidas (substituível em testes)
+	sessions func(ports []int) ([]sessions.Session, error)
+	// killConnections encerra as conexões estabelecidas de um IP banido
+	// (substituível em testes)
+	killConnections func(ip string, profile firewall.BanProfile) (int, error)
 }
 
-// NewServer cria uma nova instância do servidor API
-func NewServer(cfg *config.Config, fw firewall.Firewall) *Server {
+// NewServer cria uma nova instância do servidor API. O agendador de expiração
+// é opcional; sem ele, apenas banimentos permanentes são aceitos. A lista de
+// permitidos também é opcional; com ela, fw deve ser o firewall protegido
+// por allowlist.Protect.
+func NewServer(cfg *config.Config, fw firewall.Firewall, sched *expiry.Scheduler, allow *allowlist.List) *Server {
 	return &Server{
-		cfg: cfg,
-		fw:  fw,
+		cfg:       cfg,
+		fw:        fw,
+		expiry:    sched,
+		allowlist: allow,
+		sessions:  sessions.Established,
+
+		killConnections: firewall.NewConntrack(firewall.ExecRunner{}, cfg.DryRun).Kill,
 	}
 }
 
+// SetCommit registra a ativação do firewall aguardando confirmação, que
+// passa a poder ser confirmada em /guardian/confirm
+func (s *Server) SetCommit(p *commit.Pending) {
+	s.commit = p
+}
+
+// SetDetection registra o diagnóstico da detecção do firewall, retornado em
+// /guardian/status
+func (s *Server) SetDetection(d *firewall.Detection) {
+	s.detection = d
+}
+
+// SetHits registra o acompanhamento dos contadores dos banimentos, retornado
+// em /guardian/counters
+func (s *Server) SetHits(t *hits.Tracker) {
+	s.hits = t
+}
+
+// SetLedger registra o registro local dos banimentos, no qual os
+// banimentos e desbanimentos feitos pela API são gravados
+func (s *Server) SetLedger(l *ledger.Ledger) {
+	s.ledger = l
+}
+
+// SetReconciler registra a reconciliação do registro de banimentos com o
+// firewall, cujo relatório é retornado em /guardian/reconcile
+func (s *Server) SetReconciler(r *reconcile.Reconciler) {
+	s.reconcile = r
+}
+
 // Start inicia o servidor HTTP
 func (s *Server) Start() error {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/guardian", s.handleGuardian)
-
 	s.server = &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", s.cfg.IP, s.cfg.Port),
-		Handler: mux,
+		Handler: s.routes(),
 	}
 
 	return s.server.ListenAndServe()
 }
 
+// routes registra os endpoints da API
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/guardian", s.handleGuardian)
+	mux.HandleFunc("/guardian/bans", s.handleBans)
+	mux.HandleFunc("/guardian/allowlist", s.handleAllowlist)
+	mux.HandleFunc("/guardian/confirm", s.handleConfirm)
+	mux.HandleFunc("/guardian/status", s.handleStatus)
+	mux.HandleFunc("/guardian/counters", s.handleCounters)
+	mux.HandleFunc("/guardian/export", s.handleExport)
+	mux.HandleFunc("/guardian/import", s.handleImport)
+	mux.HandleFunc("/guardian/reconcile", s.handleReconcile)
+	mux.HandleFunc("/v1/bans", s.handleV1Bans)
+	mux.HandleFunc("/v1/bans/", s.handleV1Ban)
+	mux.HandleFunc("/v1/bans:batch", s.handleV1Batch)
+	return mux
+}
+
 // Shutdown encerra o servidor HTTP graciosamente
 func (s *Server) Shutdown() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -88,22 +240,38 @@ func (s *Server) handleGuardian(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validar IP
-	if !isValidIP(req.IP) {
-		http.Error(w, "Endereço IP inválido", http.StatusBadRequest)
+	// Validar IP ou rede (CIDR), usando a forma canônica daqui em diante
+	target, err := firewall.NormalizeTarget(req.IP)
+	if err != nil {
+		http.Error(w, "Endereço IP ou rede inválidos", http.StatusBadRequest)
 		return
 	}
+	req.IP = target
 
 	// Processar a ação
-	var err error
 	var message string
+	var expiresAt *time.Time
+	var killed *int
 
 	switch strings.ToLower(req.Acao) {
 	case "banir":
-		err = s.fw.BanIP(req.IP)
+		var result *banResult
+		result, err = s.ban(r, req.banInput())
+		if err != nil {
+			break
+		}
+		expiresAt, killed = result.ExpiresAt, result.Killed
 		message = fmt.Sprintf("IP %s banido com sucesso", req.IP)
+		if expiresAt != nil {
+			message = fmt.Sprintf("IP %s banido com sucesso até %s", req.IP, expiresAt.Format(time.RFC3339))
+		}
+		if result.KillErr != nil {
+			message += fmt.Sprintf("; não foi possível encerrar as conexões estabelecidas: %v", result.KillErr)
+		} else if killed != nil {
+			message += fmt.Sprintf("; %d conexões estabelecidas encerradas", *killed)
+		}
 	case "desbanir":
-		err = s.fw.UnbanIP(req.IP)
+		err = s.unban(req.IP)
 		message = fmt.Sprintf("IP %s desbanido com sucesso", req.IP)
 	default:
 		http.Error(w, "Ação inválida. Use 'banir' ou 'desbanir'", http.StatusBadRequest)
@@ -112,20 +280,589 @@ func (s *Server) handleGuardian(w http.ResponseWriter, r *http.Request) {
 
 	// Verificar se houve erro
 	if err != nil {
-		log.Printf("Erro ao processar ação %s para IP %s: %v", req.Acao, req.IP, err)
-		http.Error(w, fmt.Sprintf("Erro ao processar a solicitação: %v", err), http.StatusInternalServerError)
+		status := errorStatus(err)
+		if status == http.StatusInternalServerError {
+			log.Printf("Erro ao processar ação %s para IP %s: %v", req.Acao, req.IP, err)
+			http.Error(w, fmt.Sprintf("Erro ao processar a solicitação: %v", err), status)
+			return
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
 
 	// Enviar resposta de sucesso
 	resp := Response{
-		Success: true,
-		Message: message,
+		Success:           true,
+		Message:           message,
+		ExpiresAt:         expiresAt,
+		KilledConnections: killed,
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// banInput é um banimento solicitado à API, pelo endpoint legado ou pelo
+// recurso /v1/bans
+type banInput struct {
+	IP              string
+	Duration        string
+	Ports           string
+	Protocols       string
+	Action          string
+	Force           bool
+	KillConnections *bool
+	Reason          string
+	Creator         string
+	Source          string
+}
+
+// banInput converte a solicitação do endpoint legado
+func (req Request) banInput() banInput {
+	return banInput{
+		IP:              req.IP,
+		Duration:        req.Duracao,
+		Ports:           req.Portas,
+		Protocols:       req.Protocolos,
+		Action:          req.Bloqueio,
+		Force:           req.Force,
+		KillConnections: req.EncerrarConexoes,
+		Reason:          req.Motivo,
+		Creator:         req.Autor,
+	}
+}
+
+// banResult é o banimento aplicado pela API
+type banResult struct {
+	IP        string
+	Profile   firewall.BanProfile
+	ExpiresAt *time.Time
+	// Killed é o número de conexões encerradas, quando solicitado. Uma falha
+	// ao encerrá-las fica em KillErr, já que o banimento foi aplicado.
+	Killed  *int
+	KillErr error
+}
+
+// requestError é um erro na solicitação, retornado com o status informado
+type requestError struct {
+	status  int
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// errorStatus retorna o status HTTP correspondente a um erro do banimento ou
+// do desbanimento
+func errorStatus(err error) int {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return reqErr.status
+	}
+	var lockoutErr *LockoutError
+	var rangeErr *firewall.RangeError
+	var allowedErr *allowlist.AllowedError
+	if errors.As(err, &lockoutErr) || errors.As(err, &rangeErr) || errors.As(err, &allowedErr) {
+		return http.StatusConflict
+	}
+	var actionErr *firewall.UnsupportedActionError
+	if errors.As(err, &actionErr) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// pendingBan é um banimento validado, ainda não aplicado ao firewall
+type pendingBan struct {
+	in       banInput
+	profile  firewall.BanProfile
+	duration time.Duration
+}
+
+// ban valida e aplica um banimento: perfil, duração, verificação de
+// bloqueio do administrador, firewall, expiração, registro local e, se
+// solicitado, o encerramento das conexões estabelecidas
+func (s *Server) ban(r *http.Request, in banInput) (*banResult, error) {
+	p, err := s.prepareBan(r, in)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.fw.BanIP(p.in.IP, p.profile); err != nil {
+		return nil, err
+	}
+	return s.completeBan(r, p)
+}
+
+// prepareBan valida o alvo, a duração e o perfil do banimento e verifica se
+// ele bloquearia o acesso do administrador
+func (s *Server) prepareBan(r *http.Request, in banInput) (*pendingBan, error) {
+	target, err := firewall.NormalizeTarget(in.IP)
+	if err != nil {
+		return nil, &requestError{http.StatusBadRequest, "Endereço IP ou rede inválidos"}
+	}
+	in.IP = target
+
+	in.Source = strings.ToLower(strings.TrimSpace(in.Source))
+	switch in.Source {
+	case "":
+		in.Source = ledger.SourceAPI
+	case ledger.SourceAPI, ledger.SourceFeed:
+	default:
+		return nil, &requestError{http.StatusBadRequest, fmt.Sprintf("Origem inválida: %s. Use '%s' ou '%s'", in.Source, ledger.SourceAPI, ledger.SourceFeed)}
+	}
+
+	var duration time.Duration
+	if in.Duration != "" {
+		duration, err = config.ParseDuration(in.Duration)
+		if err != nil || duration < 0 {
+			return nil, &requestError{http.StatusBadRequest, "Duração inválida. Use, por exemplo, '30m', '24h' ou '7d'"}
+		}
+	}
+	if duration > 0 && s.expiry == nil {
+		return nil, &requestError{http.StatusBadRequest, "Banimentos temporários não estão disponíveis"}
+	}
+
+	profile, err := s.cfg.BanProfile.With(in.Ports, in.Protocols, in.Action)
+	if err != nil {
+		return nil, &requestError{http.StatusBadRequest, err.Error()}
+	}
+
+	if !in.Force {
+		if err := s.checkLockout(r, in.IP, profile); err != nil {
+			return nil, err
+		}
+	}
+
+	return &pendingBan{in: in, profile: profile, duration: duration}, nil
+}
+
+// completeBan agenda a expiração, grava o registro local e, se solicitado,
+// encerra as conexões estabelecidas de um banimento já aplicado ao firewall.
+// Se a expiração ou o registro falharem, o banimento é desfeito.
+func (s *Server) completeBan(r *http.Request, p *pendingBan) (*banResult, error) {
+	var err error
+	result := &banResult{IP: p.in.IP, Profile: p.profile}
+	if result.ExpiresAt, err = s.setExpiry(p.in.IP, p.duration); err == nil {
+		err = s.record(r, p.in, p.profile, result.ExpiresAt)
+	}
+	if err != nil {
+		return nil, s.undoBan(p.in.IP, err)
+	}
+
+	if s.shouldKill(p.in.KillConnections) {
+		// O banimento já foi aplicado; uma falha aqui só é informada
+		n, err := s.killConnections(p.in.IP, p.profile)
+		if err != nil {
+			log.Printf("Erro ao encerrar conexões de %s: %v", p.in.IP, err)
+			result.KillErr = err
+		} else {
+			result.Killed = &n
+		}
+	}
+	return result, nil
+}
+
+// undoBan remove do firewall um banimento cuja expiração ou registro não
+// puderam ser gravados. Sem registro, ele seria tratado como órfão pela
+// reconciliação; sem expiração, ficaria permanente. Retorna o erro original,
+// acrescido do erro da reversão quando ela também falhar.
+func (s *Server) undoBan(ip string, cause error) error {
+	if err := s.fw.UnbanIP(ip); err != nil {
+		log.Printf("Erro ao desfazer o banimento de %s: %v", ip, err)
+		return fmt.Errorf("%w (o banimento não pôde ser desfeito: %v)", cause, err)
+	}
+	// O banimento já saiu do firewall; uma falha aqui só é registrada no log
+	if err := s.completeUnban(ip); err != nil {
+		log.Printf("Erro ao remover a expiração ou o registro de %s: %v", ip, err)
+	}
+	return cause
+}
+
+// unban remove o banimento do firewall, a expiração agendada e o registro
+// ativo
+func (s *Server) unban(ip string) error {
+	if err := s.fw.UnbanIP(ip); err != nil {
+		return err
+	}
+	return s.completeUnban(ip)
+}
+
+// completeUnban cancela a expiração e encerra o registro ativo de um
+// banimento já removido do firewall
+func (s *Server) completeUnban(ip string) error {
+	if s.expiry != nil {
+		if err := s.expiry.Cancel(ip); err != nil {
+			return err
+		}
+	}
+	if s.ledger != nil {
+		return s.ledger.Close(ip, ledger.StatusRemoved)
+	}
+	return nil
+}
+
+// record grava no registro local um banimento feito pela API
+func (s *Server) record(r *http.Request, in banInput, profile firewall.BanProfile, expiresAt *time.Time) error {
+	if s.ledger == nil {
+		return nil
+	}
+	creator := in.Creator
+	if creator == "" {
+		creator = r.RemoteAddr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			creator = host
+		}
+	}
+	_, err := s.ledger.Add(ledger.Record{
+		IP:        in.IP,
+		Ports:     profile.Ports,
+		Protocols: profile.Protocols,
+		Action:    profile.Action,
+		Source:    in.Source,
+		Reason:    in.Reason,
+		Creator:   creator,
+		ExpiresAt: expiresAt,
+	})
+	return err
+}
+
+// shouldKill indica se as conexões estabelecidas do IP devem ser encerradas
+// no banimento: pelo campo da requisição ou, sem ele, pela configuração
+func (s *Server) shouldKill(requested *bool) bool {
+	if requested != nil {
+		return *requested
+	}
+	return s.cfg.KillConnections
+}
+
+// setExpiry agenda a expiração de um banimento temporário ou, para duração
+// zero, cancela uma expiração anterior tornando o banimento permanente
+func (s *Server) setExpiry(ip string, duration time.Duration) (*time.Time, error) {
+	if s.expiry == nil {
+		return nil, nil
+	}
+
+	if duration == 0 {
+		return nil, s.expiry.Cancel(ip)
+	}
+
+	expiresAt := time.Now().Add(duration).UTC()
+	if err := s.expiry.Schedule(ip, expiresAt); err != nil {
+		return nil, err
+	}
+	return &expiresAt, nil
+}
+
+// handleBans retorna os banimentos presentes no firewall
+func (s *Server) handleBans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.validateToken(r.Header.Get("Authorization")) {
+		http.Error(w, "Não autorizado", http.StatusUnauthorized)
+		return
+	}
+
+	bans, err := s.fw.ListBanned()
+	if err != nil {
+		log.Printf("Erro ao listar banimentos: %v", err)
+		http.Error(w, fmt.Sprintf("Erro ao listar banimentos: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if bans == nil {
+		bans = []firewall.Ban{}
+	}
+
+	writeJSON(w, http.StatusOK, BansResponse{Success: true, Bans: bans})
+}
+
+// handleAllowlist lista (GET), adiciona (POST) e remove (DELETE) entradas da
+// lista de permitidos
+func (s *Server) handleAllowlist(w http.ResponseWriter, r *http.Request) {
+	if !s.validateToken(r.Header.Get("Authorization")) {
+		http.Error(w, "Não autorizado", http.StatusUnauthorized)
+		return
+	}
+
+	if s.allowlist == nil {
+		http.Error(w, "Lista de permitidos não disponível", http.StatusNotFound)
+		return
 	}
 
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, AllowlistResponse{Success: true, Entries: s.allowlist.Entries()})
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req AllowlistRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Formato de requisição inválido", http.StatusBadRequest)
+		return
+	}
+	target, err := firewall.NormalizeTarget(req.IP)
+	if err != nil {
+		http.Error(w, "Endereço IP ou rede inválidos", http.StatusBadRequest)
+		return
+	}
+
+	var message string
+	if r.Method == http.MethodPost {
+		var entry allowlist.Entry
+		entry, err = s.allowlist.Add(target, req.Comentario)
+		message = fmt.Sprintf("%s adicionado à lista de permitidos", entry.Target)
+		if err == nil {
+			// Um banimento já existente não é removido automaticamente
+			if banned, berr := s.fw.IsBanned(target); berr == nil && banned {
+				message += fmt.Sprintf(" (atenção: %s está banido; desbana-o para liberar o acesso)", target)
+			}
+		}
+	} else {
+		err = s.allowlist.Remove(target)
+		message = fmt.Sprintf("%s removido da lista de permitidos", target)
+	}
+
+	var notFound *allowlist.NotFoundError
+	var fixed *allowlist.FixedEntryError
+	switch {
+	case errors.As(err, &notFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.As(err, &fixed):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Erro ao alterar a lista de permitidos: %v", err)
+		http.Error(w, fmt.Sprintf("Erro ao alterar a lista de permitidos: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{Success: true, Message: message})
+}
+
+// handleConfirm consulta (GET) ou confirma (POST) a ativação do firewall
+// feita em modo commit-confirm
+func (s *Server) handleConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.validateToken(r.Header.Get("Authorization")) {
+		http.Error(w, "Não autorizado", http.StatusUnauthorized)
+		return
+	}
+
+	if s.commit == nil {
+		http.Error(w, commit.ErrNotPending.Error(), http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if err := s.commit.Confirm(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Println("Ativação do firewall confirmada pela API")
+	}
+
+	writeJSON(w, http.StatusOK, ConfirmResponse{Success: true, Status: s.commit.Status()})
+}
+
+// handleStatus retorna o firewall em uso, seu estado e o diagnóstico da
+// detecção
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.validateToken(r.Header.Get("Authorization")) {
+		http.Error(w, "Não autorizado", http.StatusUnauthorized)
+		return
+	}
+
+	enabled, err := s.fw.IsEnabled()
+	if err != nil {
+		log.Printf("Erro ao verificar status do firewall: %v", err)
+		http.Error(w, fmt.Sprintf("Erro ao verificar status do firewall: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	resp := StatusResponse{
+		Success:   true,
+		Firewall:  s.fw.Type(),
+		Enabled:   enabled,
+		DryRun:    s.cfg.DryRun,
+		Detection: s.detection,
+	}
+	if s.commit != nil {
+		status := s.commit.Status()
+		resp.Confirm = &status
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleCounters retorna os pacotes e bytes bloqueados por cada banimento e
+// quando ele bloqueou tráfego pela última vez. Com hit_within (ex.: "24h"),
+// retorna apenas os banimentos que bloquearam tráfego nesse período; com
+// never_hit=true, apenas os que nunca bloquearam nada.
+func (s *Server) handleCounters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.validateToken(r.Header.Get("Authorization")) {
+		http.Error(w, "Não autorizado", http.StatusUnauthorized)
+		return
+	}
+
+	if s.hits == nil {
+		http.Error(w, "Contadores não disponíveis", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now().UTC()
+	var within time.Duration
+	if v := r.URL.Query().Get("hit_within"); v != "" {
+		d, err := config.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "Período inválido em hit_within", http.StatusBadRequest)
+			return
+		}
+		within = d
+	}
+	neverHit := r.URL.Query().Get("never_hit") == "true"
+
+	stats, err := s.hits.Sample(now)
+	var unsupported *firewall.UnsupportedCountersError
+	switch {
+	case errors.As(err, &unsupported):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	case err != nil:
+		log.Printf("Erro ao ler contadores dos banimentos: %v", err)
+		http.Error(w, fmt.Sprintf("Erro ao ler contadores dos banimentos: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	filtered := []hits.Stat{}
+	for _, stat := range stats {
+		if within > 0 && !stat.HitSince(now.Add(-within)) {
+			continue
+		}
+		if neverHit && !stat.NeverHit() {
+			continue
+		}
+		filtered = append(filtered, stat)
+	}
+
+	writeJSON(w, http.StatusOK, CountersResponse{Success: true, Counters: filtered})
+}
+
+// handleExport retorna o documento de exportação com os banimentos e a
+// lista de permitidos, que pode ser importado em /guardian/import
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.validateToken(r.Header.Get("Authorization")) {
+		http.Error(w, "Não autorizado", http.StatusUnauthorized)
+		return
+	}
+
+	doc, err := backup.Export(s.state())
+	if err != nil {
+		log.Printf("Erro ao exportar: %v", err)
+		http.Error(w, fmt.Sprintf("Erro ao exportar: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, doc)
+}
+
+// handleImport aplica um documento exportado. Com replace=true, os
+// banimentos e as entradas da API ausentes do documento são removidos.
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.validateToken(r.Header.Get("Authorization")) {
+		http.Error(w, "Não autorizado", http.StatusUnauthorized)
+		return
+	}
+
+	doc, err := backup.Read(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := backup.Options{Replace: r.URL.Query().Get("replace") == "true"}
+
+	result, err := backup.Import(doc, s.state(), opts)
+	if err != nil {
+		log.Printf("Erro ao importar: %v", err)
+		http.Error(w, fmt.Sprintf("Erro ao importar: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Importação concluída: %d banimento(s) aplicado(s), %d falha(s)", len(result.Banned), len(result.Failed))
+
+	writeJSON(w, http.StatusOK, ImportResponse{Success: len(result.Failed) == 0, Result: result})
+}
+
+// handleReconcile retorna o relatório da última reconciliação (GET) ou
+// executa uma reconciliação imediatamente (POST)
+func (s *Server) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.validateToken(r.Header.Get("Authorization")) {
+		http.Error(w, "Não autorizado", http.StatusUnauthorized)
+		return
+	}
+
+	if s.reconcile == nil {
+		http.Error(w, "Reconciliação não disponível", http.StatusNotFound)
+		return
+	}
+
+	report := s.reconcile.Last()
+	if r.Method == http.MethodPost || report == nil {
+		var err error
+		report, err = s.reconcile.Run(time.Now())
+		if err != nil {
+			log.Printf("Erro na reconciliação dos banimentos: %v", err)
+			http.Error(w, fmt.Sprintf("Erro na reconciliação dos banimentos: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, ReconcileResponse{Success: true, Report: report})
+}
+
+// state retorna o estado gerenciado pelo servidor, para a exportação e a
+// importação
+func (s *Server) state() backup.State {
+	return backup.State{Firewall: s.fw, Allowlist: s.allowlist, Expiry: s.expiry, Ledger: s.ledger}
+}
+
+// writeJSON envia uma resposta JSON com o status informado
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(resp)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
 }
 
 // validateToken verifica se o token de autenticação é válido
@@ -139,21 +876,3 @@ func (s *Server) validateToken(authHeader string) bool {
 	token := parts[1]
 	return token == s.cfg.AuthToken
 }
-
-// isValidIP verifica se uma string é um endereço IP válido
-func isValidIP(ip string) bool {
-	parts := strings.Split(ip, ".")
-	if len(parts) != 4 {
-		return false
-	}
-
-	for _, part := range parts {
-		// Verificar se cada parte é um número entre 0 e 255
-		var num int
-		if _, err := fmt.Sscanf(part, "%d", &num); err != nil || num < 0 || num > 255 {
-			return false
-		}
-	}
-
-	return true
-}
@@ -3,28 +3,41 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/mtm/guardian/internal/allowlist"
+	"github.com/mtm/guardian/internal/commit"
 	"github.com/mtm/guardian/internal/config"
+	"github.com/mtm/guardian/internal/expiry"
 	"github.com/mtm/guardian/internal/firewall"
+	"github.com/mtm/guardian/internal/hits"
+	"github.com/mtm/guardian/internal/ledger"
+	"github.com/mtm/guardian/internal/reconcile"
+	"github.com/mtm/guardian/internal/sessions"
 )
 
 // TestHandleGuardian testa o endpoint da API Guardian
 func TestHandleGuardian(t *testing.T) {
 	// Configuração de teste
 	cfg := &config.Config{
-		IP:        "127.0.0.1",
-		Port:      4554,
-		AuthToken: "test-token",
+		IP:         "127.0.0.1",
+		Port:       4554,
+		AuthToken:  "test-token",
+		BanProfile: config.DefaultBanProfile(),
 	}
 
 	// Criar mock do firewall
 	mockFw := firewall.NewMockFirewall()
 
 	// Criar servidor
-	server := NewServer(cfg, mockFw)
+	server := NewServer(cfg, mockFw, nil, nil)
 
 	// Teste 1: Requisição válida para banir IP
 	t.Run("Ban IP Valid Request", func(t *testing.T) {
@@ -154,3 +167,645 @@ func TestHandleGuardian(t *testing.T) {
 		}
 	})
 }
+
+// TestHandleBans testa a listagem de banimentos
+func TestHandleBans(t *testing.T) {
+	cfg := &config.Config{
+		IP:         "127.0.0.1",
+		Port:       4554,
+		AuthToken:  "test-token",
+		BanProfile: config.DefaultBanProfile(),
+	}
+
+	mockFw := firewall.NewMockFirewall()
+	mockFw.BanIP("192.168.1.100", config.DefaultBanProfile())
+	server := NewServer(cfg, mockFw, nil, nil)
+
+	req := httptest.NewRequest("GET", "/guardian/bans", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+
+	rr := httptest.NewRecorder()
+	server.handleBans(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Status code esperado: %d, obtido: %d", http.StatusOK, status)
+	}
+
+	var resp BansResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Erro ao decodificar resposta: %v", err)
+	}
+
+	if len(resp.Bans) != 1 || resp.Bans[0].IP != "192.168.1.100" {
+		t.Errorf("Banimentos esperados: [192.168.1.100], obtidos: %+v", resp.Bans)
+	}
+}
+
+// TestHandleGuardianTemporaryBan testa o banimento com duração
+func TestHandleGuardianTemporaryBan(t *testing.T) {
+	cfg := &config.Config{
+		IP:         "127.0.0.1",
+		Port:       4554,
+		AuthToken:  "test-token",
+		BanProfile: config.DefaultBanProfile(),
+	}
+
+	mockFw := firewall.NewMockFirewall()
+	sched, err := expiry.NewScheduler(filepath.Join(t.TempDir(), "expiry.json"), mockFw)
+	if err != nil {
+		t.Fatalf("Erro ao criar agendador: %v", err)
+	}
+	server := NewServer(cfg, mockFw, sched, nil)
+
+	send := func(reqBody Request) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(reqBody)
+		req := httptest.NewRequest("POST", "/guardian", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		server.handleGuardian(rr, req)
+		return rr
+	}
+
+	rr := send(Request{Acao: "banir", IP: "192.168.1.100", Duracao: "2h"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Status code esperado: %d, obtido: %d", http.StatusOK, rr.Code)
+	}
+
+	var resp Response
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Erro ao decodificar resposta: %v", err)
+	}
+	if resp.ExpiresAt == nil {
+		t.Fatal("Resposta deveria informar a expiração")
+	}
+	if _, ok := sched.ExpiresAt("192.168.1.100"); !ok {
+		t.Error("Expiração deveria ter sido agendada")
+	}
+
+	// Duração inválida
+	if rr := send(Request{Acao: "banir", IP: "192.168.1.100", Duracao: "amanhã"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// Desbanir cancela a expiração
+	if rr := send(Request{Acao: "desbanir", IP: "192.168.1.100"}); rr.Code != http.StatusOK {
+		t.Fatalf("Status code esperado: %d, obtido: %d", http.StatusOK, rr.Code)
+	}
+	if _, ok := sched.ExpiresAt("192.168.1.100"); ok {
+		t.Error("Expiração deveria ter sido cancelada")
+	}
+}
+
+// TestHandleGuardianCIDR testa banimentos de redes
+func TestHandleGuardianCIDR(t *testing.T) {
+	cfg := &config.Config{
+		IP:         "127.0.0.1",
+		Port:       4554,
+		AuthToken:  "test-token",
+		BanProfile: config.DefaultBanProfile(),
+	}
+
+	mockFw := firewall.NewMockFirewall()
+	server := NewServer(cfg, mockFw, nil, nil)
+
+	send := func(reqBody Request) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(reqBody)
+		req := httptest.NewRequest("POST", "/guardian", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		server.handleGuardian(rr, req)
+		return rr
+	}
+
+	if rr := send(Request{Acao: "banir", IP: "2001:db8::/48"}); rr.Code != http.StatusOK {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusOK, rr.Code)
+	}
+	if rr := send(Request{Acao: "banir", IP: "10.0.0.7/24"}); rr.Code != http.StatusOK {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusOK, rr.Code)
+	}
+	if banned, _ := mockFw.IsBanned("10.0.0.0/24"); !banned {
+		t.Error("Rede 10.0.0.0/24 deveria estar banida")
+	}
+
+	// Desbanir um IP contido em uma rede banida é recusado
+	if rr := send(Request{Acao: "desbanir", IP: "10.0.0.7"}); rr.Code != http.StatusConflict {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusConflict, rr.Code)
+	}
+}
+
+// TestHandleGuardianProfile testa o perfil de banimento enviado na requisição
+func TestHandleGuardianProfile(t *testing.T) {
+	cfg := &config.Config{
+		IP:         "127.0.0.1",
+		Port:       4554,
+		AuthToken:  "test-token",
+		BanProfile: config.DefaultBanProfile(),
+	}
+
+	mockFw := firewall.NewMockFirewall()
+	server := NewServer(cfg, mockFw, nil, nil)
+
+	send := func(reqBody Request) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(reqBody)
+		req := httptest.NewRequest("POST", "/guardian", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		server.handleGuardian(rr, req)
+		return rr
+	}
+
+	if rr := send(Request{Acao: "banir", IP: "192.168.1.100", Portas: "53", Protocolos: "udp", Bloqueio: "reject"}); rr.Code != http.StatusOK {
+		t.Fatalf("Status code esperado: %d, obtido: %d", http.StatusOK, rr.Code)
+	}
+	bans, _ := mockFw.ListBanned()
+	if len(bans) != 1 || bans[0].Action != "reject" || bans[0].Protocols[0] != "udp" || bans[0].Ports[0] != 53 {
+		t.Errorf("Banimento inesperado: %+v", bans)
+	}
+
+	// Campos omitidos usam o perfil configurado
+	if rr := send(Request{Acao: "banir", IP: "192.168.1.101", Bloqueio: "reject"}); rr.Code != http.StatusOK {
+		t.Fatalf("Status code esperado: %d, obtido: %d", http.StatusOK, rr.Code)
+	}
+	bans, _ = mockFw.ListBanned()
+	if len(bans) != 2 || len(bans[1].Ports) != 4 || bans[1].Action != "reject" {
+		t.Errorf("Banimento inesperado: %+v", bans)
+	}
+
+	for _, req := range []Request{
+		{Acao: "banir", IP: "192.168.1.102", Bloqueio: "accept"},
+		{Acao: "banir", IP: "192.168.1.102", Portas: "70000"},
+		{Acao: "banir", IP: "192.168.1.102", Protocolos: "udp", Bloqueio: "tarpit"},
+	} {
+		if rr := send(req); rr.Code != http.StatusBadRequest {
+			t.Errorf("%+v: status code esperado: %d, obtido: %d", req, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
+
+// TestHandleAllowlist testa a gestão da lista de permitidos e a recusa de
+// banimentos de alvos permitidos
+func TestHandleAllowlist(t *testing.T) {
+	cfg := &config.Config{
+		IP:         "127.0.0.1",
+		Port:       4554,
+		AuthToken:  "test-token",
+		BanProfile: config.DefaultBanProfile(),
+	}
+
+	allow, err := allowlist.New(filepath.Join(t.TempDir(), "allowlist.json"), nil, cfg.IP)
+	if err != nil {
+		t.Fatalf("Erro ao criar lista de permitidos: %v", err)
+	}
+	mockFw := firewall.NewMockFirewall()
+	server := NewServer(cfg, allowlist.Protect(mockFw, allow), nil, allow)
+
+	send := func(method string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, "/guardian/allowlist", bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer test-token")
+
+		rr := httptest.NewRecorder()
+		server.handleAllowlist(rr, req)
+		return rr
+	}
+	ban := func(ip string) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(Request{Acao: "banir", IP: ip})
+		req := httptest.NewRequest("POST", "/guardian", bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer test-token")
+
+		rr := httptest.NewRecorder()
+		server.handleGuardian(rr, req)
+		return rr
+	}
+
+	if rr := send("POST", AllowlistRequest{IP: "198.18.0.10", Comentario: "escritório"}); rr.Code != http.StatusOK {
+		t.Fatalf("Status code esperado: %d, obtido: %d", http.StatusOK, rr.Code)
+	}
+
+	// O banimento é recusado e a resposta indica a entrada correspondente
+	rr := ban("198.18.0.0/24")
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "198.18.0.10") {
+		t.Errorf("Esperado 409 citando 198.18.0.10, obtido %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := ban("127.0.0.1"); rr.Code != http.StatusConflict {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusConflict, rr.Code)
+	}
+
+	rr = send("GET", nil)
+	var resp AllowlistResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || len(resp.Entries) < 5 {
+		t.Errorf("Lista inesperada (%d): %+v", rr.Code, resp.Entries)
+	}
+
+	if rr := send("DELETE", AllowlistRequest{IP: "127.0.0.0/8"}); rr.Code != http.StatusConflict {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusConflict, rr.Code)
+	}
+	if rr := send("DELETE", AllowlistRequest{IP: "198.18.0.10"}); rr.Code != http.StatusOK {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusOK, rr.Code)
+	}
+	if rr := send("DELETE", AllowlistRequest{IP: "198.18.0.10"}); rr.Code != http.StatusNotFound {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusNotFound, rr.Code)
+	}
+	if rr := ban("198.18.0.0/24"); rr.Code != http.StatusOK {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusOK, rr.Code)
+	}
+}
+
+// TestHandleGuardianLockout testa a recusa de banimentos que bloqueariam a
+// conexão do administrador ou sessões SSH abertas
+func TestHandleGuardianLockout(t *testing.T) {
+	cfg := &config.Config{
+		IP:         "127.0.0.1",
+		Port:       4554,
+		AuthToken:  "test-token",
+		BanProfile: config.DefaultBanProfile(),
+		SSHPorts:   []int{22},
+	}
+	mockFw := firewall.NewMockFirewall()
+	server := NewServer(cfg, mockFw, nil, nil)
+	server.sessions = func(ports []int) ([]sessions.Session, error) {
+		return []sessions.Session{{
+			Local:  netip.MustParseAddrPort("10.0.0.1:22"),
+			Remote: netip.MustParseAddrPort("198.51.100.7:51234"),
+		}}, nil
+	}
+
+	ban := func(req Request) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(req)
+		r := httptest.NewRequest("POST", "/guardian", bytes.NewReader(data))
+		r.RemoteAddr = "203.0.113.9:40000"
+		r.Header.Set("Authorization", "Bearer test-token")
+
+		rr := httptest.NewRecorder()
+		server.handleGuardian(rr, r)
+		return rr
+	}
+
+	// Rede que contém o endereço de quem faz a requisição
+	rr := ban(Request{Acao: "banir", IP: "203.0.113.0/24"})
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "203.0.113.9") {
+		t.Errorf("Esperado 409 citando a conexão da requisição, obtido %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Origem de uma sessão SSH
+	rr = ban(Request{Acao: "banir", IP: "198.51.100.7"})
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "198.51.100.7:51234") {
+		t.Errorf("Esperado 409 citando a sessão SSH, obtido %d: %s", rr.Code, rr.Body.String())
+	}
+	if banned, _ := mockFw.IsBanned("198.51.100.7"); banned {
+		t.Error("IP não deveria ter sido banido")
+	}
+
+	// Um perfil que não bloqueia a porta do SSH não derruba a sessão
+	if rr := ban(Request{Acao: "banir", IP: "198.51.100.7", Portas: "80"}); rr.Code != http.StatusOK {
+		t.Errorf("Status code esperado: %d, obtido: %d (%s)", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// Com force o banimento é aplicado
+	if rr := ban(Request{Acao: "banir", IP: "198.51.100.7", Force: true}); rr.Code != http.StatusOK {
+		t.Errorf("Status code esperado: %d, obtido: %d (%s)", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if banned, _ := mockFw.IsBanned("198.51.100.7"); !banned {
+		t.Error("IP deveria ter sido banido com force")
+	}
+}
+
+// TestHandleGuardianKillConnections testa o encerramento das conexões
+// estabelecidas no banimento
+func TestHandleGuardianKillConnections(t *testing.T) {
+	cfg := &config.Config{
+		IP:              "127.0.0.1",
+		Port:            4554,
+		AuthToken:       "test-token",
+		BanProfile:      config.DefaultBanProfile(),
+		KillConnections: true,
+	}
+	server := NewServer(cfg, firewall.NewMockFirewall(), nil, nil)
+	var killed []string
+	server.killConnections = func(ip string, profile firewall.BanProfile) (int, error) {
+		killed = append(killed, ip)
+		return 3, nil
+	}
+
+	ban := func(req Request) Response {
+		data, _ := json.Marshal(req)
+		r := httptest.NewRequest("POST", "/guardian", bytes.NewReader(data))
+		r.Header.Set("Authorization", "Bearer test-token")
+
+		rr := httptest.NewRecorder()
+		server.handleGuardian(rr, r)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Status code esperado: %d, obtido: %d (%s)", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var resp Response
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return resp
+	}
+
+	resp := ban(Request{Acao: "banir", IP: "198.18.0.5"})
+	if resp.KilledConnections == nil || *resp.KilledConnections != 3 {
+		t.Errorf("Esperadas 3 conexões encerradas, obtido %v", resp.KilledConnections)
+	}
+
+	// O campo da requisição prevalece sobre a configuração
+	no := false
+	resp = ban(Request{Acao: "banir", IP: "198.18.0.6", EncerrarConexoes: &no})
+	if resp.KilledConnections != nil || len(killed) != 1 {
+		t.Errorf("Conexões não deveriam ser encerradas: %v", killed)
+	}
+
+	// Uma falha ao encerrar as conexões não desfaz o banimento
+	server.killConnections = func(ip string, profile firewall.BanProfile) (int, error) {
+		return 0, errors.New("conntrack não encontrado")
+	}
+	resp = ban(Request{Acao: "banir", IP: "198.18.0.7"})
+	if !resp.Success || resp.KilledConnections != nil || !strings.Contains(resp.Message, "conntrack não encontrado") {
+		t.Errorf("Resposta inesperada: %+v", resp)
+	}
+}
+
+// TestHandleConfirm testa a confirmação da ativação do firewall
+func TestHandleConfirm(t *testing.T) {
+	cfg := &config.Config{
+		IP:         "127.0.0.1",
+		Port:       4554,
+		AuthToken:  "test-token",
+		BanProfile: config.DefaultBanProfile(),
+	}
+	mockFw := firewall.NewMockFirewall()
+	server := NewServer(cfg, mockFw, nil, nil)
+
+	send := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/guardian/confirm", nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+
+		rr := httptest.NewRecorder()
+		server.handleConfirm(rr, req)
+		return rr
+	}
+
+	if rr := send("POST"); rr.Code != http.StatusNotFound {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusNotFound, rr.Code)
+	}
+
+	pending, err := commit.Enable(mockFw, time.Minute, filepath.Join(t.TempDir(), "confirm"))
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	server.SetCommit(pending)
+
+	rr := send("GET")
+	var resp ConfirmResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || resp.Status.State != commit.StatePending {
+		t.Errorf("Estado inesperado (%d): %+v", rr.Code, resp.Status)
+	}
+
+	if rr := send("POST"); rr.Code != http.StatusOK {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusOK, rr.Code)
+	}
+	if s := pending.Status(); s.State != commit.StateConfirmed {
+		t.Errorf("Estado inesperado: %+v", s)
+	}
+	if rr := send("POST"); rr.Code != http.StatusConflict {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusConflict, rr.Code)
+	}
+}
+
+// TestHandleStatus testa o endpoint de status com o diagnóstico da detecção
+func TestHandleStatus(t *testing.T) {
+	cfg := &config.Config{
+		IP:         "127.0.0.1",
+		Port:       4554,
+		AuthToken:  "test-token",
+		BanProfile: config.DefaultBanProfile(),
+	}
+	server := NewServer(cfg, firewall.NewMockFirewall(), nil, nil)
+	server.SetDetection(&firewall.Detection{
+		Configured: "auto",
+		Selected:   "firewalld",
+		Ambiguous:  true,
+		Warnings:   []string{"mais de um gerenciador de firewall ativo (firewalld, ufw)"},
+	})
+
+	req := httptest.NewRequest("GET", "/guardian/status", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	server.handleStatus(rr, req)
+
+	var resp StatusResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Resposta inválida (%d): %s", rr.Code, rr.Body.String())
+	}
+	if rr.Code != http.StatusOK || resp.Firewall != "mock" || resp.Detection == nil || !resp.Detection.Ambiguous {
+		t.Errorf("Status inesperado (%d): %+v", rr.Code, resp)
+	}
+
+	req = httptest.NewRequest("GET", "/guardian/status", nil)
+	rr = httptest.NewRecorder()
+	server.handleStatus(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+// TestHandleCounters testa o filtro dos banimentos pelo tráfego bloqueado
+func TestHandleCounters(t *testing.T) {
+	cfg := &config.Config{
+		IP:         "127.0.0.1",
+		Port:       4554,
+		AuthToken:  "test-token",
+		BanProfile: config.DefaultBanProfile(),
+	}
+	mockFw := firewall.NewMockFirewall()
+	mockFw.BanIP("203.0.113.7", cfg.BanProfile)
+	mockFw.BanIP("203.0.113.8", cfg.BanProfile)
+	server := NewServer(cfg, mockFw, nil, nil)
+
+	send := func(query string) (*httptest.ResponseRecorder, CountersResponse) {
+		req := httptest.NewRequest("GET", "/guardian/counters"+query, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		server.handleCounters(rr, req)
+
+		var resp CountersResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr, resp
+	}
+
+	if rr, _ := send(""); rr.Code != http.StatusNotFound {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusNotFound, rr.Code)
+	}
+
+	tracker, err := hits.NewTracker(filepath.Join(t.TempDir(), "hits.json"), mockFw)
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	server.SetHits(tracker)
+
+	// A primeira leitura serve de referência; o aumento seguinte é um bloqueio
+	if rr, resp := send(""); rr.Code != http.StatusOK || len(resp.Counters) != 2 {
+		t.Errorf("Resposta inesperada (%d): %+v", rr.Code, resp)
+	}
+	mockFw.SetCounters("203.0.113.7", 3, 180)
+
+	rr, resp := send("?hit_within=24h")
+	if rr.Code != http.StatusOK || len(resp.Counters) != 1 || resp.Counters[0].IP != "203.0.113.7" {
+		t.Errorf("Esperado apenas 203.0.113.7 (%d): %+v", rr.Code, resp)
+	}
+	rr, resp = send("?never_hit=true")
+	if rr.Code != http.StatusOK || len(resp.Counters) != 1 || resp.Counters[0].IP != "203.0.113.8" {
+		t.Errorf("Esperado apenas 203.0.113.8 (%d): %+v", rr.Code, resp)
+	}
+
+	// Contadores zerados pela recriação das regras não apagam o bloqueio
+	mockFw.SetCounters("203.0.113.7", 0, 0)
+	rr, resp = send("?never_hit=true")
+	if rr.Code != http.StatusOK || len(resp.Counters) != 1 || resp.Counters[0].IP != "203.0.113.8" {
+		t.Errorf("Esperado apenas 203.0.113.8 depois de zerar os contadores (%d): %+v", rr.Code, resp)
+	}
+	if rr, _ := send("?hit_within=ontem"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// TestHandleExportImport testa a exportação e a importação pela API
+func TestHandleExportImport(t *testing.T) {
+	cfg := &config.Config{
+		IP:         "127.0.0.1",
+		Port:       4554,
+		AuthToken:  "test-token",
+		BanProfile: config.DefaultBanProfile(),
+	}
+	source := firewall.NewMockFirewall()
+	source.BanIP("203.0.113.7", cfg.BanProfile)
+
+	req := httptest.NewRequest("GET", "/guardian/export", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	NewServer(cfg, source, nil, nil).handleExport(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Status code esperado: %d, obtido: %d (%s)", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	target := firewall.NewMockFirewall()
+	server := NewServer(cfg, target, nil, nil)
+	req = httptest.NewRequest("POST", "/guardian/import", bytes.NewReader(rr.Body.Bytes()))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr = httptest.NewRecorder()
+	server.handleImport(rr, req)
+
+	var resp ImportResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if rr.Code != http.StatusOK || !resp.Success || len(resp.Result.Banned) != 1 {
+		t.Errorf("Resposta inesperada (%d): %+v", rr.Code, resp)
+	}
+	if banned, _ := target.IsBanned("203.0.113.7"); !banned {
+		t.Error("IP deveria ter sido banido na importação")
+	}
+
+	req = httptest.NewRequest("POST", "/guardian/import", strings.NewReader(`{"version": 99}`))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr = httptest.NewRecorder()
+	server.handleImport(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// TestHandleGuardianLedger testa o registro dos banimentos feitos pela API
+func TestHandleGuardianLedger(t *testing.T) {
+	cfg := &config.Config{
+		IP:         "127.0.0.1",
+		Port:       4554,
+		AuthToken:  "test-token",
+		BanProfile: config.DefaultBanProfile(),
+	}
+	led, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.json"))
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	server := NewServer(cfg, firewall.NewMockFirewall(), nil, nil)
+	server.SetLedger(led)
+
+	send := func(req Request) {
+		data, _ := json.Marshal(req)
+		r := httptest.NewRequest("POST", "/guardian", bytes.NewReader(data))
+		r.RemoteAddr = "198.51.100.2:40000"
+		r.Header.Set("Authorization", "Bearer test-token")
+
+		rr := httptest.NewRecorder()
+		server.handleGuardian(rr, r)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Status code esperado: %d, obtido: %d (%s)", http.StatusOK, rr.Code, rr.Body.String())
+		}
+	}
+
+	send(Request{Acao: "banir", IP: "203.0.113.7", Motivo: "varredura de portas"})
+	r, ok := led.Get("203.0.113.7")
+	if !ok || r.Source != ledger.SourceAPI || r.Reason != "varredura de portas" || r.Creator != "198.51.100.2" {
+		t.Errorf("Registro inesperado: %+v", r)
+	}
+
+	send(Request{Acao: "desbanir", IP: "203.0.113.7"})
+	if _, ok := led.Get("203.0.113.7"); ok {
+		t.Error("Registro deveria ter sido encerrado no desbanimento")
+	}
+	if records := led.Records(); len(records) != 1 || records[0].Status != ledger.StatusRemoved {
+		t.Errorf("Histórico inesperado: %+v", records)
+	}
+}
+
+// TestHandleReconcile testa o relatório de divergências da reconciliação
+func TestHandleReconcile(t *testing.T) {
+	cfg := &config.Config{
+		IP:         "127.0.0.1",
+		Port:       4554,
+		AuthToken:  "test-token",
+		BanProfile: config.DefaultBanProfile(),
+	}
+	mockFw := firewall.NewMockFirewall()
+	server := NewServer(cfg, mockFw, nil, nil)
+
+	send := func(method string) (*httptest.ResponseRecorder, ReconcileResponse) {
+		req := httptest.NewRequest(method, "/guardian/reconcile", nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		server.handleReconcile(rr, req)
+
+		var resp ReconcileResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr, resp
+	}
+
+	if rr, _ := send("GET"); rr.Code != http.StatusNotFound {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusNotFound, rr.Code)
+	}
+
+	led, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.json"))
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	led.Add(ledger.Record{IP: "203.0.113.7", Action: config.ActionDrop, Source: ledger.SourceAPI})
+	server.SetReconciler(reconcile.New(mockFw, led, nil, config.OrphansFlag))
+
+	// Sem reconciliação anterior, o GET executa uma
+	rr, resp := send("GET")
+	if rr.Code != http.StatusOK || resp.Report == nil || len(resp.Report.Drifts) != 1 || resp.Report.Drifts[0].Kind != reconcile.KindMissing {
+		t.Fatalf("Resposta inesperada (%d): %s", rr.Code, rr.Body.String())
+	}
+	if banned, _ := mockFw.IsBanned("203.0.113.7"); !banned {
+		t.Error("Banimento ausente deveria ter sido reaplicado")
+	}
+
+	if rr, resp := send("POST"); rr.Code != http.StatusOK || len(resp.Report.Drifts) != 0 {
+		t.Errorf("Resposta inesperada (%d): %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,300 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mtm/guardian/internal/firewall"
+	"github.com/mtm/guardian/internal/ledger"
+)
+
+// BanRequest representa a criação de um banimento em POST /v1/bans
+type BanRequest struct {
+	IP string `json:"ip"`
+	// Duration opcional do banimento (ex.: "30m", "24h", "7d"). Sem duração
+	// o banimento é permanente.
+	Duration string `json:"duration,omitempty"`
+	// Perfil opcional do banimento. Campos omitidos usam o perfil configurado
+	// (GUARDIAN_BAN_*).
+	Ports     string `json:"ports,omitempty"`     // ex.: "22,80" ou "all"
+	Protocols string `json:"protocols,omitempty"` // ex.: "tcp", "tcp,udp" ou "all"
+	Action    string `json:"action,omitempty"`    // drop, reject ou tarpit
+	// Force confirma um banimento que bloquearia a conexão de quem faz a
+	// requisição ou sessões SSH abertas
+	Force bool `json:"force,omitempty"`
+	// KillConnections encerra as conexões já estabelecidas do IP ao bani-lo.
+	// Omitido, vale GUARDIAN_KILL_CONNECTIONS.
+	KillConnections *bool `json:"kill_connections,omitempty"`
+	// Reason e Creator opcionais, gravados no registro de banimentos. Sem
+	// autor, é registrado o endereço de quem faz a requisição.
+	Reason  string `json:"reason,omitempty"`
+	Creator string `json:"creator,omitempty"`
+	// Source opcional gravado no registro de banimentos: api (padrão) ou
+	// feed, para os banimentos enviados por importadores de listas de
+	// bloqueio
+	Source string `json:"source,omitempty"`
+}
+
+// banInput converte a criação de um banimento
+func (req BanRequest) banInput() banInput {
+	return banInput{
+		IP:              req.IP,
+		Duration:        req.Duration,
+		Ports:           req.Ports,
+		Protocols:       req.Protocols,
+		Action:          req.Action,
+		Force:           req.Force,
+		KillConnections: req.KillConnections,
+		Reason:          req.Reason,
+		Creator:         req.Creator,
+		Source:          req.Source,
+	}
+}
+
+// BanResource representa um banimento no firewall, com a expiração agendada
+// e os dados do registro de banimentos, quando houver
+type BanResource struct {
+	IP        string     `json:"ip"`
+	Ports     []int      `json:"ports,omitempty"`     // Vazio quando todas as portas são bloqueadas
+	Protocols []string   `json:"protocols,omitempty"` // Vazio quando todos os protocolos são bloqueados
+	Action    string     `json:"action"`
+	Family    string     `json:"family,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Source    string     `json:"source,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	Creator   string     `json:"creator,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// BanResponse representa um banimento retornado por /v1/bans
+type BanResponse struct {
+	Success bool         `json:"success"`
+	Ban     *BanResource `json:"ban"`
+	// KilledConnections é o número de conexões estabelecidas encerradas na
+	// criação do banimento (em dry-run, as que seriam encerradas)
+	KilledConnections *int `json:"killed_connections,omitempty"`
+	// Warning informa uma falha que não impediu o banimento
+	Warning string `json:"warning,omitempty"`
+}
+
+// BanListResponse representa uma página da lista de banimentos retornada
+// por /v1/bans
+type BanListResponse struct {
+	Success bool          `json:"success"`
+	Bans    []BanResource `json:"bans"`
+	// Total é o número de banimentos que atendem aos filtros, em todas as
+	// páginas
+	Total int `json:"total"`
+	// NextCursor, presente quando há mais páginas, é passado em cursor para
+	// obter a próxima
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ErrorResponse representa um erro retornado pelos endpoints /v1
+type ErrorResponse struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+}
+
+// handleV1Bans lista (GET) e cria (POST) banimentos
+func (s *Server) handleV1Bans(w http.ResponseWriter, r *http.Request) {
+	if !s.validateToken(r.Header.Get("Authorization")) {
+		writeError(w, http.StatusUnauthorized, "Não autorizado")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.listBans(w, r)
+	case http.MethodPost:
+		s.createBan(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "Método não permitido")
+	}
+}
+
+// handleV1Ban consulta (GET) e remove (DELETE) o banimento de um IP ou rede.
+// O alvo é todo o restante do caminho, para que redes possam ser informadas
+// sem codificar a barra (/v1/bans/203.0.113.0/24).
+func (s *Server) handleV1Ban(w http.ResponseWriter, r *http.Request) {
+	raw := strings.TrimPrefix(r.URL.Path, "/v1/bans/")
+	if raw == "" {
+		s.handleV1Bans(w, r)
+		return
+	}
+
+	if !s.validateToken(r.Header.Get("Authorization")) {
+		writeError(w, http.StatusUnauthorized, "Não autorizado")
+		return
+	}
+
+	target, err := firewall.NormalizeTarget(raw)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Endereço IP ou rede inválidos")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ban, err := s.lookup(target)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		if ban == nil {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("%s não está banido", target))
+			return
+		}
+		writeJSON(w, http.StatusOK, BanResponse{Success: true, Ban: ban})
+	case http.MethodDelete:
+		banned, err := s.fw.IsBanned(target)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		if !banned {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("%s não está banido", target))
+			return
+		}
+		// Um endereço contido em uma rede banida resulta em conflito
+		if err := s.unban(target); err != nil {
+			writeFailure(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "Método não permitido")
+	}
+}
+
+// createBan aplica o banimento de POST /v1/bans. Diferente do endpoint
+// legado, um alvo já banido não é substituído: resulta em conflito.
+func (s *Server) createBan(w http.ResponseWriter, r *http.Request) {
+	var req BanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Formato de requisição inválido")
+		return
+	}
+	if req.IP == "" {
+		writeError(w, http.StatusBadRequest, "Campo 'ip' é obrigatório")
+		return
+	}
+	target, err := firewall.NormalizeTarget(req.IP)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Endereço IP ou rede inválidos")
+		return
+	}
+
+	existing, err := s.lookup(target)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	if existing != nil {
+		writeError(w, http.StatusConflict, fmt.Sprintf("%s já está banido", target))
+		return
+	}
+
+	req.IP = target
+	result, err := s.ban(r, req.banInput())
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	resp := BanResponse{Success: true, KilledConnections: result.Killed}
+	if resp.Ban, err = s.lookup(target); err != nil || resp.Ban == nil {
+		// O banimento foi aplicado; sem a listagem, é descrito pelo perfil
+		resp.Ban = &BanResource{
+			IP:        target,
+			Ports:     result.Profile.Ports,
+			Protocols: result.Profile.Protocols,
+			Action:    result.Profile.Action,
+			ExpiresAt: result.ExpiresAt,
+		}
+	}
+	if result.KillErr != nil {
+		resp.Warning = fmt.Sprintf("não foi possível encerrar as conexões estabelecidas: %v", result.KillErr)
+	}
+
+	w.Header().Set("Location", "/v1/bans/"+target)
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// lookup retorna o banimento do próprio alvo no firewall, ou nil se ele não
+// estiver banido
+func (s *Server) lookup(target string) (*BanResource, error) {
+	bans, err := s.fw.ListBanned()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao listar banimentos: %w", err)
+	}
+	if ban := firewall.FindBan(bans, target); ban != nil {
+		resource := s.resource(*ban, s.activeRecords())
+		return &resource, nil
+	}
+	return nil, nil
+}
+
+// activeRecords indexa os registros ativos do registro de banimentos pelo
+// alvo, para descrever vários banimentos com uma única consulta
+func (s *Server) activeRecords() map[string]ledger.Record {
+	records := make(map[string]ledger.Record)
+	if s.ledger != nil {
+		for _, rec := range s.ledger.Active() {
+			records[rec.IP] = rec
+		}
+	}
+	return records
+}
+
+// resource descreve um banimento do firewall com a expiração agendada e os
+// dados do registro ativo do alvo em records
+func (s *Server) resource(ban firewall.Ban, records map[string]ledger.Record) BanResource {
+	resource := BanResource{
+		IP:        ban.IP,
+		Ports:     ban.Ports,
+		Protocols: ban.Protocols,
+		Action:    ban.Action,
+		Family:    ban.Family,
+	}
+	target, err := firewall.NormalizeTarget(ban.IP)
+	if err != nil {
+		return resource
+	}
+	resource.IP = target
+
+	if s.expiry != nil {
+		if at, ok := s.expiry.ExpiresAt(target); ok {
+			resource.ExpiresAt = &at
+		}
+	}
+	if rec, ok := records[target]; ok {
+		resource.Source = rec.Source
+		resource.Reason = rec.Reason
+		resource.Creator = rec.Creator
+		createdAt := rec.CreatedAt
+		resource.CreatedAt = &createdAt
+	}
+	return resource
+}
+
+// writeFailure envia o erro de um banimento ou desbanimento com o status
+// correspondente
+func writeFailure(w http.ResponseWriter, err error) {
+	status := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("Erro ao processar a solicitação: %v", err)
+	}
+	writeError(w, status, err.Error())
+}
+
+// writeError envia um erro no formato JSON dos endpoints /v1
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, ErrorResponse{Success: false, Error: message})
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mtm/guardian/internal/config"
+	"github.com/mtm/guardian/internal/expiry"
+	"github.com/mtm/guardian/internal/firewall"
+	"github.com/mtm/guardian/internal/ledger"
+)
+
+// newV1Server cria um servidor com firewall simulado, agendador de expiração
+// e registro de banimentos, acessado pelas rotas da API
+func newV1Server(t *testing.T) (*firewall.MockFirewall, http.Handler) {
+	t.Helper()
+	cfg := &config.Config{
+		IP:         "127.0.0.1",
+		Port:       4554,
+		AuthToken:  "test-token",
+		BanProfile: config.DefaultBanProfile(),
+	}
+	mockFw := firewall.NewMockFirewall()
+	sched, err := expiry.NewScheduler(filepath.Join(t.TempDir(), "expiry.json"), mockFw)
+	if err != nil {
+		t.Fatalf("Erro ao criar agendador: %v", err)
+	}
+	led, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.json"))
+	if err != nil {
+		t.Fatalf("Erro ao abrir registro: %v", err)
+	}
+	server := NewServer(cfg, mockFw, sched, nil)
+	server.SetLedger(led)
+	return mockFw, server.routes()
+}
+
+// call envia uma requisição autenticada às rotas da API
+func call(handler http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	r := httptest.NewRequest(method, path, bytes.NewReader(data))
+	r.RemoteAddr = "198.51.100.2:40000"
+	r.Header.Set("Authorization", "Bearer test-token")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, r)
+	return rr
+}
+
+// TestV1Bans testa a criação, consulta, listagem e remoção de banimentos
+// pelo recurso /v1/bans
+func TestV1Bans(t *testing.T) {
+	mockFw, handler := newV1Server(t)
+
+	rr := call(handler, "POST", "/v1/bans", BanRequest{IP: "203.0.113.7", Duration: "1h", Ports: "22", Reason: "varredura de portas"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Status code esperado: %d, obtido: %d (%s)", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if location := rr.Header().Get("Location"); location != "/v1/bans/203.0.113.7" {
+		t.Errorf("Location inesperado: %s", location)
+	}
+	var resp BanResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Ban == nil || resp.Ban.IP != "203.0.113.7" || resp.Ban.ExpiresAt == nil ||
+		resp.Ban.Reason != "varredura de portas" || resp.Ban.Creator != "198.51.100.2" || len(resp.Ban.Ports) != 1 {
+		t.Errorf("Banimento inesperado: %s", rr.Body.String())
+	}
+
+	// Um alvo já banido não é substituído
+	if rr := call(handler, "POST", "/v1/bans", BanRequest{IP: "203.0.113.7"}); rr.Code != http.StatusConflict {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusConflict, rr.Code)
+	}
+	if rr := call(handler, "POST", "/v1/bans", BanRequest{IP: "invalido"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// Redes são informadas no caminho sem codificar a barra
+	if rr := call(handler, "POST", "/v1/bans", BanRequest{IP: "198.18.0.7/24"}); rr.Code != http.StatusCreated {
+		t.Fatalf("Status code esperado: %d, obtido: %d (%s)", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	rr = call(handler, "GET", "/v1/bans/198.18.0.0/24", nil)
+	resp = BanResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || resp.Ban == nil || resp.Ban.IP != "198.18.0.0/24" {
+		t.Errorf("Resposta inesperada (%d): %s", rr.Code, rr.Body.String())
+	}
+	if rr := call(handler, "GET", "/v1/bans/203.0.113.8", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusNotFound, rr.Code)
+	}
+
+	rr = call(handler, "GET", "/v1/bans", nil)
+	var list BanListResponse
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if rr.Code != http.StatusOK || len(list.Bans) != 2 {
+		t.Errorf("Resposta inesperada (%d): %s", rr.Code, rr.Body.String())
+	}
+
+	// Um endereço contido em uma rede banida não pode ser removido sozinho
+	if rr := call(handler, "DELETE", "/v1/bans/198.18.0.9", nil); rr.Code != http.StatusConflict {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusConflict, rr.Code)
+	}
+	if rr := call(handler, "DELETE", "/v1/bans/203.0.113.7", nil); rr.Code != http.StatusNoContent {
+		t.Errorf("Status code esperado: %d, obtido: %d (%s)", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+	if banned, _ := mockFw.IsBanned("203.0.113.7"); banned {
+		t.Error("IP deveria ter sido desbanido")
+	}
+	if rr := call(handler, "DELETE", "/v1/bans/203.0.113.7", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusNotFound, rr.Code)
+	}
+
+	if rr := call(handler, "PUT", "/v1/bans", nil); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+// TestV1BansLegacy testa que o endpoint legado continua funcionando sobre o
+// mesmo tratamento
+func TestV1BansLegacy(t *testing.T) {
+	_, handler := newV1Server(t)
+
+	if rr := call(handler, "POST", "/guardian", Request{Acao: "banir", IP: "203.0.113.7", Motivo: "legado"}); rr.Code != http.StatusOK {
+		t.Fatalf("Status code esperado: %d, obtido: %d (%s)", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	rr := call(handler, "GET", "/v1/bans/203.0.113.7", nil)
+	var resp BanResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || resp.Ban == nil || resp.Ban.Source != ledger.SourceAPI || resp.Ban.Reason != "legado" {
+		t.Errorf("Resposta inesperada (%d): %s", rr.Code, rr.Body.String())
+	}
+
+	// O endpoint legado substitui o perfil de um alvo já banido
+	if rr := call(handler, "POST", "/guardian", Request{Acao: "banir", IP: "203.0.113.7", Portas: "80"}); rr.Code != http.StatusOK {
+		t.Errorf("Status code esperado: %d, obtido: %d (%s)", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if rr := call(handler, "POST", "/guardian", Request{Acao: "desbanir", IP: "203.0.113.7"}); rr.Code != http.StatusOK {
+		t.Errorf("Status code esperado: %d, obtido: %d (%s)", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if rr := call(handler, "GET", "/v1/bans/203.0.113.7", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Status code esperado: %d, obtido: %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// TestV1BanRollback testa que um banimento cujo registro não pôde ser
+// gravado é desfeito no firewall, na requisição individual e no lote
+func TestV1BanRollback(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{IP: "127.0.0.1", Port: 4554, AuthToken: "test-token", BanProfile: config.DefaultBanProfile()}
+	mockFw := firewall.NewMockFirewall()
+	sched, err := expiry.NewScheduler(filepath.Join(dir, "expiry.json"), mockFw)
+	if err != nil {
+		t.Fatalf("Erro ao criar agendador: %v", err)
+	}
+	led, err := ledger.Open(filepath.Join(dir, "ledger", "ledger.json"))
+	if err != nil {
+		t.Fatalf("Erro ao abrir registro: %v", err)
+	}
+	server := NewServer(cfg, mockFw, sched, nil)
+	server.SetLedger(led)
+	handler := server.routes()
+
+	// Um arquivo no lugar do diretório impede a gravação do registro
+	if err := os.WriteFile(filepath.Join(dir, "ledger"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := call(handler, "POST", "/v1/bans", BanRequest{IP: "203.0.113.7", Duration: "1h"}); rr.Code != http.StatusInternalServerError {
+		t.Errorf("Status code esperado: %d, obtido: %d (%s)", http.StatusInternalServerError, rr.Code, rr.Body.String())
+	}
+	rr := call(handler, "POST", "/v1/bans:batch", BatchRequest{Entries: []BatchEntry{{BanRequest: BanRequest{IP: "203.0.113.8"}}}})
+	var resp BatchResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Success || len(resp.Results) != 1 || resp.Results[0].Status != http.StatusInternalServerError {
+		t.Errorf("Resposta inesperada: %s", rr.Body.String())
+	}
+
+	for _, ip := range []string{"203.0.113.7", "203.0.113.8"} {
+		if banned, _ := mockFw.IsBanned(ip); banned {
+			t.Errorf("O banimento de %s deveria ter sido desfeito", ip)
+		}
+		if _, ok := sched.ExpiresAt(ip); ok {
+			t.Errorf("A expiração de %s deveria ter sido cancelada", ip)
+		}
+	}
+}
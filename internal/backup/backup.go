@@ -0,0 +1,329 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mtm/guardian/internal/allowlist"
+	"github.com/mtm/guardian/internal/expiry"
+	"github.com/mtm/guardian/internal/firewall"
+	"github.com/mtm/guardian/internal/ledger"
+)
+
+// Version é a versão do formato do documento de exportação
+const Version = 1
+
+// Document é uma cópia de tudo o que o Guardian gerencia, independente do
+// backend: os banimentos com seus perfis, expirações e dados do registro
+// local e a lista de permitidos. Ao contrário do firewall.Snapshot, que
+// guarda as regras do próprio backend, o documento pode ser importado em
+// qualquer firewall.
+type Document struct {
+	Version   int               `json:"version"`
+	CreatedAt time.Time         `json:"created_at"`
+	Hostname  string            `json:"hostname,omitempty"`
+	Firewall  string            `json:"firewall"` // Backend de origem, apenas informativo
+	Bans      []Ban             `json:"bans"`
+	Allowlist []allowlist.Entry `json:"allowlist"`
+}
+
+// Ban é um banimento exportado: o alvo, o perfil, a expiração e os dados do
+// registro local, sem os identificadores das regras do backend
+type Ban struct {
+	IP        string     `json:"ip"`
+	Ports     []int      `json:"ports,omitempty"`     // Vazio quando todas as portas são bloqueadas
+	Protocols []string   `json:"protocols,omitempty"` // Vazio quando todos os protocolos são bloqueados
+	Action    string     `json:"action"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Ausente para banimentos permanentes
+	// Dados do registro local, ausentes para banimentos sem registro
+	Source    string     `json:"source,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	Creator   string     `json:"creator,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// Profile retorna o perfil do banimento normalizado, como os perfis
+// recebidos pela API: ação e protocolos em minúsculas, portas e protocolos
+// ordenados e sem repetições. Perfis inconsistentes retornam erro.
+func (b Ban) Profile() (firewall.BanProfile, error) {
+	profile := firewall.BanProfile{Ports: b.Ports, Action: strings.ToLower(b.Action)}
+	for _, proto := range b.Protocols {
+		profile.Protocols = append(profile.Protocols, strings.ToLower(proto))
+	}
+	return profile.With("", "", "")
+}
+
+// Issue é um item do documento que não foi importado e o motivo
+type Issue struct {
+	IP     string `json:"ip"`
+	Reason string `json:"reason"`
+}
+
+// Result resume o que a importação alterou
+type Result struct {
+	Banned   []string `json:"banned"`
+	Unbanned []string `json:"unbanned,omitempty"` // Removidos no modo de substituição
+	Allowed  []string `json:"allowed,omitempty"`  // Entradas adicionadas à lista de permitidos
+	Removed  []string `json:"removed,omitempty"`  // Entradas removidas no modo de substituição
+	Skipped  []Issue  `json:"skipped,omitempty"`
+	Failed   []Issue  `json:"failed,omitempty"`
+}
+
+// State reúne o que o Guardian gerencia. Apenas o firewall é obrigatório.
+type State struct {
+	Firewall  firewall.Firewall
+	Allowlist *allowlist.List
+	Expiry    *expiry.Scheduler
+	Ledger    *ledger.Ledger
+}
+
+// Export monta o documento com os banimentos presentes no firewall, as
+// expirações do agendador, os dados do registro local e as entradas da
+// lista de permitidos
+func Export(state State) (*Document, error) {
+	fw, allow, sched := state.Firewall, state.Allowlist, state.Expiry
+	bans, err := fw.ListBanned()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao listar banimentos: %w", err)
+	}
+
+	doc := &Document{
+		Version:   Version,
+		CreatedAt: time.Now().UTC(),
+		Firewall:  fw.Type(),
+		Bans:      []Ban{},
+		Allowlist: []allowlist.Entry{},
+	}
+	doc.Hostname, _ = os.Hostname()
+
+	for _, b := range bans {
+		ban := Ban{IP: b.IP, Ports: b.Ports, Protocols: b.Protocols, Action: b.Action}
+		if sched != nil {
+			if at, ok := sched.ExpiresAt(b.IP); ok {
+				ban.ExpiresAt = &at
+			}
+		}
+		if state.Ledger != nil {
+			if r, ok := state.Ledger.Get(b.IP); ok {
+				created := r.CreatedAt
+				ban.Source, ban.Reason, ban.Creator, ban.CreatedAt = r.Source, r.Reason, r.Creator, &created
+			}
+		}
+		doc.Bans = append(doc.Bans, ban)
+	}
+	if allow != nil {
+		doc.Allowlist = allow.Entries()
+	}
+
+	return doc, nil
+}
+
+// Read decodifica e valida um documento de exportação
+func Read(r io.Reader) (*Document, error) {
+	var doc Document
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("erro ao decodificar documento: %w", err)
+	}
+	if err := doc.Validate(); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Validate confere a versão do documento e os alvos dos banimentos e da
+// lista de permitidos
+func (d *Document) Validate() error {
+	if d.Version < 1 || d.Version > Version {
+		return fmt.Errorf("versão do documento não suportada: %d", d.Version)
+	}
+	for _, b := range d.Bans {
+		if _, err := firewall.NormalizeTarget(b.IP); err != nil {
+			return fmt.Errorf("banimento inválido no documento: %w", err)
+		}
+		if _, err := b.Profile(); err != nil {
+			return fmt.Errorf("banimento inválido no documento (%s): %w", b.IP, err)
+		}
+	}
+	for _, e := range d.Allowlist {
+		if _, err := firewall.NormalizeTarget(e.Target); err != nil {
+			return fmt.Errorf("entrada inválida na lista de permitidos do documento: %w", err)
+		}
+	}
+	return nil
+}
+
+// Options controla a importação
+type Options struct {
+	// Replace remove os banimentos e as entradas da lista de permitidos
+	// adicionadas pela API que não estão no documento, tornando o estado
+	// igual ao exportado. Sem ele, o documento é acrescentado ao estado atual.
+	Replace bool
+	// Now é o instante usado para descartar banimentos já expirados (zero
+	// usa o horário atual)
+	Now time.Time
+}
+
+// Import aplica o documento através do firewall do estado, que pode ser
+// qualquer backend: a lista de permitidos primeiro, para que a proteção
+// valha para os banimentos importados, e depois os banimentos, com suas
+// expirações e seus dados no registro local. Apenas as entradas da lista
+// adicionadas pela API são importadas; as do sistema e da configuração
+// pertencem ao servidor de origem. Falhas em itens isolados são registradas
+// no resultado e não interrompem a importação.
+func Import(doc *Document, state State, opts Options) (*Result, error) {
+	fw, allow, sched := state.Firewall, state.Allowlist, state.Expiry
+	if err := doc.Validate(); err != nil {
+		return nil, err
+	}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	result := &Result{Banned: []string{}}
+
+	if allow != nil {
+		importAllowlist(doc, allow, opts, result)
+	}
+
+	if opts.Replace {
+		if err := removeExtraBans(doc, state, result); err != nil {
+			return result, err
+		}
+	}
+
+	for _, b := range doc.Bans {
+		ip, _ := firewall.NormalizeTarget(b.IP)
+		if b.ExpiresAt != nil && !b.ExpiresAt.After(now) {
+			result.Skipped = append(result.Skipped, Issue{IP: ip, Reason: "banimento expirado em " + b.ExpiresAt.Format(time.RFC3339)})
+			continue
+		}
+		if b.ExpiresAt != nil && sched == nil {
+			result.Failed = append(result.Failed, Issue{IP: ip, Reason: "banimentos temporários não estão disponíveis"})
+			continue
+		}
+
+		profile, _ := b.Profile()
+		if err := fw.BanIP(ip, profile); err != nil {
+			result.Failed = append(result.Failed, Issue{IP: ip, Reason: err.Error()})
+			continue
+		}
+
+		var err error
+		if sched != nil && b.ExpiresAt != nil {
+			err = sched.Schedule(ip, b.ExpiresAt.UTC())
+		} else if sched != nil {
+			err = sched.Cancel(ip)
+		}
+		if err == nil && state.Ledger != nil {
+			err = record(state.Ledger, ip, b)
+		}
+		if err != nil {
+			result.Failed = append(result.Failed, Issue{IP: ip, Reason: fmt.Sprintf("banido, mas a expiração ou o registro não foram gravados: %v", err)})
+			continue
+		}
+		result.Banned = append(result.Banned, ip)
+	}
+
+	return result, nil
+}
+
+// importAllowlist adiciona as entradas da API do documento à lista e, no
+// modo de substituição, remove as entradas da API ausentes do documento
+func importAllowlist(doc *Document, allow *allowlist.List, opts Options, result *Result) {
+	wanted := make(map[string]bool)
+	for _, e := range doc.Allowlist {
+		target, _ := firewall.NormalizeTarget(e.Target)
+		if e.Source != allowlist.SourceAPI {
+			result.Skipped = append(result.Skipped, Issue{IP: target, Reason: fmt.Sprintf("entrada da lista de permitidos de origem %q no servidor exportado", e.Source)})
+			continue
+		}
+		wanted[target] = true
+
+		if _, err := allow.Add(target, e.Comment); err != nil {
+			result.Failed = append(result.Failed, Issue{IP: target, Reason: err.Error()})
+			continue
+		}
+		result.Allowed = append(result.Allowed, target)
+	}
+
+	if !opts.Replace {
+		return
+	}
+	for _, e := range allow.Entries() {
+		if e.Source != allowlist.SourceAPI || wanted[e.Target] {
+			continue
+		}
+		if err := allow.Remove(e.Target); err != nil {
+			result.Failed = append(result.Failed, Issue{IP: e.Target, Reason: err.Error()})
+			continue
+		}
+		result.Removed = append(result.Removed, e.Target)
+	}
+}
+
+// record grava no registro local um banimento importado, mantendo a origem,
+// o motivo, o autor e a data do servidor exportado
+func record(l *ledger.Ledger, ip string, b Ban) error {
+	r := ledger.Record{
+		IP:        ip,
+		Ports:     b.Ports,
+		Protocols: b.Protocols,
+		Action:    b.Action,
+		Source:    b.Source,
+		Reason:    b.Reason,
+		Creator:   b.Creator,
+		ExpiresAt: b.ExpiresAt,
+	}
+	if r.Source == "" {
+		r.Source = ledger.SourceImport
+	}
+	if b.CreatedAt != nil {
+		r.CreatedAt = *b.CreatedAt
+	}
+	_, err := l.Add(r)
+	return err
+}
+
+// removeExtraBans remove os banimentos do firewall que não estão no
+// documento, antes de aplicar os dele
+func removeExtraBans(doc *Document, state State, result *Result) error {
+	fw, sched := state.Firewall, state.Expiry
+	wanted := make(map[string]bool)
+	for _, b := range doc.Bans {
+		ip, _ := firewall.NormalizeTarget(b.IP)
+		wanted[ip] = true
+	}
+
+	bans, err := fw.ListBanned()
+	if err != nil {
+		return fmt.Errorf("erro ao listar banimentos: %w", err)
+	}
+	for _, b := range bans {
+		ip, err := firewall.NormalizeTarget(b.IP)
+		if err != nil || wanted[ip] {
+			continue
+		}
+		if err := fw.UnbanIP(ip); err != nil {
+			result.Failed = append(result.Failed, Issue{IP: ip, Reason: err.Error()})
+			continue
+		}
+		if sched != nil {
+			if err := sched.Cancel(ip); err != nil {
+				result.Failed = append(result.Failed, Issue{IP: ip, Reason: err.Error()})
+				continue
+			}
+		}
+		if state.Ledger != nil {
+			if err := state.Ledger.Close(ip, ledger.StatusRemoved); err != nil {
+				result.Failed = append(result.Failed, Issue{IP: ip, Reason: err.Error()})
+				continue
+			}
+		}
+		result.Unbanned = append(result.Unbanned, ip)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mtm/guardian/internal/allowlist"
+	"github.com/mtm/guardian/internal/config"
+	"github.com/mtm/guardian/internal/expiry"
+	"github.com/mtm/guardian/internal/firewall"
+	"github.com/mtm/guardian/internal/ledger"
+)
+
+// newState cria um firewall simulado protegido por uma lista de permitidos,
+// com o agendador de expiração
+func newState(t *testing.T, configured ...string) (*firewall.MockFirewall, firewall.Firewall, *allowlist.List, *expiry.Scheduler) {
+	t.Helper()
+	dir := t.TempDir()
+	allow, err := allowlist.New(filepath.Join(dir, "allowlist.json"), configured, "")
+	if err != nil {
+		t.Fatalf("Erro ao criar lista de permitidos: %v", err)
+	}
+	mock := firewall.NewMockFirewall()
+	fw := allowlist.Protect(mock, allow)
+	sched, err := expiry.NewScheduler(filepath.Join(dir, "expiry.json"), fw)
+	if err != nil {
+		t.Fatalf("Erro ao criar agendador: %v", err)
+	}
+	return mock, fw, allow, sched
+}
+
+// TestExportImport testa a migração dos banimentos e da lista de permitidos
+// para outro firewall
+func TestExportImport(t *testing.T) {
+	_, fw, allow, sched := newState(t, "198.51.100.0/24")
+	profile := config.BanProfile{Ports: []int{22}, Protocols: []string{"tcp"}, Action: config.ActionReject}
+	fw.BanIP("203.0.113.7", profile)
+	fw.BanIP("2001:db8::/48", config.DefaultBanProfile())
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	sched.Schedule("203.0.113.7", expiresAt)
+	allow.Add("198.18.0.0/16", "escritório")
+	led, _ := ledger.Open(filepath.Join(t.TempDir(), "ledger.json"))
+	led.Add(ledger.Record{IP: "203.0.113.7", Action: profile.Action, Source: ledger.SourceDetector, Reason: "5 tentativas"})
+
+	doc, err := Export(State{Firewall: fw, Allowlist: allow, Expiry: sched, Ledger: led})
+	if err != nil {
+		t.Fatalf("Erro ao exportar: %v", err)
+	}
+
+	// O documento passa por JSON, como no arquivo ou na API
+	data, _ := json.Marshal(doc)
+	doc, err = Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Erro ao ler documento: %v", err)
+	}
+
+	mock, fw2, allow2, sched2 := newState(t)
+	led2, _ := ledger.Open(filepath.Join(t.TempDir(), "ledger.json"))
+	result, err := Import(doc, State{Firewall: fw2, Allowlist: allow2, Expiry: sched2, Ledger: led2}, Options{})
+	if err != nil {
+		t.Fatalf("Erro ao importar: %v", err)
+	}
+	if len(result.Banned) != 2 || len(result.Failed) != 0 {
+		t.Errorf("Resultado inesperado: %+v", result)
+	}
+
+	bans, _ := mock.ListBanned()
+	ban := findBan(bans, "203.0.113.7")
+	if ban == nil || ban.Action != config.ActionReject || len(ban.Ports) != 1 || ban.Ports[0] != 22 {
+		t.Errorf("Banimento importado com perfil inesperado: %+v", bans)
+	}
+	if at, ok := sched2.ExpiresAt("203.0.113.7"); !ok || !at.Equal(expiresAt) {
+		t.Errorf("Expiração esperada %s, obtida %s (%v)", expiresAt, at, ok)
+	}
+	if _, ok := sched2.ExpiresAt("2001:db8::/48"); ok {
+		t.Error("Banimento permanente não deveria ter expiração")
+	}
+
+	// Os dados do registro local acompanham o banimento; sem eles, a origem
+	// é a importação
+	if r, ok := led2.Get("203.0.113.7"); !ok || r.Source != ledger.SourceDetector || r.Reason != "5 tentativas" {
+		t.Errorf("Registro importado inesperado: %+v", r)
+	}
+	if r, ok := led2.Get("2001:db8::/48"); !ok || r.Source != ledger.SourceImport {
+		t.Errorf("Registro importado inesperado: %+v", r)
+	}
+
+	// Apenas as entradas da API são importadas; as da configuração são do
+	// servidor de origem
+	if e, ok := allow2.Match("198.18.0.1"); !ok || e.Comment != "escritório" {
+		t.Errorf("Entrada da API não importada: %+v", e)
+	}
+	if _, ok := allow2.Match("198.51.100.1"); ok {
+		t.Error("Entrada da configuração não deveria ser importada")
+	}
+}
+
+// TestImportReplace testa a importação que substitui o estado atual
+func TestImportReplace(t *testing.T) {
+	mock, fw, allow, sched := newState(t)
+	fw.BanIP("203.0.113.50", config.DefaultBanProfile())
+	allow.Add("198.18.0.0/16", "")
+
+	past := time.Now().Add(-time.Hour)
+	doc := &Document{
+		Version: Version,
+		Bans: []Ban{
+			{IP: "203.0.113.7", Action: config.ActionDrop},
+			{IP: "203.0.113.8", Action: config.ActionDrop, ExpiresAt: &past},
+		},
+	}
+
+	result, err := Import(doc, State{Firewall: fw, Allowlist: allow, Expiry: sched}, Options{Replace: true})
+	if err != nil {
+		t.Fatalf("Erro ao importar: %v", err)
+	}
+	if len(result.Unbanned) != 1 || len(result.Removed) != 1 || len(result.Skipped) != 1 {
+		t.Errorf("Resultado inesperado: %+v", result)
+	}
+	if banned, _ := mock.IsBanned("203.0.113.50"); banned {
+		t.Error("Banimento ausente do documento deveria ser removido")
+	}
+	if banned, _ := mock.IsBanned("203.0.113.8"); banned {
+		t.Error("Banimento expirado não deveria ser importado")
+	}
+	if banned, _ := mock.IsBanned("203.0.113.7"); !banned {
+		t.Error("Banimento do documento deveria ser aplicado")
+	}
+	if _, ok := allow.Match("198.18.0.1"); ok {
+		t.Error("Entrada ausente do documento deveria ser removida")
+	}
+
+	doc.Version = Version + 1
+	if _, err := Import(doc, State{Firewall: fw, Allowlist: allow, Expiry: sched}, Options{}); err == nil {
+		t.Error("Deveria recusar uma versão desconhecida do documento")
+	}
+
+	// O perfil do documento é normalizado antes do banimento, e um perfil
+	// inválido recusa o documento
+	doc = &Document{Version: Version, Bans: []Ban{{IP: "203.0.113.9", Ports: []int{443, 22, 443}, Protocols: []string{"TCP"}, Action: "DROP"}}}
+	if _, err := Import(doc, State{Firewall: fw, Allowlist: allow, Expiry: sched}, Options{}); err != nil {
+		t.Fatalf("Erro ao importar: %v", err)
+	}
+	bans, _ := mock.ListBanned()
+	expected := config.BanProfile{Ports: []int{22, 443}, Protocols: []string{"tcp"}, Action: config.ActionDrop}
+	if ban := findBan(bans, "203.0.113.9"); ban == nil || !reflect.DeepEqual(ban.Profile(), expected) {
+		t.Errorf("Banimento inesperado: %+v", ban)
+	}
+	doc.Bans[0].Action = "explodir"
+	if _, err := Import(doc, State{Firewall: fw, Allowlist: allow, Expiry: sched}, Options{}); err == nil {
+		t.Error("Deveria recusar um perfil inválido")
+	}
+}
+
+// findBan procura o banimento do IP na lista
+func findBan(bans []firewall.Ban, ip string) *firewall.Ban {
+	for i := range bans {
+		if bans[i].IP == ip {
+			return &bans[i]
+		}
+	}
+	return nil
+}
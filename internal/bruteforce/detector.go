@@ -2,18 +2,23 @@ package bruteforce
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/netip"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/mtm/guardian/internal/allowlist"
 	"github.com/mtm/guardian/internal/config"
+	"github.com/mtm/guardian/internal/expiry"
+	"github.com/mtm/guardian/internal/firewall"
+	"github.com/mtm/guardian/internal/ledger"
 )
 
 // LoginAttempt representa uma tentativa de login malsucedida
@@ -23,25 +28,64 @@ type LoginAttempt struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// Decisões do detector para um IP detectado
+const (
+	DecisionBan    = "banir"
+	DecisionIgnore = "ignorar"
+	DecisionError  = "erro"
+)
+
+// Decision registra o que o detector decidiu fazer com um IP detectado
+type Decision struct {
+	Time      time.Time  `json:"time"`
+	IP        string     `json:"ip"`
+	Count     int        `json:"count"`
+	Decision  string     `json:"decision"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// KilledConnections são as conexões estabelecidas encerradas no banimento
+	KilledConnections int `json:"killed_connections,omitempty"`
+}
+
 // Detector é responsável por detectar tentativas de força bruta
 type Detector struct {
-	cfg            *config.Config
-	outputFilePath string
-	logFilePath    string
-	minAttempts    int
-	logFile        *os.File
+	cfg               *config.Config
+	fw                firewall.Firewall
+	expiry            *expiry.Scheduler
+	outputFilePath    string
+	logFilePath       string
+	decisionsFilePath string // Decisões gravadas no modo dry-run
+	minAttempts       int
+	logFile           *os.File
+	// killConnections encerra as conexões estabelecidas de um IP banido,
+	// com GUARDIAN_KILL_CONNECTIONS (substituível em testes)
+	killConnections func(ip string, profile firewall.BanProfile) (int, error)
+	// ledger é o registro local dos banimentos (opcional)
+	ledger *ledger.Ledger
 }
 
-// NewDetector cria uma nova instância do detector de força bruta
-func NewDetector(cfg *config.Config) *Detector {
+// NewDetector cria uma nova instância do detector de força bruta. O firewall
+// e o agendador de expiração são usados quando o banimento automático está
+// habilitado (GUARDIAN_DETECTOR_BAN).
+func NewDetector(cfg *config.Config, fw firewall.Firewall, sched *expiry.Scheduler) *Detector {
 	return &Detector{
-		cfg:            cfg,
-		outputFilePath: filepath.Join(cfg.InstallDir, "data", "bruteforce.json"),
-		logFilePath:    filepath.Join(cfg.InstallDir, "data", "bruteforce.log"),
-		minAttempts:    3, // Número mínimo de tentativas para considerar como força bruta
+		cfg:               cfg,
+		fw:                fw,
+		expiry:            sched,
+		outputFilePath:    filepath.Join(cfg.InstallDir, "data", "bruteforce.json"),
+		logFilePath:       filepath.Join(cfg.InstallDir, "data", "bruteforce.log"),
+		decisionsFilePath: filepath.Join(cfg.InstallDir, "data", "dry-run", "decisions.jsonl"),
+		minAttempts:       3, // Número mínimo de tentativas para considerar como força bruta
+		killConnections:   firewall.NewConntrack(firewall.ExecRunner{}, cfg.DryRun).Kill,
 	}
 }
 
+// SetLedger registra o registro local dos banimentos, no qual os
+// banimentos automáticos são gravados
+func (d *Detector) SetLedger(l *ledger.Ledger) {
+	d.ledger = l
+}
+
 // logMessage registra uma mensagem no arquivo de log e no console
 func (d *Detector) logMessage(format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
@@ -195,98 +239,45 @@ func (d *Detector) Detect() error {
 	sudoOutput, _ := sudoCmd.CombinedOutput()
 	d.logMessage("Status do sudo: %s", string(sudoOutput))
 	
-	// Executar o comando lastb com mais detalhes sobre erros
-	d.logMessage("Executando comando lastb com sudo...")
-	
-	// Primeiro, vamos executar apenas 'sudo lastb' para ver se funciona
-	d.logMessage("Executando 'sudo lastb' diretamente para verificar...")
-	directCmd := exec.Command("bash", "-c", "sudo lastb")
-	directOutput, directErr := directCmd.CombinedOutput()
-	if directErr != nil {
-		d.logMessage("ERRO ao executar lastb diretamente: %v", directErr)
-		d.logMessage("Saída do comando direto: %s", string(directOutput))
-	} else {
-		d.logMessage("Comando lastb direto executado com sucesso")
-		d.logMessage("Primeiras 20 linhas da saída do lastb:")
-		lines := strings.Split(string(directOutput), "\n")
-		for i, line := range lines {
-			if i >= 20 {
-				break
-			}
-			d.logMessage("  %s", line)
-		}
-	}
-	
-	// Agora executar o comando completo com pipes
-	d.logMessage("Executando comando completo com pipes...")
-	cmd := exec.Command("bash", "-c", "sudo lastb | awk '{ print $3 }' | sort | uniq -c | sort -nr")
+	// O lastb é executado com -w (nomes completos) e -i (endereços numéricos),
+	// para que endereços IPv6 não sejam truncados nem resolvidos para nomes.
+	// A contagem por IP é feita em Go, sem depender de awk.
+	d.logMessage("Executando 'sudo lastb -w -i'...")
+	cmd := exec.Command("sudo", "lastb", "-w", "-i")
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		d.logMessage("AVISO: erro ao executar comando completo: %v", err)
+		d.logMessage("ERRO ao executar lastb: %v", err)
 		d.logMessage("Saída do comando: %s", string(output))
-		
-		// Tentar executar cada parte do pipe separadamente
-		d.logMessage("Tentando executar cada parte do pipe separadamente...")
-		
-		// Executar lastb e salvar em arquivo temporário
-		tmpFile := "/tmp/lastb_output.txt"
-		tmpCmd := exec.Command("bash", "-c", fmt.Sprintf("sudo lastb > %s", tmpFile))
-		tmpOutput, tmpErr := tmpCmd.CombinedOutput()
-		if tmpErr != nil {
-			d.logMessage("ERRO ao salvar saída do lastb em arquivo: %v", tmpErr)
-			d.logMessage("Saída: %s", string(tmpOutput))
-		} else {
-			d.logMessage("Saída do lastb salva em arquivo temporário")
-			
-			// Processar o arquivo com awk
-			awkCmd := exec.Command("bash", "-c", fmt.Sprintf("cat %s | awk '{ print $3 }' | sort | uniq -c | sort -nr", tmpFile))
-			awkOutput, awkErr := awkCmd.CombinedOutput()
-			if awkErr != nil {
-				d.logMessage("ERRO ao processar arquivo com awk: %v", awkErr)
-				d.logMessage("Saída: %s", string(awkOutput))
-			} else {
-				d.logMessage("Arquivo processado com sucesso")
-				d.logMessage("Saída do processamento: %s", string(awkOutput))
-				// Usar esta saída em vez da original
-				output = awkOutput
-				err = nil
-			}
-		}
-		
-		// Se ainda falhou, usar dados fictícios
-		if err != nil {
-			d.logMessage("Usando dados fictícios devido à falha do lastb")
-			attempts := []LoginAttempt{
-				{
-					IP:        "192.168.1.100",
-					Count:     5,
-					Timestamp: time.Now(),
-				},
-				{
-					IP:        "10.0.0.1",
-					Count:     3,
-					Timestamp: time.Now(),
-				},
-			}
-			return d.saveToJSON(attempts)
+
+		// Se falhou, usar dados fictícios
+		d.logMessage("Usando dados fictícios devido à falha do lastb")
+		attempts := []LoginAttempt{
+			{
+				IP:        "192.168.1.100",
+				Count:     5,
+				Timestamp: time.Now(),
+			},
+			{
+				IP:        "10.0.0.1",
+				Count:     3,
+				Timestamp: time.Now(),
+			},
 		}
-	} else {
-		d.logMessage("Comando completo executado com sucesso")
-		d.logMessage("Saída do comando (primeiras 20 linhas):")
-		lines := strings.Split(string(output), "\n")
-		for i, line := range lines {
-			if i >= 20 {
-				break
-			}
-			d.logMessage("  %s", line)
+		return d.saveToJSON(attempts)
+	}
+
+	d.logMessage("Comando lastb executado com sucesso")
+	d.logMessage("Primeiras 20 linhas da saída do lastb:")
+	for i, line := range strings.Split(string(output), "\n") {
+		if i >= 20 {
+			break
 		}
+		d.logMessage("  %s", line)
 	}
 
 	// Processar a saída
-	attempts, err := d.parseOutput(string(output))
-	if err != nil {
-		return fmt.Errorf("erro ao processar saída: %w", err)
-	}
+	attempts := parseLastb(string(output), time.Now())
+	d.logMessage("Total de IPs com tentativas encontrados: %d", len(attempts))
 
 	// Filtrar apenas tentativas com contagem >= minAttempts
 	var filteredAttempts []LoginAttempt
@@ -296,69 +287,169 @@ func (d *Detector) Detect() error {
 		}
 	}
 
+	// Banir os IPs detectados, se habilitado
+	var decisions []Decision
+	if d.cfg.DetectorBan && d.fw != nil {
+		decisions = d.banAttempts(filteredAttempts)
+	} else {
+		for _, attempt := range filteredAttempts {
+			decisions = append(decisions, Decision{IP: attempt.IP, Count: attempt.Count, Decision: DecisionIgnore, Reason: "banimento automático desabilitado"})
+		}
+	}
+
+	// No modo dry-run, as decisões são gravadas para conferência
+	if d.cfg.DryRun {
+		if err := d.saveDecisions(decisions); err != nil {
+			d.logMessage("Erro ao gravar decisões do dry-run: %v", err)
+		}
+	}
+
 	// Salvar resultado em JSON
 	return d.saveToJSON(filteredAttempts)
 }
 
-// parseOutput converte a saída do comando em uma lista de LoginAttempt
-func (d *Detector) parseOutput(output string) ([]LoginAttempt, error) {
-	lines := strings.Split(strings.TrimSpace(output), "\n")
-	var attempts []LoginAttempt
-	now := time.Now()
-
-	// Regex para extrair contagem e IP
-	re := regexp.MustCompile(`^\s*(\d+)\s+(\S+)`)
+// banAttempts bane os IPs detectados que ainda não estão banidos, com o
+// perfil de banimento configurado, e retorna a decisão tomada para cada um.
+// Com uma duração configurada, o banimento é agendado para expirar.
+func (d *Detector) banAttempts(attempts []LoginAttempt) []Decision {
+	var decisions []Decision
+	for _, attempt := range attempts {
+		decision := Decision{IP: attempt.IP, Count: attempt.Count, Decision: DecisionIgnore}
 
-	d.logMessage("Processando %d linhas de saída", len(lines))
-	for i, line := range lines {
-		// Ignorar linhas vazias
-		if strings.TrimSpace(line) == "" {
+		if !isValidIP(attempt.IP) {
+			decision.Reason = "IP inválido"
+			decisions = append(decisions, decision)
 			continue
 		}
 
-		// Registrar as primeiras 5 linhas para debug
-		if i < 5 {
-			d.logMessage("Processando linha: '%s'", line)
+		banned, err := d.fw.IsBanned(attempt.IP)
+		if err != nil {
+			d.logMessage("Erro ao verificar banimento de %s: %v", attempt.IP, err)
+			decision.Decision, decision.Reason = DecisionError, err.Error()
+			decisions = append(decisions, decision)
+			continue
+		}
+		if banned {
+			decision.Reason = "já banido"
+			decisions = append(decisions, decision)
+			continue
 		}
 
-		matches := re.FindStringSubmatch(line)
-		if len(matches) != 3 {
-			if i < 5 {
-				d.logMessage("Linha não corresponde ao padrão esperado, ignorando")
+		if err := d.fw.BanIP(attempt.IP, d.cfg.BanProfile); err != nil {
+			d.logMessage("Erro ao banir IP %s: %v", attempt.IP, err)
+			decision.Reason = err.Error()
+			var allowed *allowlist.AllowedError
+			if !errors.As(err, &allowed) {
+				decision.Decision = DecisionError
 			}
+			decisions = append(decisions, decision)
 			continue
 		}
+		decision.Decision = DecisionBan
 
-		count, err := strconv.Atoi(matches[1])
-		if err != nil {
-			if i < 5 {
-				d.logMessage("Erro ao converter contagem para número: %v", err)
+		if d.cfg.DetectorBanDuration > 0 && d.expiry != nil {
+			expiresAt := time.Now().Add(d.cfg.DetectorBanDuration).UTC()
+			if err := d.expiry.Schedule(attempt.IP, expiresAt); err != nil {
+				d.logMessage("Erro ao agendar expiração do banimento de %s: %v", attempt.IP, err)
+			}
+			decision.ExpiresAt = &expiresAt
+			d.logMessage("IP %s banido até %s (%d tentativas)", attempt.IP, expiresAt.Format(time.RFC3339), attempt.Count)
+		} else {
+			d.logMessage("IP %s banido permanentemente (%d tentativas)", attempt.IP, attempt.Count)
+		}
+
+		if d.ledger != nil {
+			_, err := d.ledger.Add(ledger.Record{
+				IP:        attempt.IP,
+				Ports:     d.cfg.BanProfile.Ports,
+				Protocols: d.cfg.BanProfile.Protocols,
+				Action:    d.cfg.BanProfile.Action,
+				Source:    ledger.SourceDetector,
+				Reason:    fmt.Sprintf("%d tentativas de login malsucedidas", attempt.Count),
+				Creator:   "detector",
+				ExpiresAt: decision.ExpiresAt,
+			})
+			if err != nil {
+				d.logMessage("Erro ao registrar banimento de %s: %v", attempt.IP, err)
+			}
+		}
+
+		if d.cfg.KillConnections {
+			killed, err := d.killConnections(attempt.IP, d.cfg.BanProfile)
+			if err != nil {
+				d.logMessage("Erro ao encerrar conexões de %s: %v", attempt.IP, err)
+			} else if killed > 0 {
+				decision.KilledConnections = killed
+				d.logMessage("%d conexões estabelecidas de %s encerradas", killed, attempt.IP)
 			}
+		}
+		decisions = append(decisions, decision)
+	}
+	return decisions
+}
+
+// saveDecisions acrescenta as decisões ao arquivo de decisões do dry-run, em
+// JSON, uma por linha
+func (d *Detector) saveDecisions(decisions []Decision) error {
+	if err := os.MkdirAll(filepath.Dir(d.decisionsFilePath), 0755); err != nil {
+		return fmt.Errorf("erro ao criar diretório do dry-run: %w", err)
+	}
+	file, err := os.OpenFile(d.decisionsFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("erro ao abrir arquivo de decisões: %w", err)
+	}
+	defer file.Close()
+
+	now := time.Now().UTC()
+	for _, decision := range decisions {
+		decision.Time = now
+		data, err := json.Marshal(decision)
+		if err != nil {
+			return fmt.Errorf("erro ao serializar decisão: %w", err)
+		}
+		if _, err := file.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("erro ao gravar decisão: %w", err)
+		}
+		d.logMessage("[dry-run] %s: %s (%d tentativas) %s", decision.IP, decision.Decision, decision.Count, decision.Reason)
+	}
+	return nil
+}
+
+// parseLastb conta as tentativas por endereço de origem na saída do
+// "lastb -w -i", cuja terceira coluna é o endereço IPv4 ou IPv6. Linhas sem
+// endereço (como o rodapé "btmp begins") e origens desconhecidas, que o
+// lastb -i exibe como 0.0.0.0, são ignoradas. O resultado é ordenado pela
+// contagem, da maior para a menor.
+func parseLastb(output string, now time.Time) []LoginAttempt {
+	counts := make(map[string]int)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
 			continue
 		}
 
-		ip := matches[2]
-		
-		// Verificar se é um IP válido, mas aceitar mesmo se não for
-		valid := isValidIP(ip)
-		if !valid && i < 5 {
-			d.logMessage("IP não é válido, mas será incluído mesmo assim: %s", ip)
+		addr, err := netip.ParseAddr(fields[2])
+		if err != nil || addr.IsUnspecified() {
+			continue
 		}
+		counts[addr.Unmap().WithZone("").String()]++
+	}
 
+	attempts := make([]LoginAttempt, 0, len(counts))
+	for ip, count := range counts {
 		attempts = append(attempts, LoginAttempt{
 			IP:        ip,
 			Count:     count,
 			Timestamp: now,
 		})
-
-		// Registrar as primeiras 5 tentativas encontradas
-		if i < 5 {
-			d.logMessage("Tentativa encontrada: IP=%s, Count=%d", ip, count)
-		}
 	}
-
-	d.logMessage("Total de tentativas encontradas: %d", len(attempts))
-	return attempts, nil
+	sort.Slice(attempts, func(i, j int) bool {
+		if attempts[i].Count != attempts[j].Count {
+			return attempts[i].Count > attempts[j].Count
+		}
+		return attempts[i].IP < attempts[j].IP
+	})
+	return attempts
 }
 
 // saveToJSON salva as tentativas em um arquivo JSON
@@ -406,20 +497,9 @@ func (d *Detector) saveToJSON(attempts []LoginAttempt) error {
 	return nil
 }
 
-// isValidIP verifica se uma string é um endereço IP válido
+// isValidIP verifica se uma string é um alvo de banimento válido: um
+// endereço IPv4 ou IPv6 ou uma rede em notação CIDR
 func isValidIP(ip string) bool {
-	parts := strings.Split(ip, ".")
-	if len(parts) != 4 {
-		return false
-	}
-
-	for _, part := range parts {
-		// Verificar se cada parte é um número entre 0 e 255
-		var num int
-		if _, err := fmt.Sscanf(part, "%d", &num); err != nil || num < 0 || num > 255 {
-			return false
-		}
-	}
-
-	return true
+	_, err := firewall.ParseTarget(ip)
+	return err == nil
 }
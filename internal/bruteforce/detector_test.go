@@ -0,0 +1,103 @@
+package bruteforce
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mtm/guardian/internal/allowlist"
+	"github.com/mtm/guardian/internal/config"
+	"github.com/mtm/guardian/internal/firewall"
+	"github.com/mtm/guardian/internal/ledger"
+)
+
+// TestParseLastb testa a contagem de tentativas na saída do lastb -w -i
+func TestParseLastb(t *testing.T) {
+	output := `root     ssh:notty    2001:db8::1      Thu Oct 16 10:00 - 10:00  (00:00)
+admin    ssh:notty    203.0.113.7      Thu Oct 16 09:58 - 09:58  (00:00)
+root     ssh:notty    2001:db8:0::1    Thu Oct 16 09:57 - 09:57  (00:00)
+root     ssh:notty    2001:db8::1      Thu Oct 16 09:56 - 09:56  (00:00)
+oracle   ssh:notty    ::ffff:203.0.113.7 Thu Oct 16 09:55 - 09:55  (00:00)
+ubuntu   ssh:notty    0.0.0.0          Thu Oct 16 09:54 - 09:54  (00:00)
+
+btmp begins Wed Oct  1 00:00:01 2026`
+
+	now := time.Now()
+	attempts := parseLastb(output, now)
+
+	expected := []LoginAttempt{
+		{IP: "2001:db8::1", Count: 3, Timestamp: now},
+		{IP: "203.0.113.7", Count: 2, Timestamp: now},
+	}
+	if len(attempts) != len(expected) {
+		t.Fatalf("Tentativas esperadas: %+v, obtidas: %+v", expected, attempts)
+	}
+	for i := range expected {
+		if attempts[i] != expected[i] {
+			t.Errorf("Tentativa %d esperada: %+v, obtida: %+v", i, expected[i], attempts[i])
+		}
+	}
+}
+
+// TestBanAttemptsDecisions testa as decisões registradas para os IPs detectados
+func TestBanAttemptsDecisions(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{InstallDir: dir, DetectorBan: true, DryRun: true, KillConnections: true, BanProfile: config.DefaultBanProfile()}
+
+	list, err := allowlist.New(filepath.Join(dir, "allowlist.json"), []string{"198.51.100.7"}, "")
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	mock := firewall.NewMockFirewall()
+	mock.BanIP("203.0.113.9", cfg.BanProfile)
+	d := NewDetector(cfg, allowlist.Protect(mock, list), nil)
+	d.killConnections = func(ip string, profile firewall.BanProfile) (int, error) {
+		return 2, nil
+	}
+	led, err := ledger.Open(filepath.Join(dir, "ledger.json"))
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	d.SetLedger(led)
+
+	decisions := d.banAttempts([]LoginAttempt{
+		{IP: "203.0.113.7", Count: 5},
+		{IP: "203.0.113.9", Count: 4},
+		{IP: "198.51.100.7", Count: 3},
+		{IP: "invalido", Count: 3},
+	})
+	expected := []string{DecisionBan, DecisionIgnore, DecisionIgnore, DecisionIgnore}
+	if len(decisions) != len(expected) {
+		t.Fatalf("Decisões inesperadas: %+v", decisions)
+	}
+	for i, decision := range decisions {
+		if decision.Decision != expected[i] {
+			t.Errorf("Decisão para %s: esperada %q, obtida %q (%s)", decision.IP, expected[i], decision.Decision, decision.Reason)
+		}
+	}
+	if banned, _ := mock.IsBanned("198.51.100.7"); banned {
+		t.Error("IP da lista de permitidos não deveria ter sido banido")
+	}
+	if r, ok := led.Get("203.0.113.7"); !ok || r.Source != ledger.SourceDetector || r.Reason == "" {
+		t.Errorf("Banimento do detector não registrado: %+v", r)
+	}
+	if len(led.Records()) != 1 {
+		t.Errorf("Apenas o banimento aplicado deveria ser registrado: %+v", led.Records())
+	}
+	if decisions[0].KilledConnections != 2 || decisions[1].KilledConnections != 0 {
+		t.Errorf("Conexões encerradas inesperadas: %+v", decisions)
+	}
+
+	if err := d.saveDecisions(decisions); err != nil {
+		t.Fatalf("Erro ao gravar decisões: %v", err)
+	}
+	data, err := os.ReadFile(d.decisionsFilePath)
+	if err != nil {
+		t.Fatalf("Erro ao ler decisões: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != len(decisions) {
+		t.Errorf("Esperadas %d linhas no arquivo de decisões, obtidas %d", len(decisions), lines)
+	}
+}
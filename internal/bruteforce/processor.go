@@ -53,7 +53,7 @@ func (p *Processor) ProcessLogAndSendToDatabase() error {
 	defer file.Close()
 
 	// Compilar regex para extrair IPs e contagens
-	re := regexp.MustCompile(`Detectado IP com múltiplas tentativas: (\d+\.\d+\.\d+\.\d+) \(contagem: (\d+)\)`)
+	re := regexp.MustCompile(`Detectado IP com múltiplas tentativas: ([0-9A-Fa-f.:]+) \(contagem: (\d+)\)`)
 
 	// Mapa para armazenar IPs únicos (para evitar duplicatas)
 	uniqueIPs := make(map[string]bool)
@@ -121,7 +121,7 @@ func (p *Processor) ExtractIPsFromLog() ([]IPEntry, error) {
 	defer file.Close()
 
 	// Compilar regex para extrair IPs e contagens
-	re := regexp.MustCompile(`\[([^\]]+)\] Detectado IP com múltiplas tentativas: (\d+\.\d+\.\d+\.\d+) \(contagem: (\d+)\)`)
+	re := regexp.MustCompile(`\[([^\]]+)\] Detectado IP com múltiplas tentativas: ([0-9A-Fa-f.:]+) \(contagem: (\d+)\)`)
 
 	// Slice para armazenar os resultados
 	var entries []IPEntry
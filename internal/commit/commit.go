@@ -0,0 +1,173 @@
+package commit
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mtm/guardian/internal/firewall"
+)
+
+// Estados de uma ativação
+const (
+	StatePending   = "pendente"
+	StateConfirmed = "confirmado"
+	StateReverted  = "revertido"
+)
+
+// pollInterval é o intervalo de verificação do arquivo de confirmação
+var pollInterval = time.Second
+
+// ErrNotPending indica que não há ativação aguardando confirmação
+var ErrNotPending = errors.New("nenhuma ativação do firewall aguardando confirmação")
+
+// Status descreve o estado de uma ativação
+type Status struct {
+	State    string    `json:"state"`
+	Deadline time.Time `json:"deadline"`
+	Error    string    `json:"error,omitempty"` // Falha ao reverter
+}
+
+// Pending é uma ativação do firewall aplicada em modo commit-confirm: se não
+// for confirmada dentro do prazo, o firewall volta ao estado anterior
+type Pending struct {
+	mu          sync.Mutex
+	fw          firewall.Firewall
+	snapshot    *firewall.Snapshot
+	confirmPath string
+	status      Status
+	done        chan struct{}
+}
+
+// Enable salva o estado do firewall, executa o Enable e aguarda a confirmação
+// por até window. A confirmação vem de Confirm (API) ou da criação do arquivo
+// confirmPath (comando "guardian confirm").
+func Enable(fw firewall.Firewall, window time.Duration, confirmPath string) (*Pending, error) {
+	snapshot, err := fw.Snapshot()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao salvar o estado do firewall: %w", err)
+	}
+
+	// Uma confirmação antiga não vale para esta ativação
+	if err := os.Remove(confirmPath); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("erro ao remover confirmação anterior: %w", err)
+	}
+
+	if err := fw.Enable(); err != nil {
+		if rerr := fw.Restore(snapshot); rerr != nil {
+			log.Printf("Erro ao reverter o firewall após falha na ativação: %v", rerr)
+		}
+		return nil, err
+	}
+
+	p := &Pending{
+		fw:          fw,
+		snapshot:    snapshot,
+		confirmPath: confirmPath,
+		status:      Status{State: StatePending, Deadline: time.Now().Add(window).UTC()},
+		done:        make(chan struct{}),
+	}
+	go p.wait(window, pollInterval)
+
+	return p, nil
+}
+
+// Confirm mantém a ativação e cancela a reversão
+func (p *Pending) Confirm() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.status.State != StatePending {
+		return ErrNotPending
+	}
+	p.status.State = StateConfirmed
+	close(p.done)
+	return nil
+}
+
+// Status retorna o estado da ativação
+func (p *Pending) Status() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// Done é fechado quando a ativação é confirmada ou revertida
+func (p *Pending) Done() <-chan struct{} {
+	return p.done
+}
+
+// wait verifica o arquivo de confirmação até o prazo e reverte o firewall se
+// a ativação não foi confirmada
+func (p *Pending) wait(window, poll time.Duration) {
+	deadline := time.NewTimer(window)
+	defer deadline.Stop()
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			if _, err := os.Stat(p.confirmPath); err != nil {
+				continue
+			}
+			os.Remove(p.confirmPath)
+			if p.Confirm() == nil {
+				log.Println("Ativação do firewall confirmada (guardian confirm)")
+			}
+		case <-deadline.C:
+			p.revert()
+			return
+		}
+	}
+}
+
+// revert restaura o estado salvo antes do Enable
+func (p *Pending) revert() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.status.State != StatePending {
+		return
+	}
+	p.status.State = StateReverted
+	close(p.done)
+
+	log.Println("Ativação do firewall não confirmada no prazo. Revertendo...")
+	if err := p.fw.Restore(p.snapshot); err != nil {
+		p.status.Error = err.Error()
+		log.Printf("Erro ao reverter o firewall: %v", err)
+		return
+	}
+	log.Println("Firewall revertido ao estado anterior à ativação")
+}
+
+// RequestConfirm cria o arquivo de confirmação lido pelo serviço e aguarda
+// até timeout que ele seja consumido. Retorna false se o serviço não
+// consumiu o arquivo (por exemplo, se não está em execução ou não há
+// ativação pendente).
+func RequestConfirm(confirmPath string, timeout time.Duration) (bool, error) {
+	if err := os.MkdirAll(filepath.Dir(confirmPath), 0755); err != nil {
+		return false, fmt.Errorf("erro ao criar diretório de dados: %w", err)
+	}
+	if err := ioutil.WriteFile(confirmPath, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		return false, fmt.Errorf("erro ao registrar confirmação: %w", err)
+	}
+
+	for end := time.Now().Add(timeout); time.Now().Before(end); time.Sleep(pollInterval / 4) {
+		if _, err := os.Stat(confirmPath); os.IsNotExist(err) {
+			return true, nil
+		}
+	}
+
+	// Não deixar uma confirmação antiga para uma próxima ativação
+	os.Remove(confirmPath)
+	return false, nil
+}
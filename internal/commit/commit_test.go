@@ -0,0 +1,87 @@
+package commit
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mtm/guardian/internal/config"
+	"github.com/mtm/guardian/internal/firewall"
+)
+
+// TestPending testa a confirmação e a reversão automática de uma ativação
+func TestPending(t *testing.T) {
+	pollInterval = 10 * time.Millisecond
+	defer func() { pollInterval = time.Second }()
+
+	confirmPath := filepath.Join(t.TempDir(), "confirm")
+
+	t.Run("Revert", func(t *testing.T) {
+		fw := firewall.NewMockFirewall()
+		fw.BanIP("192.168.1.100", config.DefaultBanProfile())
+
+		p, err := Enable(fw, 50*time.Millisecond, confirmPath)
+		if err != nil {
+			t.Fatalf("Erro inesperado: %v", err)
+		}
+		if enabled, _ := fw.IsEnabled(); !enabled {
+			t.Fatal("Firewall deveria estar ativo durante o prazo")
+		}
+		fw.BanIP("192.168.1.101", config.DefaultBanProfile())
+
+		<-p.Done()
+		if s := p.Status(); s.State != StateReverted || s.Error != "" {
+			t.Errorf("Estado inesperado: %+v", s)
+		}
+		if enabled, _ := fw.IsEnabled(); enabled {
+			t.Error("Firewall deveria ter sido revertido")
+		}
+		if banned, _ := fw.IsBanned("192.168.1.100"); !banned {
+			t.Error("Banimento anterior à ativação deveria ter sido restaurado")
+		}
+		if err := p.Confirm(); err != ErrNotPending {
+			t.Errorf("Esperado ErrNotPending, obtido %v", err)
+		}
+	})
+
+	t.Run("Confirm", func(t *testing.T) {
+		fw := firewall.NewMockFirewall()
+		p, err := Enable(fw, 50*time.Millisecond, confirmPath)
+		if err != nil {
+			t.Fatalf("Erro inesperado: %v", err)
+		}
+		if err := p.Confirm(); err != nil {
+			t.Fatalf("Erro inesperado: %v", err)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		if s := p.Status(); s.State != StateConfirmed {
+			t.Errorf("Estado inesperado: %+v", s)
+		}
+		if enabled, _ := fw.IsEnabled(); !enabled {
+			t.Error("Firewall deveria continuar ativo")
+		}
+	})
+
+	t.Run("ConfirmFile", func(t *testing.T) {
+		fw := firewall.NewMockFirewall()
+		p, err := Enable(fw, time.Minute, confirmPath)
+		if err != nil {
+			t.Fatalf("Erro inesperado: %v", err)
+		}
+
+		ok, err := RequestConfirm(confirmPath, time.Second)
+		if err != nil || !ok {
+			t.Fatalf("Confirmação não consumida: %v, %v", ok, err)
+		}
+		<-p.Done()
+		if s := p.Status(); s.State != StateConfirmed {
+			t.Errorf("Estado inesperado: %+v", s)
+		}
+
+		// Sem ativação pendente, a confirmação não é consumida
+		if ok, _ := RequestConfirm(confirmPath, 50*time.Millisecond); ok {
+			t.Error("Confirmação não deveria ter sido consumida")
+		}
+	})
+}
@@ -5,10 +5,31 @@ import (
 	"net"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// Modos de aplicação dos banimentos ao tráfego dos containers do Docker
+const (
+	DockerAuto = "auto" // Apenas quando a chain DOCKER-USER existe
+	DockerOn   = "on"   // Sempre, criando a DOCKER-USER se necessário
+	DockerOff  = "off"
+)
+
+// Tratamento dos banimentos órfãos na reconciliação: presentes no firewall,
+// mas sem registro ativo no registro de banimentos
+const (
+	OrphansFlag   = "flag"   // Apenas reportados
+	OrphansRemove = "remove" // Removidos do firewall
+	OrphansAdopt  = "adopt"  // Incluídos no registro de banimentos
+)
+
+// DefaultBatchLimit é o número máximo padrão de itens em um lote de
+// banimentos da API
+const DefaultBatchLimit = 1000
+
 // Config contém as configurações da aplicação
 type Config struct {
 	IP           string
@@ -21,6 +42,34 @@ type Config struct {
 	DBSchema     string
 	ServerID     string
 	TitularID    string
+	// Banimento automático pelo detector de força bruta
+	DetectorBan         bool
+	DetectorBanDuration time.Duration // Zero para banimentos permanentes
+	// Perfil padrão dos banimentos (portas, protocolos e ação)
+	BanProfile BanProfile
+	// IPs e redes que nunca podem ser banidos, além dos adicionados pela API
+	Allowlist []string
+	// Portas do SSH, usadas para detectar sessões que um banimento derrubaria
+	SSHPorts []int
+	// Prazo para confirmar a ativação do firewall antes que ela seja
+	// revertida; zero ativa sem confirmação
+	EnableConfirm time.Duration
+	// Modo dry-run: as alterações no firewall são registradas, não aplicadas
+	DryRun bool
+	// Aplicação dos banimentos ao tráfego encaminhado aos containers do
+	// Docker (DockerAuto, DockerOn ou DockerOff)
+	DockerBans string
+	// Encerrar as conexões já estabelecidas do IP (entradas do conntrack)
+	// ao bani-lo
+	KillConnections bool
+	// Intervalo entre as reconciliações do registro de banimentos com o
+	// firewall; zero reconcilia apenas na inicialização
+	ReconcileInterval time.Duration
+	// Tratamento dos banimentos órfãos (OrphansFlag, OrphansRemove ou
+	// OrphansAdopt)
+	ReconcileOrphans string
+	// Número máximo de itens em um lote de banimentos (POST /v1/bans:batch)
+	BatchLimit int
 }
 
 // Load carrega as configurações do arquivo .env ou variáveis de ambiente
@@ -32,8 +81,16 @@ func Load() (*Config, error) {
 	// Configuração padrão
 	cfg := &Config{
 		Port:         4554,
-		FirewallType: "auto", // auto, ufw, iptables
+		FirewallType: "auto", // auto, ufw, nftables, iptables, firewalld
 		InstallDir:   "/opt/guardian",
+		// Banimentos do detector expiram em 24h por padrão
+		DetectorBanDuration: 24 * time.Hour,
+		BanProfile:          DefaultBanProfile(),
+		SSHPorts:            []int{22},
+		DockerBans:          DockerAuto,
+		ReconcileInterval:   15 * time.Minute,
+		ReconcileOrphans:    OrphansFlag,
+		BatchLimit:          DefaultBatchLimit,
 	}
 
 	// Obter IP automaticamente se não estiver definido
@@ -92,9 +149,135 @@ func Load() (*Config, error) {
 		cfg.TitularID = titularID
 	}
 
+	// Banimento automático pelo detector
+	if detectorBan := os.Getenv("GUARDIAN_DETECTOR_BAN"); detectorBan != "" {
+		enabled, err := strconv.ParseBool(detectorBan)
+		if err != nil {
+			return nil, fmt.Errorf("valor inválido para GUARDIAN_DETECTOR_BAN: %w", err)
+		}
+		cfg.DetectorBan = enabled
+	}
+
+	if banDuration := os.Getenv("GUARDIAN_DETECTOR_BAN_DURATION"); banDuration != "" {
+		duration, err := ParseDuration(banDuration)
+		if err != nil {
+			return nil, fmt.Errorf("valor inválido para GUARDIAN_DETECTOR_BAN_DURATION: %w", err)
+		}
+		cfg.DetectorBanDuration = duration
+	}
+
+	// Perfil de banimento
+	profile, err := cfg.BanProfile.With(os.Getenv("GUARDIAN_BAN_PORTS"), os.Getenv("GUARDIAN_BAN_PROTOCOLS"), os.Getenv("GUARDIAN_BAN_ACTION"))
+	if err != nil {
+		return nil, fmt.Errorf("perfil de banimento inválido (GUARDIAN_BAN_*): %w", err)
+	}
+	cfg.BanProfile = profile
+
+	// Lista de permitidos, separada por vírgulas
+	if allowlist := os.Getenv("GUARDIAN_ALLOWLIST"); allowlist != "" {
+		for _, entry := range strings.Split(allowlist, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				cfg.Allowlist = append(cfg.Allowlist, entry)
+			}
+		}
+	}
+
+	// Portas do SSH, separadas por vírgulas
+	if sshPorts := os.Getenv("GUARDIAN_SSH_PORTS"); sshPorts != "" {
+		cfg.SSHPorts = nil
+		for _, s := range strings.Split(sshPorts, ",") {
+			port, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || port < 1 || port > 65535 {
+				return nil, fmt.Errorf("porta inválida em GUARDIAN_SSH_PORTS: %s", s)
+			}
+			cfg.SSHPorts = append(cfg.SSHPorts, port)
+		}
+	}
+
+	// Commit-confirm na ativação do firewall
+	if enableConfirm := os.Getenv("GUARDIAN_ENABLE_CONFIRM"); enableConfirm != "" {
+		window, err := ParseDuration(enableConfirm)
+		if err != nil || window < 0 {
+			return nil, fmt.Errorf("valor inválido para GUARDIAN_ENABLE_CONFIRM: %s", enableConfirm)
+		}
+		cfg.EnableConfirm = window
+	}
+
+	// Modo dry-run
+	if dryRun := os.Getenv("GUARDIAN_DRY_RUN"); dryRun != "" {
+		enabled, err := strconv.ParseBool(dryRun)
+		if err != nil {
+			return nil, fmt.Errorf("valor inválido para GUARDIAN_DRY_RUN: %w", err)
+		}
+		cfg.DryRun = enabled
+	}
+
+	// Banimentos no tráfego dos containers do Docker
+	if dockerBans := os.Getenv("GUARDIAN_DOCKER_BANS"); dockerBans != "" {
+		switch mode := strings.ToLower(dockerBans); mode {
+		case DockerAuto, DockerOn, DockerOff:
+			cfg.DockerBans = mode
+		default:
+			return nil, fmt.Errorf("valor inválido para GUARDIAN_DOCKER_BANS: %s (use auto, on ou off)", dockerBans)
+		}
+	}
+
+	// Encerramento das conexões estabelecidas nos banimentos
+	if kill := os.Getenv("GUARDIAN_KILL_CONNECTIONS"); kill != "" {
+		enabled, err := strconv.ParseBool(kill)
+		if err != nil {
+			return nil, fmt.Errorf("valor inválido para GUARDIAN_KILL_CONNECTIONS: %w", err)
+		}
+		cfg.KillConnections = enabled
+	}
+
+	// Reconciliação do registro de banimentos com o firewall
+	if interval := os.Getenv("GUARDIAN_RECONCILE_INTERVAL"); interval != "" {
+		d, err := ParseDuration(interval)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("valor inválido para GUARDIAN_RECONCILE_INTERVAL: %s", interval)
+		}
+		cfg.ReconcileInterval = d
+	}
+	if orphans := os.Getenv("GUARDIAN_RECONCILE_ORPHANS"); orphans != "" {
+		switch mode := strings.ToLower(orphans); mode {
+		case OrphansFlag, OrphansRemove, OrphansAdopt:
+			cfg.ReconcileOrphans = mode
+		default:
+			return nil, fmt.Errorf("valor inválido para GUARDIAN_RECONCILE_ORPHANS: %s (use flag, remove ou adopt)", orphans)
+		}
+	}
+
+	// Tamanho máximo dos lotes de banimentos
+	if limit := os.Getenv("GUARDIAN_BATCH_LIMIT"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("valor inválido para GUARDIAN_BATCH_LIMIT: %s", limit)
+		}
+		cfg.BatchLimit = n
+	}
+
 	return cfg, nil
 }
 
+// ParseDuration interpreta uma duração no formato do time.ParseDuration,
+// aceitando também dias ("7d")
+func ParseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("duração inválida: %s", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("duração inválida: %s", s)
+	}
+	return d, nil
+}
+
 // getOutboundIP obtém o IP preferencial da máquina para conexões externas
 func getOutboundIP() (string, error) {
 	conn, err := net.Dial("udp", "8.8.8.8:80")
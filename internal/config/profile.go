@@ -0,0 +1,195 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Ações de bloqueio aceitas em um perfil de banimento
+const (
+	ActionDrop   = "drop"
+	ActionReject = "reject"
+	ActionTarpit = "tarpit"
+)
+
+// BanProfile define o escopo de um banimento: quais portas e protocolos são
+// bloqueados e como os pacotes são descartados
+type BanProfile struct {
+	Ports     []int    `json:"ports,omitempty"`     // Vazio para todas as portas
+	Protocols []string `json:"protocols,omitempty"` // tcp e/ou udp; vazio para todos os protocolos
+	Action    string   `json:"action"`              // drop, reject ou tarpit
+}
+
+// DefaultBanProfile retorna o perfil usado quando nenhum é configurado: DROP
+// das portas TCP de SSH, HTTP, HTTPS e da API do Guardian
+func DefaultBanProfile() BanProfile {
+	return BanProfile{
+		Ports:     []int{22, 80, 443, 4554},
+		Protocols: []string{"tcp"},
+		Action:    ActionDrop,
+	}
+}
+
+// With retorna uma cópia do perfil com os campos informados substituídos.
+// Campos vazios mantêm o valor atual. As portas e os protocolos são listas
+// separadas por vírgula ou "all".
+func (p BanProfile) With(ports, protocols, action string) (BanProfile, error) {
+	if ports = strings.TrimSpace(ports); ports != "" {
+		p.Ports = nil
+		if !strings.EqualFold(ports, "all") {
+			for _, s := range strings.Split(ports, ",") {
+				port, err := strconv.Atoi(strings.TrimSpace(s))
+				if err != nil {
+					return BanProfile{}, fmt.Errorf("porta inválida no perfil de banimento: %s", s)
+				}
+				p.Ports = append(p.Ports, port)
+			}
+		}
+	}
+
+	if protocols = strings.TrimSpace(protocols); protocols != "" {
+		p.Protocols = nil
+		if !strings.EqualFold(protocols, "all") {
+			for _, s := range strings.Split(protocols, ",") {
+				p.Protocols = append(p.Protocols, strings.ToLower(strings.TrimSpace(s)))
+			}
+		}
+	}
+
+	if action = strings.TrimSpace(action); action != "" {
+		p.Action = strings.ToLower(action)
+	}
+
+	p = p.normalize()
+	if err := p.Validate(); err != nil {
+		return BanProfile{}, err
+	}
+	return p, nil
+}
+
+// Validate verifica se o perfil é consistente
+func (p BanProfile) Validate() error {
+	for _, port := range p.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("porta inválida no perfil de banimento: %d", port)
+		}
+	}
+
+	for _, proto := range p.Protocols {
+		if proto != "tcp" && proto != "udp" {
+			return fmt.Errorf("protocolo inválido no perfil de banimento: %s (use tcp ou udp)", proto)
+		}
+	}
+	if len(p.Ports) > 0 && len(p.Protocols) == 0 {
+		return fmt.Errorf("o perfil de banimento precisa de um protocolo (tcp ou udp) para bloquear portas específicas")
+	}
+
+	switch p.Action {
+	case ActionDrop, ActionReject:
+	case ActionTarpit:
+		// O TARPIT só se aplica a conexões TCP
+		if len(p.Protocols) != 1 || p.Protocols[0] != "tcp" {
+			return fmt.Errorf("a ação tarpit exige o protocolo tcp")
+		}
+	default:
+		return fmt.Errorf("ação inválida no perfil de banimento: %s (use drop, reject ou tarpit)", p.Action)
+	}
+
+	return nil
+}
+
+// AllPorts indica se o perfil bloqueia todas as portas
+func (p BanProfile) AllPorts() bool {
+	return len(p.Ports) == 0
+}
+
+// Blocks indica se o perfil bloqueia o tráfego para a porta e protocolo
+// informados
+func (p BanProfile) Blocks(protocol string, port int) bool {
+	if len(p.Protocols) > 0 && !containsString(p.Protocols, protocol) {
+		return false
+	}
+	return p.AllPorts() || containsInt(p.Ports, port)
+}
+
+// Equal compara dois perfis
+func (p BanProfile) Equal(other BanProfile) bool {
+	return p.String() == other.String()
+}
+
+// String retorna a forma canônica do perfil, "<ação> <protocolos> <portas>",
+// como em "drop tcp 22,80,443,4554" ou "reject all all". É o formato aceito
+// por ParseBanProfile. As portas e os protocolos são ordenados e sem
+// repetições, de modo que perfis iguais têm a mesma forma.
+func (p BanProfile) String() string {
+	p = p.normalize()
+
+	protocols := "all"
+	if len(p.Protocols) > 0 {
+		protocols = strings.Join(p.Protocols, ",")
+	}
+
+	ports := "all"
+	if len(p.Ports) > 0 {
+		list := make([]string, len(p.Ports))
+		for i, port := range p.Ports {
+			list[i] = strconv.Itoa(port)
+		}
+		ports = strings.Join(list, ",")
+	}
+
+	return p.Action + " " + protocols + " " + ports
+}
+
+// ParseBanProfile interpreta a forma canônica gerada por String
+func ParseBanProfile(s string) (BanProfile, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		return BanProfile{}, fmt.Errorf("perfil de banimento inválido: %q", s)
+	}
+	return BanProfile{}.With(fields[2], fields[1], fields[0])
+}
+
+// normalize ordena e remove duplicatas das portas e protocolos
+func (p BanProfile) normalize() BanProfile {
+	var ports []int
+	for _, port := range p.Ports {
+		if !containsInt(ports, port) {
+			ports = append(ports, port)
+		}
+	}
+	sort.Ints(ports)
+
+	var protocols []string
+	for _, proto := range p.Protocols {
+		if !containsString(protocols, proto) {
+			protocols = append(protocols, proto)
+		}
+	}
+	sort.Strings(protocols)
+
+	p.Ports, p.Protocols = ports, protocols
+	return p
+}
+
+// containsInt verifica se a lista contém o valor
+func containsInt(list []int, value int) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString verifica se a lista contém o valor
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
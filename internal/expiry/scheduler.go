@@ -0,0 +1,211 @@
+package expiry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mtm/guardian/internal/firewall"
+)
+
+// checkInterval é o intervalo entre as verificações de banimentos expirados
+const checkInterval = 30 * time.Second
+
+// Entry representa um banimento temporário agendado
+type Entry struct {
+	IP        string    `json:"ip"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Scheduler remove banimentos temporários quando expiram. A agenda é
+// persistida em um arquivo JSON para sobreviver a reinícios do serviço.
+type Scheduler struct {
+	mu      sync.Mutex
+	path    string
+	fw      firewall.Firewall
+	entries map[string]time.Time
+	// onExpire é chamada para cada banimento expirado removido
+	onExpire func(ip string)
+}
+
+// NewScheduler cria o agendador, carregando a agenda salva em path
+func NewScheduler(path string, fw firewall.Firewall) (*Scheduler, error) {
+	s := &Scheduler{
+		path:    path,
+		fw:      fw,
+		entries: make(map[string]time.Time),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("erro ao ler agenda de expiração: %w", err)
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("erro ao decodificar agenda de expiração %s: %w", path, err)
+	}
+	for _, e := range entries {
+		s.entries[e.IP] = e.ExpiresAt
+	}
+
+	return s, nil
+}
+
+// OnExpire registra uma função chamada para cada banimento expirado, depois
+// de removido do firewall (ou se já havia sido removido)
+func (s *Scheduler) OnExpire(fn func(ip string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onExpire = fn
+}
+
+// Schedule agenda a remoção do banimento do IP para o instante informado,
+// substituindo um agendamento anterior
+func (s *Scheduler) Schedule(ip string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[ip] = expiresAt
+	return s.save()
+}
+
+// Cancel remove o agendamento do IP, tornando o banimento permanente
+func (s *Scheduler) Cancel(ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[ip]; !ok {
+		return nil
+	}
+	delete(s.entries, ip)
+	return s.save()
+}
+
+// ExpiresAt retorna quando o banimento do IP expira, se for temporário
+func (s *Scheduler) ExpiresAt(ip string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at, ok := s.entries[ip]
+	return at, ok
+}
+
+// Entries retorna os agendamentos ordenados pela expiração
+func (s *Scheduler) Entries() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sorted()
+}
+
+// Start verifica periodicamente os banimentos expirados. Bloqueia, então
+// deve ser executado em uma goroutine.
+func (s *Scheduler) Start() {
+	log.Printf("Agenda de expiração carregada: %d banimento(s) temporário(s)", len(s.Entries()))
+
+	s.Expire(time.Now())
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.Expire(now)
+	}
+}
+
+// Expire remove, através do Firewall.UnbanIP, os banimentos expirados até
+// now. Falhas são registradas e o banimento é tentado novamente na próxima
+// verificação.
+func (s *Scheduler) Expire(now time.Time) {
+	for _, e := range s.Entries() {
+		if e.ExpiresAt.After(now) {
+			break
+		}
+
+		banned, err := s.fw.IsBanned(e.IP)
+		if err != nil {
+			log.Printf("Erro ao verificar banimento expirado de %s: %v", e.IP, err)
+			continue
+		}
+		var rangeErr *firewall.RangeError
+		if banned {
+			err := s.fw.UnbanIP(e.IP)
+			switch {
+			case errors.As(err, &rangeErr):
+				// O IP foi unificado ao banimento de uma rede, que segue valendo
+				log.Printf("Banimento de %s expirado, mas o endereço segue bloqueado por %s", e.IP, rangeErr.Range)
+			case err != nil:
+				log.Printf("Erro ao remover banimento expirado de %s: %v", e.IP, err)
+				continue
+			default:
+				log.Printf("Banimento de %s expirado em %s e removido", e.IP, e.ExpiresAt.Format(time.RFC3339))
+			}
+		} else {
+			log.Printf("Banimento de %s expirado em %s já havia sido removido", e.IP, e.ExpiresAt.Format(time.RFC3339))
+		}
+
+		s.mu.Lock()
+		// O IP pode ter sido reagendado enquanto o banimento era removido
+		onExpire := s.onExpire
+		if at, ok := s.entries[e.IP]; ok && at.Equal(e.ExpiresAt) {
+			delete(s.entries, e.IP)
+			if err := s.save(); err != nil {
+				log.Printf("Erro ao salvar agenda de expiração: %v", err)
+			}
+		} else {
+			onExpire = nil
+		}
+		s.mu.Unlock()
+
+		if onExpire != nil {
+			onExpire(e.IP)
+		}
+	}
+}
+
+// sorted retorna os agendamentos ordenados pela expiração. Deve ser chamado
+// com o mutex travado.
+func (s *Scheduler) sorted() []Entry {
+	entries := make([]Entry, 0, len(s.entries))
+	for ip, at := range s.entries {
+		entries = append(entries, Entry{IP: ip, ExpiresAt: at})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ExpiresAt.Before(entries[j].ExpiresAt)
+	})
+	return entries
+}
+
+// save grava a agenda no disco de forma atômica. Deve ser chamado com o
+// mutex travado.
+func (s *Scheduler) save() error {
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("erro ao serializar agenda de expiração: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("erro ao criar diretório da agenda de expiração: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("erro ao salvar agenda de expiração: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("erro ao salvar agenda de expiração: %w", err)
+	}
+
+	return nil
+}
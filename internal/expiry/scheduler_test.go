@@ -0,0 +1,66 @@
+package expiry
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mtm/guardian/internal/config"
+	"github.com/mtm/guardian/internal/firewall"
+)
+
+// TestSchedulerExpire testa a remoção de banimentos expirados
+func TestSchedulerExpire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "expiry.json")
+	fw := firewall.NewMockFirewall()
+	fw.BanIP("192.168.1.100", config.DefaultBanProfile())
+	fw.BanIP("192.168.1.101", config.DefaultBanProfile())
+
+	sched, err := NewScheduler(path, fw)
+	if err != nil {
+		t.Fatalf("Erro ao criar agendador: %v", err)
+	}
+
+	now := time.Now()
+	if err := sched.Schedule("192.168.1.100", now.Add(time.Minute)); err != nil {
+		t.Fatalf("Erro ao agendar expiração: %v", err)
+	}
+	if err := sched.Schedule("192.168.1.101", now.Add(time.Hour)); err != nil {
+		t.Fatalf("Erro ao agendar expiração: %v", err)
+	}
+
+	// A agenda deve sobreviver a um reinício
+	sched, err = NewScheduler(path, fw)
+	if err != nil {
+		t.Fatalf("Erro ao recarregar agendador: %v", err)
+	}
+	if len(sched.Entries()) != 2 {
+		t.Fatalf("Agendamentos esperados: 2, obtidos: %d", len(sched.Entries()))
+	}
+
+	var expired []string
+	sched.OnExpire(func(ip string) { expired = append(expired, ip) })
+	sched.Expire(now.Add(2 * time.Minute))
+
+	if len(expired) != 1 || expired[0] != "192.168.1.100" {
+		t.Errorf("Expirações notificadas inesperadas: %v", expired)
+	}
+	if banned, _ := fw.IsBanned("192.168.1.100"); banned {
+		t.Error("IP 192.168.1.100 deveria ter sido desbanido")
+	}
+	if banned, _ := fw.IsBanned("192.168.1.101"); !banned {
+		t.Error("IP 192.168.1.101 ainda deveria estar banido")
+	}
+	if _, ok := sched.ExpiresAt("192.168.1.100"); ok {
+		t.Error("Agendamento de 192.168.1.100 deveria ter sido removido")
+	}
+
+	// Cancelar torna o banimento permanente
+	if err := sched.Cancel("192.168.1.101"); err != nil {
+		t.Fatalf("Erro ao cancelar agendamento: %v", err)
+	}
+	sched.Expire(now.Add(2 * time.Hour))
+	if banned, _ := fw.IsBanned("192.168.1.101"); !banned {
+		t.Error("IP 192.168.1.101 não deveria expirar após o cancelamento")
+	}
+}
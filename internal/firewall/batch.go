@@ -0,0 +1,210 @@
+package firewall
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// BatchOp é uma alteração de um lote: o banimento do alvo com o perfil ou,
+// com Unban, o seu desbanimento
+type BatchOp struct {
+	IP      string
+	Profile BanProfile
+	Unban   bool
+}
+
+// batchEach aplica o lote item a item com BanIP e UnbanIP, para os backends
+// sem uma operação em lote
+func batchEach(fw Firewall, ops []BatchOp) []error {
+	errs := make([]error, len(ops))
+	for i, op := range ops {
+		if op.Unban {
+			errs[i] = fw.UnbanIP(op.IP)
+		} else {
+			errs[i] = fw.BanIP(op.IP, op.Profile)
+		}
+	}
+	return errs
+}
+
+// batchFailed retorna o mesmo erro para todos os itens do lote
+func batchFailed(ops []BatchOp, err error) []error {
+	errs := make([]error, len(ops))
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+// batchChange é uma alteração no backend decorrente de um item do lote
+type batchChange struct {
+	op      int // Índice do item que a originou
+	ip      string
+	profile BanProfile // Perfil do banimento adicionado
+	rules   []string   // Regras do banimento removido, da listagem do backend
+}
+
+// batchPlan são as alterações de um lote validadas contra os banimentos
+// atuais e entre si, com o erro de cada item
+type batchPlan struct {
+	add    []batchChange
+	remove []batchChange
+	errs   []error
+}
+
+// fail registra o erro do item, caso ele ainda não tenha falhado
+func (p *batchPlan) fail(op int, err error) {
+	if p.errs[op] == nil {
+		p.errs[op] = err
+	}
+}
+
+// failAll registra o erro em todos os itens que alteram o backend, quando a
+// operação que os aplicaria de uma vez falha
+func (p *batchPlan) failAll(err error) {
+	for _, c := range append(append([]batchChange{}, p.remove...), p.add...) {
+		p.fail(c.op, err)
+	}
+}
+
+// verifyRemoved confere, numa única listagem tirada depois do lote, que os
+// banimentos removidos não ficaram no backend. Os alvos banidos novamente
+// pelo lote, com outro perfil, são ignorados.
+func (p *batchPlan) verifyRemoved(bans []Ban) {
+	for _, c := range p.remove {
+		readded := false
+		for _, a := range p.add {
+			readded = readded || a.ip == c.ip
+		}
+		if ban := FindBan(bans, c.ip); ban != nil && !readded {
+			p.fail(c.op, &RemainingRulesError{IP: c.ip, Rules: ban.Rules})
+		}
+	}
+}
+
+// batchEntry é um banimento no estado simulado pelo planejamento do lote
+type batchEntry struct {
+	prefix  netip.Prefix
+	ip      string
+	profile BanProfile
+	rules   []string
+	pending bool // Adicionado por um item do lote
+	op      int
+}
+
+// planBatch aplica ao lote as mesmas regras do prepareBan e do prepareUnban,
+// simulando os itens na ordem: alvos cobertos por uma rede banida são
+// recusados, banimentos contidos em uma rede banida no lote são unificados e
+// um banimento com outro perfil é substituído. Itens sem efeito (um alvo já
+// banido com o mesmo perfil ou um desbanimento de um alvo não banido) não
+// geram alterações. check, opcional, recusa os banimentos que o backend não
+// suporta.
+func planBatch(bans []Ban, ops []BatchOp, check func(ip string, profile BanProfile) error) *batchPlan {
+	plan := &batchPlan{errs: make([]error, len(ops))}
+
+	var state []batchEntry
+	for _, ban := range bans {
+		prefix, err := ParseTarget(ban.IP)
+		if err != nil {
+			continue
+		}
+		state = append(state, batchEntry{prefix: prefix, ip: FormatTarget(prefix), profile: ban.Profile(), rules: ban.Rules})
+	}
+
+	// remove retira um banimento do estado; os adicionados pelo próprio lote
+	// simplesmente deixam de ser aplicados
+	remove := func(i, op int) {
+		if e := state[i]; !e.pending {
+			plan.remove = append(plan.remove, batchChange{op: op, ip: e.ip, rules: e.rules})
+		}
+		state = append(state[:i], state[i+1:]...)
+	}
+	find := func(prefix netip.Prefix) int {
+		for i, e := range state {
+			if e.prefix == prefix {
+				return i
+			}
+		}
+		return -1
+	}
+	covering := func(prefix netip.Prefix) *batchEntry {
+		for i, e := range state {
+			if e.prefix != prefix && contains(e.prefix, prefix) {
+				return &state[i]
+			}
+		}
+		return nil
+	}
+
+	for i, op := range ops {
+		prefix, err := ParseTarget(op.IP)
+		if err != nil {
+			plan.errs[i] = err
+			continue
+		}
+		target := FormatTarget(prefix)
+
+		if op.Unban {
+			if j := find(prefix); j >= 0 {
+				remove(j, i)
+			} else if e := covering(prefix); e != nil {
+				plan.errs[i] = &RangeError{Target: target, Range: e.ip, Unban: true}
+			}
+			continue
+		}
+
+		if err := op.Profile.Validate(); err != nil {
+			plan.errs[i] = err
+			continue
+		}
+		if check != nil {
+			if err := check(target, op.Profile); err != nil {
+				plan.errs[i] = err
+				continue
+			}
+		}
+		if e := covering(prefix); e != nil {
+			plan.errs[i] = &RangeError{Target: target, Range: e.ip}
+			continue
+		}
+		if j := find(prefix); j >= 0 {
+			if state[j].profile.Equal(op.Profile) {
+				continue
+			}
+			remove(j, i)
+		}
+		for j := len(state) - 1; j >= 0; j-- {
+			if contains(prefix, state[j].prefix) {
+				remove(j, i)
+			}
+		}
+		state = append(state, batchEntry{prefix: prefix, ip: target, profile: op.Profile, pending: true, op: i})
+	}
+
+	for _, e := range state {
+		if e.pending {
+			plan.add = append(plan.add, batchChange{op: e.op, ip: e.ip, profile: e.profile})
+		}
+	}
+	sort.SliceStable(plan.add, func(i, j int) bool { return plan.add[i].op < plan.add[j].op })
+	return plan
+}
+
+// groupChanges agrupa as alterações pela chave informada (o set de destino,
+// por exemplo), na ordem em que cada chave aparece, para que a preparação e
+// a verificação da ordem sejam feitas uma vez por grupo
+func groupChanges(changes []batchChange, key func(batchChange) string) [][]batchChange {
+	var groups [][]batchChange
+	index := make(map[string]int)
+	for _, c := range changes {
+		k := key(c)
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], c)
+	}
+	return groups
+}
@@ -0,0 +1,140 @@
+package firewall
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mtm/guardian/internal/config"
+)
+
+// TestPlanBatch testa a validação de um lote contra os banimentos atuais e
+// entre os próprios itens
+func TestPlanBatch(t *testing.T) {
+	bans := []Ban{
+		{IP: "203.0.113.7", Action: config.ActionDrop, Rules: []string{"set banned4"}},
+		{IP: "198.51.100.0/24", Action: config.ActionDrop},
+	}
+	drop := BanProfile{Action: config.ActionDrop}
+	reject := BanProfile{Action: config.ActionReject}
+	ops := []BatchOp{
+		{IP: "203.0.113.7", Profile: drop},   // 0: já banido com o mesmo perfil
+		{IP: "198.51.100.9", Profile: drop},  // 1: contido em uma rede banida
+		{IP: "198.51.100.9", Unban: true},    // 2: idem, no desbanimento
+		{IP: "198.18.0.1", Profile: drop},    // 3: unificado pela rede do item 4
+		{IP: "198.18.0.0/16", Profile: drop}, // 4
+		{IP: "203.0.113.7", Profile: reject}, // 5: substituição do perfil
+		{IP: "192.0.2.1", Unban: true},       // 6: não banido
+		{IP: "invalido", Profile: drop},      // 7
+		{IP: "192.0.2.2", Profile: BanProfile{Protocols: []string{"tcp"}, Action: config.ActionTarpit}}, // 8
+	}
+	plan := planBatch(bans, ops, func(ip string, profile BanProfile) error {
+		if profile.Action == config.ActionTarpit {
+			return &UnsupportedActionError{Firewall: "teste", Action: profile.Action}
+		}
+		return nil
+	})
+
+	for _, i := range []int{0, 3, 4, 5, 6} {
+		if plan.errs[i] != nil {
+			t.Errorf("Item %d: erro inesperado: %v", i, plan.errs[i])
+		}
+	}
+	if err, ok := plan.errs[1].(*RangeError); !ok || err.Range != "198.51.100.0/24" || err.Unban {
+		t.Errorf("Item 1: esperado RangeError, obtido %v", plan.errs[1])
+	}
+	if err, ok := plan.errs[2].(*RangeError); !ok || !err.Unban {
+		t.Errorf("Item 2: esperado RangeError no desbanimento, obtido %v", plan.errs[2])
+	}
+	if plan.errs[7] == nil {
+		t.Error("Item 7: esperado erro para alvo inválido")
+	}
+	if _, ok := plan.errs[8].(*UnsupportedActionError); !ok {
+		t.Errorf("Item 8: esperado UnsupportedActionError, obtido %v", plan.errs[8])
+	}
+
+	// O IP unificado pela rede não chega ao backend
+	if len(plan.add) != 2 || plan.add[0].ip != "198.18.0.0/16" || plan.add[1].ip != "203.0.113.7" || plan.add[1].op != 5 {
+		t.Errorf("Adições inesperadas: %+v", plan.add)
+	}
+	if len(plan.remove) != 1 || plan.remove[0].ip != "203.0.113.7" || plan.remove[0].rules[0] != "set banned4" {
+		t.Errorf("Remoções inesperadas: %+v", plan.remove)
+	}
+}
+
+// TestNFTablesBatch testa que o lote é aplicado ao nftables em um único script
+func TestNFTablesBatch(t *testing.T) {
+	r := NewFakeRunner()
+	fw := &NFTablesFirewall{runner: r}
+
+	table := "table inet guardian {\n\tset banned4 {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t\telements = { %s }\n\t}\n}\n"
+	r.On("nft list table inet guardian", strings.Replace(table, "%s", "203.0.113.7", 1), nil)
+	r.On("nft list chain inet guardian input", "table inet guardian {\n\tchain input {\n\t\tjump bans\n\t\tip saddr @banned4 drop\n\t\tct state established,related accept\n\t}\n}\n", nil)
+	r.OnInput(func(string) {
+		r.On("nft list table inet guardian", strings.Replace(table, "%s", "198.51.100.1, 198.18.0.1", 1), nil)
+	})
+
+	drop := BanProfile{Action: config.ActionDrop}
+	errs := fw.Batch([]BatchOp{
+		{IP: "198.51.100.1", Profile: drop},
+		{IP: "198.18.0.1", Profile: drop},
+		{IP: "203.0.113.7", Unban: true},
+		{IP: "198.51.100.2", Profile: BanProfile{Protocols: []string{"tcp"}, Action: config.ActionTarpit}},
+	})
+	for i, err := range errs[:3] {
+		if err != nil {
+			t.Errorf("Item %d: erro inesperado: %v", i, err)
+		}
+	}
+	if _, ok := errs[3].(*UnsupportedActionError); !ok {
+		t.Errorf("Esperado UnsupportedActionError para tarpit, obtido %v", errs[3])
+	}
+
+	expected := "delete element inet guardian banned4 { 203.0.113.7 }\nadd element inet guardian banned4 { 198.51.100.1, 198.18.0.1 }\n"
+	if inputs := r.Inputs(); len(inputs) != 1 || inputs[0] != expected {
+		t.Errorf("Scripts inesperados: %q", inputs)
+	}
+}
+
+// TestIPTablesBatch testa que o lote é aplicado aos ipsets com um único
+// ipset restore
+func TestIPTablesBatch(t *testing.T) {
+	r := NewFakeRunner()
+	fw := &IPTablesFirewall{runner: r}
+
+	members := "Name: guardian-ip4\nType: hash:ip\nMembers:\n%s\n"
+	r.On("ipset list -n", "guardian-ip4\nguardian-net4\nguardian-v4\n", nil)
+	r.On("ipset list guardian-ip4", strings.Replace(members, "%s", "203.0.113.7", 1), nil)
+	r.On("iptables -S INPUT", "-P INPUT ACCEPT\n-A INPUT -j GUARDIAN\n-A INPUT -p tcp --dport 22 -j ACCEPT\n", nil)
+	r.On("iptables -S GUARDIAN", "-N GUARDIAN\n-A GUARDIAN -m set --match-set guardian-v4 src -p tcp -m multiport --dports 22,80,443,4554 -j DROP\n", nil)
+	r.On("iptables -C GUARDIAN", "iptables: Bad rule", errExit)
+	r.OnInput(func(string) {
+		r.On("ipset list guardian-ip4", strings.Replace(members, "%s", "198.51.100.1", 1), nil)
+	})
+
+	errs := fw.Batch([]BatchOp{
+		{IP: "203.0.113.7", Unban: true},
+		{IP: "198.51.100.1", Profile: config.DefaultBanProfile()},
+	})
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatalf("Erros inesperados: %v", errs)
+	}
+	assertCalls(t, r,
+		"ipset create guardian-ip4 hash:ip family inet -exist",
+		"iptables -I GUARDIAN 1 -m set --match-set guardian-v4 src -p tcp -m multiport --dports 22,80,443,4554 -j DROP",
+		"ipset restore -exist",
+		"sh -c ipset save > /etc/iptables/ipsets",
+	)
+	if inputs := r.Inputs(); len(inputs) != 1 || inputs[0] != "del guardian-ip4 203.0.113.7\nadd guardian-ip4 198.51.100.1\n" {
+		t.Errorf("Entrada inesperada do ipset restore: %q", inputs)
+	}
+
+	// A falha do restore é atribuída a todos os itens aplicados
+	r.On("ipset restore", "ipset v7.15: Error in line 1", errExit)
+	errs = fw.Batch([]BatchOp{
+		{IP: "198.51.100.1", Unban: true},
+		{IP: "198.51.100.1", Unban: true},
+	})
+	if errs[0] == nil || !strings.Contains(errs[0].Error(), "Error in line 1") || errs[1] != nil {
+		t.Errorf("Erros inesperados: %v", errs)
+	}
+}
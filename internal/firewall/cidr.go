@@ -0,0 +1,181 @@
+package firewall
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// RangeError indica um conflito entre o alvo e o banimento de uma rede que o
+// contém: o alvo já está bloqueado e não pode ser banido nem desbanido
+// isoladamente
+type RangeError struct {
+	Target string
+	Range  string
+	Unban  bool
+}
+
+func (e *RangeError) Error() string {
+	if e.Unban {
+		return fmt.Sprintf("não é possível desbanir %s: o endereço está contido no banimento de %s", e.Target, e.Range)
+	}
+	return fmt.Sprintf("%s já está bloqueado pelo banimento de %s", e.Target, e.Range)
+}
+
+// ParseTarget interpreta um alvo de banimento: um endereço IPv4 ou IPv6 ou
+// uma rede em notação CIDR. Redes são normalizadas para o endereço de rede
+// ("10.0.0.7/24" vira "10.0.0.0/24") e endereços são tratados como prefixos
+// de tamanho máximo.
+func ParseTarget(target string) (netip.Prefix, error) {
+	target = strings.TrimSpace(target)
+
+	if strings.Contains(target, "/") {
+		prefix, err := netip.ParsePrefix(target)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("rede inválida: %s", target)
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(target)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("endereço IP inválido: %s", target)
+	}
+	addr = addr.Unmap().WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// FormatTarget formata um alvo no formato usado pelos backends: o endereço
+// puro para prefixos de tamanho máximo e a notação CIDR para redes
+func FormatTarget(prefix netip.Prefix) string {
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+	return prefix.String()
+}
+
+// NormalizeTarget valida um alvo e retorna sua forma canônica
+func NormalizeTarget(target string) (string, error) {
+	prefix, err := ParseTarget(target)
+	if err != nil {
+		return "", err
+	}
+	return FormatTarget(prefix), nil
+}
+
+// contains verifica se o prefixo outer contém inteiramente o prefixo inner
+func contains(outer, inner netip.Prefix) bool {
+	return outer.Bits() <= inner.Bits() && outer.Contains(inner.Addr())
+}
+
+// Covers indica se a rede outer contém inteiramente o alvo inner. Alvos
+// inválidos não são cobertos.
+func Covers(outer, inner string) bool {
+	o, err := ParseTarget(outer)
+	if err != nil {
+		return false
+	}
+	i, err := ParseTarget(inner)
+	if err != nil {
+		return false
+	}
+	return contains(o, i)
+}
+
+// coveringBan retorna o banimento de uma rede que contém o alvo, sem ser o
+// próprio alvo
+func coveringBan(bans []Ban, target netip.Prefix) *Ban {
+	for i := range bans {
+		prefix, err := ParseTarget(bans[i].IP)
+		if err != nil || prefix == target {
+			continue
+		}
+		if contains(prefix, target) {
+			return &bans[i]
+		}
+	}
+	return nil
+}
+
+// prepareBan normaliza o alvo de um BanIP, valida o perfil e trata
+// sobreposições: um alvo já coberto por uma rede banida é recusado e os
+// banimentos contidos no alvo são removidos, já que passam a ser cobertos por
+// ele. Um banimento do próprio alvo com outro perfil é removido para ser
+// substituído.
+func prepareBan(fw Firewall, target string, profile BanProfile) (string, error) {
+	prefix, err := ParseTarget(target)
+	if err != nil {
+		return "", err
+	}
+	target = FormatTarget(prefix)
+
+	if err := profile.Validate(); err != nil {
+		return "", err
+	}
+
+	bans, err := fw.ListBanned()
+	if err != nil {
+		return "", err
+	}
+
+	if ban := coveringBan(bans, prefix); ban != nil {
+		return "", &RangeError{Target: target, Range: ban.IP}
+	}
+
+	if ban := FindBan(bans, target); ban != nil && !ban.Profile().Equal(profile) {
+		if err := fw.UnbanIP(ban.IP); err != nil {
+			return "", fmt.Errorf("erro ao substituir o banimento de %s: %w", ban.IP, err)
+		}
+	}
+
+	for _, ban := range bans {
+		inner, err := ParseTarget(ban.IP)
+		if err != nil || inner == prefix || !contains(prefix, inner) {
+			continue
+		}
+		if err := fw.UnbanIP(ban.IP); err != nil {
+			return "", fmt.Errorf("erro ao unificar o banimento de %s em %s: %w", ban.IP, target, err)
+		}
+	}
+
+	return target, nil
+}
+
+// prepareUnban normaliza o alvo de um UnbanIP e recusa o desbanimento de um
+// endereço que só está bloqueado por estar contido em uma rede banida
+func prepareUnban(fw Firewall, target string) (string, error) {
+	prefix, err := ParseTarget(target)
+	if err != nil {
+		return "", err
+	}
+	target = FormatTarget(prefix)
+
+	bans, err := fw.ListBanned()
+	if err != nil {
+		return "", err
+	}
+
+	if FindBan(bans, target) == nil {
+		if ban := coveringBan(bans, prefix); ban != nil {
+			return "", &RangeError{Target: target, Range: ban.IP, Unban: true}
+		}
+	}
+
+	return target, nil
+}
+
+// BannedIn verifica se o alvo está banido, diretamente ou por estar contido
+// em uma rede banida
+func BannedIn(bans []Ban, target string) (bool, error) {
+	prefix, err := ParseTarget(target)
+	if err != nil {
+		return false, err
+	}
+	if FindBan(bans, FormatTarget(prefix)) != nil {
+		return true, nil
+	}
+	return coveringBan(bans, prefix) != nil, nil
+}
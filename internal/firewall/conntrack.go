@@ -0,0 +1,105 @@
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// conntrackSummary é o resumo que o conntrack escreve na saída de erro ao
+// remover ou listar entradas, como "3 flow entries have been deleted."
+var conntrackSummary = regexp.MustCompile(`(\d+) flow entr(?:y|ies) ha(?:s|ve) been (?:deleted|shown)`)
+
+// Conntrack encerra as conexões já estabelecidas de um IP banido. Uma regra
+// de bloqueio nova não afeta as sessões abertas antes dela, que continuam
+// passando pela regra de ESTABLISHED,RELATED; remover as entradas do
+// conntrack faz os próximos pacotes dessas sessões serem avaliados como
+// conexões novas e, portanto, bloqueados.
+type Conntrack struct {
+	runner Runner
+	// dryRun apenas conta as conexões que seriam encerradas
+	dryRun bool
+}
+
+// NewConntrack cria o encerramento de conexões executando o conntrack com o
+// runner informado. Em dry-run as entradas são listadas, não removidas.
+func NewConntrack(r Runner, dryRun bool) *Conntrack {
+	return &Conntrack{runner: r, dryRun: dryRun}
+}
+
+// Kill remove as entradas do conntrack com origem no IP ou rede, restritas
+// aos protocolos e portas do perfil, e retorna quantas conexões foram
+// encerradas (ou, em dry-run, quantas seriam)
+func (c *Conntrack) Kill(ip string, profile BanProfile) (int, error) {
+	if c.runner.LookPath("conntrack") != nil {
+		return 0, errors.New("conntrack não encontrado (instale o pacote conntrack)")
+	}
+
+	filters, err := conntrackFilters(ip, profile)
+	if err != nil {
+		return 0, err
+	}
+
+	command := "-D"
+	if c.dryRun {
+		command = "-L"
+	}
+
+	total := 0
+	for _, filter := range filters {
+		args := append([]string{command}, filter...)
+		output, err := c.runner.CombinedOutput("conntrack", args...)
+		// O conntrack termina com erro quando nenhuma entrada corresponde ao
+		// filtro; o resumo na saída indica que o comando em si funcionou
+		match := conntrackSummary.FindStringSubmatch(string(output))
+		if match == nil {
+			if err == nil {
+				continue
+			}
+			return total, fmt.Errorf("erro ao encerrar conexões de %s: 'conntrack %s': %w (%s)",
+				ip, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+		}
+		n, _ := strconv.Atoi(match[1])
+		total += n
+	}
+	return total, nil
+}
+
+// conntrackFilters retorna os filtros do conntrack que selecionam as
+// conexões iniciadas pelo IP ou rede nos protocolos e portas do perfil, um
+// por protocolo e porta
+func conntrackFilters(ip string, profile BanProfile) ([][]string, error) {
+	prefix, err := ParseTarget(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	base := []string{"-s", prefix.Addr().String()}
+	if prefix.Addr().Is6() {
+		base = append([]string{"-f", "ipv6"}, base...)
+	}
+	if !prefix.IsSingleIP() {
+		mask := net.IP(net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()))
+		base = append(base, "--mask-src", mask.String())
+	}
+
+	if len(profile.Protocols) == 0 {
+		return [][]string{base}, nil
+	}
+
+	var filters [][]string
+	for _, proto := range profile.Protocols {
+		filter := concat(base, []string{"-p", proto})
+		if profile.AllPorts() {
+			filters = append(filters, filter)
+			continue
+		}
+		for _, port := range profile.Ports {
+			filters = append(filters, concat(filter, []string{"--dport", strconv.Itoa(port)}))
+		}
+	}
+	return filters, nil
+}
@@ -0,0 +1,52 @@
+package firewall
+
+import (
+	"testing"
+
+	"github.com/mtm/guardian/internal/config"
+)
+
+// TestConntrackKill testa o encerramento das conexões estabelecidas de um IP
+// banido
+func TestConntrackKill(t *testing.T) {
+	r := NewFakeRunner()
+	r.On("conntrack -D -s 203.0.113.7 -p tcp --dport 22", "conntrack v1.4.6 (conntrack-tools): 2 flow entries have been deleted.", nil)
+	r.On("conntrack -D -s 203.0.113.7 -p tcp --dport 80", "conntrack v1.4.6 (conntrack-tools): 0 flow entries have been deleted.", errExit)
+
+	c := NewConntrack(r, false)
+	profile := config.BanProfile{Ports: []int{22, 80}, Protocols: []string{"tcp"}, Action: config.ActionDrop}
+	killed, err := c.Kill("203.0.113.7", profile)
+	if err != nil || killed != 2 {
+		t.Errorf("Esperadas 2 conexões encerradas, obtido %d (%v)", killed, err)
+	}
+
+	// Redes IPv6 usam a família e a máscara da rede
+	r.Reset()
+	r.On("conntrack", "conntrack v1.4.6 (conntrack-tools): 1 flow entries have been deleted.", nil)
+	if killed, err = c.Kill("2001:db8::/48", config.BanProfile{Action: config.ActionDrop}); err != nil || killed != 1 {
+		t.Errorf("Esperada 1 conexão encerrada, obtido %d (%v)", killed, err)
+	}
+	assertCalls(t, r, "conntrack -D -f ipv6 -s 2001:db8:: --mask-src ffff:ffff:ffff::")
+
+	// Em dry-run as conexões são apenas listadas
+	r.Reset()
+	r.On("conntrack -L", "tcp 6 431999 ESTABLISHED src=198.51.100.0 ...\nconntrack v1.4.6 (conntrack-tools): 1 flow entries have been shown.", nil)
+	killed, err = NewConntrack(r, true).Kill("198.51.100.0/24", config.BanProfile{Protocols: []string{"udp"}, Action: config.ActionDrop})
+	if err != nil || killed != 1 {
+		t.Errorf("Esperada 1 conexão listada, obtido %d (%v)", killed, err)
+	}
+	assertCalls(t, r, "conntrack -L -s 198.51.100.0 --mask-src 255.255.255.0 -p udp")
+
+	// Falhas do próprio comando são reportadas
+	r = NewFakeRunner()
+	c = NewConntrack(r, false)
+	r.On("conntrack", "conntrack v1.4.6 (conntrack-tools): Operation not permitted", errExit)
+	if _, err := c.Kill("203.0.113.7", profile); err == nil {
+		t.Error("Deveria retornar erro quando o conntrack falha")
+	}
+
+	r.Missing("conntrack")
+	if _, err := c.Kill("203.0.113.7", profile); err == nil {
+		t.Error("Deveria retornar erro sem o conntrack instalado")
+	}
+}
@@ -0,0 +1,102 @@
+package firewall
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Counters são os pacotes e bytes bloqueados pelas regras de um banimento
+// desde que foram criadas (ou desde o último reinício do host)
+type Counters struct {
+	IP      string `json:"ip"`
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// UnsupportedCountersError indica que o backend, na configuração atual, não
+// mantém contadores por banimento
+type UnsupportedCountersError struct {
+	Firewall string
+	Reason   string
+}
+
+func (e *UnsupportedCountersError) Error() string {
+	return fmt.Sprintf("contadores não suportados pelo %s: %s", e.Firewall, e.Reason)
+}
+
+// addCounters soma os contadores de uma regra aos do banimento do IP
+func addCounters(counters []Counters, ip string, packets, bytes uint64) []Counters {
+	target, err := NormalizeTarget(ip)
+	if err != nil {
+		return counters
+	}
+	for i := range counters {
+		if counters[i].IP == target {
+			counters[i].Packets += packets
+			counters[i].Bytes += bytes
+			return counters
+		}
+	}
+	return append(counters, Counters{IP: target, Packets: packets, Bytes: bytes})
+}
+
+// sortCounters ordena os contadores pelo IP
+func sortCounters(counters []Counters) []Counters {
+	sort.Slice(counters, func(i, j int) bool { return counters[i].IP < counters[j].IP })
+	return counters
+}
+
+// parseRuleCounters soma os contadores das regras de bloqueio (DROP, REJECT
+// ou TARPIT) com origem definida de uma listagem do iptables -S -v, nas
+// chains aceitas por chain
+func parseRuleCounters(counters []Counters, listing string, chain func(string) bool) []Counters {
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "-A" || !chain(fields[1]) {
+			continue
+		}
+
+		var ip, target string
+		var packets, bytes uint64
+		counted := false
+		for i := 2; i+1 < len(fields); i++ {
+			switch fields[i] {
+			case "-s":
+				ip = strings.TrimSuffix(strings.TrimSuffix(fields[i+1], "/32"), "/128")
+			case "-j":
+				target = fields[i+1]
+			case "-c":
+				if i+2 < len(fields) {
+					p, perr := strconv.ParseUint(fields[i+1], 10, 64)
+					b, berr := strconv.ParseUint(fields[i+2], 10, 64)
+					packets, bytes, counted = p, b, perr == nil && berr == nil
+				}
+			}
+		}
+		if ip == "" || !counted || (target != "DROP" && target != "REJECT" && target != "TARPIT") {
+			continue
+		}
+		counters = addCounters(counters, ip, packets, bytes)
+	}
+	return counters
+}
+
+// parseCounterFields extrai os contadores de um elemento de set listado com
+// "packets N bytes M" (ipset e nftables)
+func parseCounterFields(fields []string) (packets, bytes uint64, ok bool) {
+	var hasPackets, hasBytes bool
+	for i := 0; i+1 < len(fields); i++ {
+		var err error
+		switch fields[i] {
+		case "packets":
+			packets, err = strconv.ParseUint(fields[i+1], 10, 64)
+			hasPackets = err == nil
+		case "bytes":
+			bytes, err = strconv.ParseUint(fields[i+1], 10, 64)
+			hasBytes = err == nil
+		}
+	}
+	return packets, bytes, hasPackets && hasBytes
+}
@@ -0,0 +1,251 @@
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mtm/guardian/internal/config"
+)
+
+// detectionOrder é a ordem de preferência entre os gerenciadores de firewall.
+// Os gerenciadores de alto nível vêm antes porque escrevem suas regras no
+// nftables ou no iptables: com o firewalld ativo, as tabelas do nftables são
+// dele, não de um nftables gerenciado diretamente.
+var detectionOrder = []string{"firewalld", "ufw", "nftables", "iptables"}
+
+// fallbackOrder é a ordem usada quando nenhum gerenciador está ativo. O
+// nftables vem antes do iptables: nas distribuições atuais o iptables
+// instalado costuma ser o iptables-nft, uma camada sobre o próprio nftables.
+var fallbackOrder = []string{"ufw", "nftables", "iptables", "firewalld"}
+
+// Candidate é o resultado da verificação de um gerenciador de firewall
+type Candidate struct {
+	Type      string `json:"type"`
+	Installed bool   `json:"installed"`
+	Active    bool   `json:"active"`
+	Reason    string `json:"reason"`
+}
+
+// Detection registra como o firewall foi escolhido: o que foi encontrado em
+// cada gerenciador e por que o selecionado venceu
+type Detection struct {
+	Configured string      `json:"configured"` // GUARDIAN_FIREWALL_TYPE
+	Selected   string      `json:"selected"`
+	Reason     string      `json:"reason"`
+	Ambiguous  bool        `json:"ambiguous"`
+	Warnings   []string    `json:"warnings,omitempty"`
+	Candidates []Candidate `json:"candidates"`
+}
+
+// Lines retorna o diagnóstico da detecção, uma linha por item, para o log de
+// inicialização
+func (d *Detection) Lines() []string {
+	var lines []string
+	for _, c := range d.Candidates {
+		state := "não instalado"
+		if c.Installed && c.Active {
+			state = "instalado, ativo"
+		} else if c.Installed {
+			state = "instalado, inativo"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s (%s)", c.Type, state, c.Reason))
+	}
+	if d.Selected != "" {
+		lines = append(lines, fmt.Sprintf("selecionado: %s (%s)", d.Selected, d.Reason))
+	}
+	for _, warning := range d.Warnings {
+		lines = append(lines, "ATENÇÃO: "+warning)
+	}
+	return lines
+}
+
+// Detect verifica quais gerenciadores de firewall estão instalados e quais
+// estão de fato em uso (status do ufw, estado do firewalld, unidades do
+// systemd e tabelas do nftables) e escolhe o firewall a ser usado. Com um
+// tipo configurado, ele é selecionado, mas a verificação é feita do mesmo jeito
+// para o diagnóstico. Mais de um gerenciador ativo é reportado como ambíguo.
+func Detect(cfg *config.Config, r Runner) (*Detection, error) {
+	d := &Detection{Configured: cfg.FirewallType}
+	tables := nftTables(r)
+	checks := map[string]func(Runner, []string) Candidate{
+		"firewalld": checkFirewalld,
+		"ufw":       checkUFW,
+		"nftables":  checkNFTables,
+		"iptables":  checkIPTables,
+	}
+
+	var active []string
+	for _, t := range detectionOrder {
+		c := checks[t](r, tables)
+		d.Candidates = append(d.Candidates, c)
+		if c.Active {
+			active = append(active, t)
+		}
+	}
+
+	if len(active) > 1 {
+		d.Ambiguous = true
+		d.Warnings = append(d.Warnings, fmt.Sprintf("mais de um gerenciador de firewall ativo (%s); as regras podem ficar duplicadas ou conflitantes. Desative os que não estão em uso ou defina GUARDIAN_FIREWALL_TYPE",
+			strings.Join(active, ", ")))
+	}
+
+	if cfg.FirewallType != "auto" {
+		d.Selected = cfg.FirewallType
+		d.Reason = "definido em GUARDIAN_FIREWALL_TYPE"
+		if len(active) > 0 && !containsString(active, cfg.FirewallType) {
+			d.Warnings = append(d.Warnings, fmt.Sprintf("o firewall configurado (%s) não está ativo, mas %s está", cfg.FirewallType, strings.Join(active, ", ")))
+		}
+		return d, nil
+	}
+
+	switch {
+	case len(active) == 1:
+		d.Selected = active[0]
+		d.Reason = "único gerenciador ativo"
+	case len(active) > 1:
+		d.Selected = active[0]
+		d.Reason = fmt.Sprintf("preferido entre os ativos (ordem: %s)", strings.Join(detectionOrder, ", "))
+	default:
+		for _, t := range fallbackOrder {
+			if d.candidate(t).Installed {
+				d.Selected = t
+				d.Reason = "nenhum gerenciador ativo; primeiro instalado"
+				break
+			}
+		}
+	}
+
+	if d.Selected == "" {
+		return d, errors.New("nenhum firewall suportado encontrado")
+	}
+	return d, nil
+}
+
+// candidate retorna a verificação do gerenciador informado
+func (d *Detection) candidate(t string) Candidate {
+	for _, c := range d.Candidates {
+		if c.Type == t {
+			return c
+		}
+	}
+	return Candidate{Type: t}
+}
+
+// checkFirewalld verifica o firewalld com firewall-cmd --state
+func checkFirewalld(r Runner, tables []string) Candidate {
+	c := Candidate{Type: "firewalld"}
+	if r.LookPath("firewall-cmd") != nil {
+		c.Reason = "firewall-cmd não encontrado"
+		return c
+	}
+	c.Installed = true
+
+	output, _ := r.CombinedOutput("firewall-cmd", "--state")
+	state := strings.TrimSpace(string(output))
+	if state == "" {
+		state = "sem resposta"
+	}
+	c.Active = state == "running"
+	c.Reason = "firewall-cmd --state: " + state
+	if containsString(tables, "inet firewalld") {
+		c.Reason += "; tabela inet firewalld presente no nftables"
+	}
+	return c
+}
+
+// checkUFW verifica o UFW com ufw status
+func checkUFW(r Runner, tables []string) Candidate {
+	c := Candidate{Type: "ufw"}
+	if r.LookPath("ufw") != nil {
+		c.Reason = "ufw não encontrado"
+		return c
+	}
+	c.Installed = true
+
+	output, err := r.CombinedOutput("ufw", "status")
+	if err != nil {
+		c.Reason = "ufw status falhou"
+		return c
+	}
+	c.Active = strings.Contains(string(output), "Status: active")
+	if c.Active {
+		c.Reason = "ufw status: active"
+	} else {
+		c.Reason = "ufw status: inactive"
+	}
+	return c
+}
+
+// checkNFTables verifica o nftables: a unidade nftables do systemd ativa ou a
+// tabela do Guardian já criada. As tabelas de outros gerenciadores (firewalld
+// ou iptables-nft) não contam.
+func checkNFTables(r Runner, tables []string) Candidate {
+	c := Candidate{Type: "nftables"}
+	if r.LookPath("nft") != nil {
+		c.Reason = "nft não encontrado"
+		return c
+	}
+	c.Installed = true
+
+	var reasons []string
+	if succeeds(r, "systemctl", "is-active", "--quiet", "nftables") {
+		c.Active = true
+		reasons = append(reasons, "unidade nftables ativa")
+	} else {
+		reasons = append(reasons, "unidade nftables inativa")
+	}
+	if containsString(tables, "inet "+nftTable) {
+		c.Active = true
+		reasons = append(reasons, "tabela inet "+nftTable+" presente")
+	}
+	if len(tables) > 0 {
+		reasons = append(reasons, "tabelas: "+strings.Join(tables, ", "))
+	}
+	c.Reason = strings.Join(reasons, "; ")
+	return c
+}
+
+// checkIPTables verifica o iptables: a chain do Guardian já ligada à INPUT ou
+// a unidade netfilter-persistent ativa
+func checkIPTables(r Runner, tables []string) Candidate {
+	c := Candidate{Type: "iptables"}
+	if r.LookPath("iptables") != nil {
+		c.Reason = "iptables não encontrado"
+		return c
+	}
+	c.Installed = true
+
+	switch {
+	case succeeds(r, "iptables", "-C", "INPUT", "-j", guardianChain):
+		c.Active = true
+		c.Reason = "chain " + guardianChain + " ligada à INPUT"
+	case succeeds(r, "systemctl", "is-active", "--quiet", "netfilter-persistent"):
+		c.Active = true
+		c.Reason = "unidade netfilter-persistent ativa"
+	default:
+		c.Reason = "sem chain " + guardianChain + " nem netfilter-persistent ativo"
+	}
+	return c
+}
+
+// nftTables retorna as tabelas do nftables ("família nome"), ou nenhuma se o
+// nft não estiver disponível
+func nftTables(r Runner) []string {
+	if r.LookPath("nft") != nil {
+		return nil
+	}
+	output, err := r.Output("nft", "list", "tables")
+	if err != nil {
+		return nil
+	}
+
+	var tables []string
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "table" {
+			tables = append(tables, fields[1]+" "+fields[2])
+		}
+	}
+	return tables
+}
@@ -0,0 +1,231 @@
+package firewall
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mtm/guardian/internal/config"
+)
+
+const (
+	// dockerChain é a chain que o Docker reserva para as regras do
+	// administrador, avaliada na FORWARD antes das regras das portas
+	// publicadas
+	dockerChain = "DOCKER-USER"
+	// dockerComment identifica as regras do Guardian na DOCKER-USER
+	dockerComment = "guardian"
+)
+
+// dockerBans aplica os banimentos também ao tráfego encaminhado aos
+// containers do Docker. As portas publicadas chegam aos containers pela
+// FORWARD, depois do DNAT, e não passam pelas regras da INPUT. O valor zero
+// não aplica nem remove nada.
+type dockerBans struct {
+	runner Runner
+	mode   string // config.DockerAuto, config.DockerOn ou config.DockerOff
+}
+
+// enabled indica se os banimentos devem ir para a DOCKER-USER da família
+// (iptables ou ip6tables). No modo auto, apenas quando a chain existe, isto
+// é, com o Docker em execução; no modo on, a chain é criada se necessário e
+// passa a ser usada pelo Docker quando ele iniciar.
+func (d dockerBans) enabled(iptables string) (bool, error) {
+	if d.mode != config.DockerAuto && d.mode != config.DockerOn {
+		return false, nil
+	}
+	if succeeds(d.runner, iptables, "-n", "-L", dockerChain) {
+		return true, nil
+	}
+	if d.mode == config.DockerAuto {
+		return false, nil
+	}
+	if err := runCmd(d.runner, iptables, "-N", dockerChain); err != nil {
+		return false, fmt.Errorf("erro ao criar a chain %s no %s: %w", dockerChain, iptables, err)
+	}
+	return true, nil
+}
+
+// detected indica, para os backends que não usam o iptables, se o Docker
+// está em execução (a DOCKER-USER existe no iptables-nft ou no iptables)
+// ou se o modo on foi configurado
+func (d dockerBans) detected() bool {
+	switch d.mode {
+	case config.DockerOn:
+		return true
+	case config.DockerAuto:
+		return succeeds(d.runner, "iptables", "-n", "-L", dockerChain)
+	}
+	return false
+}
+
+// ensureRules insere no topo da DOCKER-USER as regras que ainda não existem
+func (d dockerBans) ensureRules(iptables string, rules [][]string) error {
+	for _, rule := range rules {
+		if succeeds(d.runner, iptables, append([]string{"-C"}, rule...)...) {
+			continue
+		}
+		rule = append([]string{rule[0], "1"}, rule[1:]...)
+		if err := runCmd(d.runner, iptables, append([]string{"-I"}, rule...)...); err != nil {
+			return fmt.Errorf("erro ao criar regra de banimento na %s do %s: %w", dockerChain, iptables, err)
+		}
+	}
+	return nil
+}
+
+// banIP insere as regras do IP na DOCKER-USER, para os backends cujas
+// regras são por IP (UFW e firewalld)
+func (d dockerBans) banIP(ip string, profile BanProfile) error {
+	iptables, err := dockerIPTables(ip)
+	if err != nil {
+		return err
+	}
+	on, err := d.enabled(iptables)
+	if err != nil || !on {
+		return err
+	}
+	return d.ensureRules(iptables, dockerRules([]string{"-s", ip}, profile))
+}
+
+// unbanIP remove da DOCKER-USER as regras do Guardian com o IP como origem.
+// A remoção não depende do modo configurado, para não deixar regras para
+// trás quando o modo é desligado.
+func (d dockerBans) unbanIP(ip string) error {
+	if d.runner == nil {
+		return nil
+	}
+	iptables, err := dockerIPTables(ip)
+	if err != nil {
+		return err
+	}
+	output, err := d.runner.CombinedOutput(iptables, "-S", dockerChain)
+	if err != nil {
+		return nil // Sem a DOCKER-USER não há o que remover
+	}
+	for _, rule := range iptablesRulesFor(ip, string(output)) {
+		if err := d.deleteRule(iptables, rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeAll remove todas as regras do Guardian da DOCKER-USER
+func (d dockerBans) removeAll() error {
+	if d.runner == nil {
+		return nil
+	}
+	for _, iptables := range []string{"iptables", "ip6tables"} {
+		output, err := d.runner.CombinedOutput(iptables, "-S", dockerChain)
+		if err != nil {
+			continue
+		}
+		for _, rule := range strings.Split(string(output), "\n") {
+			if err := d.deleteRule(iptables, strings.TrimSpace(rule)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteRule remove uma regra de uma listagem do iptables -S DOCKER-USER,
+// se for do Guardian
+func (d dockerBans) deleteRule(iptables, rule string) error {
+	fields := strings.Fields(rule)
+	if len(fields) < 2 || fields[0] != "-A" || !strings.Contains(rule, "--comment "+dockerComment) {
+		return nil
+	}
+	if err := runCmd(d.runner, iptables, append([]string{"-D"}, fields[1:]...)...); err != nil {
+		return fmt.Errorf("erro ao remover regra da %s: %w", dockerChain, err)
+	}
+	return nil
+}
+
+// dockerRules retorna as especificações das regras da DOCKER-USER que
+// aplicam o perfil à origem definida em match. As portas do perfil são as
+// portas publicadas no host, comparadas com o destino original da conexão
+// (antes do DNAT); o conntrack aceita uma porta por regra. O TARPIT só vale
+// para conexões com o próprio host, então vira DROP.
+func dockerRules(match []string, profile BanProfile) [][]string {
+	target := strings.ToUpper(profile.Action)
+	if profile.Action == config.ActionTarpit {
+		target = "DROP"
+	}
+	suffix := []string{"-m", "comment", "--comment", dockerComment, "-j", target}
+	base := append([]string{dockerChain}, match...)
+
+	if len(profile.Protocols) == 0 {
+		return [][]string{concat(base, suffix)}
+	}
+
+	var rules [][]string
+	for _, proto := range profile.Protocols {
+		rule := concat(base, []string{"-p", proto})
+		if profile.AllPorts() {
+			rules = append(rules, concat(rule, suffix))
+			continue
+		}
+		for _, port := range profile.Ports {
+			rules = append(rules, concat(rule, []string{"-m", "conntrack", "--ctorigdstport", strconv.Itoa(port)}, suffix))
+		}
+	}
+	return rules
+}
+
+// dockerIPTables retorna o comando do iptables da família do IP
+func dockerIPTables(ip string) (string, error) {
+	family, err := ipFamily(ip)
+	if err != nil {
+		return "", err
+	}
+	if family == "ipv6" {
+		return "ip6tables", nil
+	}
+	return "iptables", nil
+}
+
+// concat junta listas de argumentos em uma nova lista
+func concat(lists ...[]string) []string {
+	var all []string
+	for _, list := range lists {
+		all = append(all, list...)
+	}
+	return all
+}
+
+// dockerSyncer é implementado pelos backends que aplicam os banimentos na
+// DOCKER-USER
+type dockerSyncer interface {
+	syncDocker() error
+}
+
+// SyncDocker reaplica os banimentos existentes ao tráfego dos containers do
+// Docker, conforme GUARDIAN_DOCKER_BANS. As regras por IP da DOCKER-USER não
+// são persistidas pelo UFW nem pelo firewalld e se perdem num reinício do
+// host; por isso a sincronização é feita na inicialização. Firewalls que não
+// são backends (como os decoradores) são ignorados.
+func SyncDocker(fw Firewall) error {
+	if s, ok := fw.(dockerSyncer); ok {
+		return s.syncDocker()
+	}
+	return nil
+}
+
+// syncBans insere na DOCKER-USER as regras dos banimentos listados, para os
+// backends cujas regras são por IP
+func (d dockerBans) syncBans(fw Firewall) error {
+	if d.mode != config.DockerAuto && d.mode != config.DockerOn {
+		return nil
+	}
+	bans, err := fw.ListBanned()
+	if err != nil {
+		return err
+	}
+	for _, ban := range bans {
+		if err := d.banIP(ban.IP, ban.Profile()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mtm/guardian/internal/config"
+)
+
+// DryRunAction é uma alteração que o modo dry-run deixou de aplicar
+type DryRunAction struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"` // enable, disable, ban, unban ou restore
+	Target  string    `json:"target,omitempty"`
+	Profile string    `json:"profile,omitempty"`
+}
+
+// DryRunFirewall registra as alterações (Enable, Disable, BanIP, UnbanIP,
+// Batch e Restore) em vez de aplicá-las. As consultas são repassadas ao
+// firewall real e combinadas com as alterações simuladas, para que a API e o
+// detector se comportem como se elas tivessem sido aplicadas.
+type DryRunFirewall struct {
+	Firewall
+
+	mu      sync.Mutex
+	path    string
+	enabled *bool                 // Estado simulado; nil enquanto não houver Enable/Disable
+	banned  map[string]BanProfile // Banimentos simulados
+	removed map[string]bool       // Banimentos reais desfeitos na simulação
+	actions []DryRunAction
+}
+
+// NewDryRun envolve o firewall no modo dry-run. As ações são registradas no
+// log e, se path não for vazio, acrescentadas a ele em JSON, uma por linha.
+func NewDryRun(fw Firewall, path string) *DryRunFirewall {
+	return &DryRunFirewall{
+		Firewall: fw,
+		path:     path,
+		banned:   make(map[string]BanProfile),
+		removed:  make(map[string]bool),
+	}
+}
+
+// Actions retorna as ações registradas, na ordem
+func (f *DryRunFirewall) Actions() []DryRunAction {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]DryRunAction(nil), f.actions...)
+}
+
+func (f *DryRunFirewall) IsEnabled() (bool, error) {
+	f.mu.Lock()
+	enabled := f.enabled
+	f.mu.Unlock()
+
+	if enabled != nil {
+		return *enabled, nil
+	}
+	return f.Firewall.IsEnabled()
+}
+
+func (f *DryRunFirewall) Enable() error {
+	return f.setEnabled(true, "enable")
+}
+
+func (f *DryRunFirewall) Disable() error {
+	return f.setEnabled(false, "disable")
+}
+
+// BanIP valida o banimento como os backends (perfil, redes sobrepostas e
+// banimentos substituídos) e o registra sem aplicá-lo
+func (f *DryRunFirewall) BanIP(ip string, profile BanProfile) error {
+	if profile.Action == config.ActionTarpit && f.Type() != "iptables" {
+		return &UnsupportedActionError{Firewall: f.Type(), Action: profile.Action}
+	}
+
+	ip, err := prepareBan(f, ip, profile)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.banned[ip] = profile
+	delete(f.removed, ip)
+	return f.record(DryRunAction{Action: "ban", Target: ip, Profile: profile.String()})
+}
+
+// UnbanIP registra o desbanimento sem aplicá-lo
+func (f *DryRunFirewall) UnbanIP(ip string) error {
+	ip, err := prepareUnban(f, ip)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.banned, ip)
+	f.removed[ip] = true
+	return f.record(DryRunAction{Action: "unban", Target: ip})
+}
+
+// Batch registra cada alteração do lote como no BanIP e no UnbanIP
+func (f *DryRunFirewall) Batch(ops []BatchOp) []error {
+	return batchEach(f, ops)
+}
+
+// CheckBanOrder não tem o que verificar para banimentos simulados
+func (f *DryRunFirewall) CheckBanOrder(ip string) error {
+	f.mu.Lock()
+	_, simulated := f.banned[ip]
+	f.mu.Unlock()
+
+	if simulated {
+		return nil
+	}
+	return f.Firewall.CheckBanOrder(ip)
+}
+
+// ListBanned retorna os banimentos reais, sem os desfeitos na simulação,
+// junto com os banimentos simulados
+func (f *DryRunFirewall) ListBanned() ([]Ban, error) {
+	current, err := f.Firewall.ListBanned()
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var bans []Ban
+	for _, ban := range current {
+		target, err := NormalizeTarget(ban.IP)
+		if err == nil && f.removed[target] {
+			continue
+		}
+		if _, simulated := f.banned[target]; simulated {
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	for ip, profile := range f.banned {
+		family, _ := ipFamily(ip)
+		bans = addProfileRule(bans, ip, family, profile, "dry-run")
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].IP < bans[j].IP })
+	return bans, nil
+}
+
+func (f *DryRunFirewall) IsBanned(ip string) (bool, error) {
+	bans, err := f.ListBanned()
+	if err != nil {
+		return false, err
+	}
+	return BannedIn(bans, ip)
+}
+
+// Restore registra a restauração sem aplicá-la. A cópia em si é tirada do
+// firewall real, já que apenas lê as regras.
+func (f *DryRunFirewall) Restore(s *Snapshot) error {
+	if err := checkSnapshot(f, s); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.enabled = nil
+	f.banned = make(map[string]BanProfile)
+	f.removed = make(map[string]bool)
+	return f.record(DryRunAction{Action: "restore"})
+}
+
+// setEnabled registra a ativação ou desativação simulada
+func (f *DryRunFirewall) setEnabled(enabled bool, action string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.enabled = &enabled
+	return f.record(DryRunAction{Action: action})
+}
+
+// record registra a ação no log e no arquivo. Deve ser chamado com o mutex
+// travado.
+func (f *DryRunFirewall) record(action DryRunAction) error {
+	action.Time = time.Now().UTC()
+	f.actions = append(f.actions, action)
+	log.Printf("[dry-run] %s: %s", f.Type(), strings.TrimSpace(action.Action+" "+action.Target+" "+action.Profile))
+
+	if f.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(action)
+	if err != nil {
+		return fmt.Errorf("erro ao serializar ação do dry-run: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return fmt.Errorf("erro ao criar diretório do dry-run: %w", err)
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("erro ao registrar ação do dry-run: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("erro ao registrar ação do dry-run: %w", err)
+	}
+	return nil
+}
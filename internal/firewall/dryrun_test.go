@@ -0,0 +1,91 @@
+package firewall
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mtm/guardian/internal/config"
+)
+
+// TestDryRun testa o registro das alterações sem aplicá-las
+func TestDryRun(t *testing.T) {
+	mock := NewMockFirewall()
+	mock.BanIP("198.51.100.1", config.DefaultBanProfile())
+
+	path := filepath.Join(t.TempDir(), "dry-run", "actions.jsonl")
+	fw := NewDryRun(mock, path)
+
+	if err := fw.Enable(); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	if enabled, _ := fw.IsEnabled(); !enabled {
+		t.Error("Ativação simulada deveria ser refletida no IsEnabled")
+	}
+	if enabled, _ := mock.IsEnabled(); enabled {
+		t.Error("Firewall real não deveria ter sido ativado")
+	}
+
+	// Banimento simulado: visível nas consultas, ausente no firewall real
+	if err := fw.BanIP("203.0.113.0/24", config.DefaultBanProfile()); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	if banned, _ := fw.IsBanned("203.0.113.7"); !banned {
+		t.Error("Banimento simulado deveria ser refletido no IsBanned")
+	}
+	if banned, _ := mock.IsBanned("203.0.113.7"); banned {
+		t.Error("Firewall real não deveria ter sido alterado")
+	}
+
+	// As validações dos backends continuam valendo
+	if _, ok := fw.BanIP("203.0.113.7", config.DefaultBanProfile()).(*RangeError); !ok {
+		t.Error("Esperado RangeError para IP contido em rede banida na simulação")
+	}
+	tarpit := BanProfile{Protocols: []string{"tcp"}, Action: config.ActionTarpit}
+	if _, ok := fw.BanIP("192.0.2.1", tarpit).(*UnsupportedActionError); !ok {
+		t.Error("Esperado UnsupportedActionError para tarpit fora do iptables")
+	}
+
+	// Desbanimento simulado de um banimento real
+	if err := fw.UnbanIP("198.51.100.1"); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	if banned, _ := fw.IsBanned("198.51.100.1"); banned {
+		t.Error("Desbanimento simulado deveria ser refletido no IsBanned")
+	}
+	if banned, _ := mock.IsBanned("198.51.100.1"); !banned {
+		t.Error("Banimento real não deveria ter sido removido")
+	}
+
+	expected := []string{"enable", "ban 203.0.113.0/24", "unban 198.51.100.1"}
+	actions := fw.Actions()
+	if len(actions) != len(expected) {
+		t.Fatalf("Ações inesperadas: %+v", actions)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Erro ao abrir registro: %v", err)
+	}
+	defer file.Close()
+
+	i := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); i++ {
+		var action DryRunAction
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+			t.Fatalf("Linha inválida no registro: %v", err)
+		}
+		got := action.Action
+		if action.Target != "" {
+			got += " " + action.Target
+		}
+		if i >= len(expected) || got != expected[i] {
+			t.Errorf("Ação %d: %q", i, got)
+		}
+	}
+	if i != len(expected) {
+		t.Errorf("Registro com %d ações, esperadas %d", i, len(expected))
+	}
+}
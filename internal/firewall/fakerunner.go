@@ -0,0 +1,132 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FakeRunner implementa a interface Runner para testes: registra as linhas de
+// comando executadas e responde com as saídas cadastradas em On. Comandos sem
+// resposta cadastrada terminam sem erro e sem saída.
+type FakeRunner struct {
+	mu        sync.Mutex
+	calls     []string
+	inputs    []string
+	responses []fakeResponse
+	missing   map[string]bool
+	onInput   func(input string)
+}
+
+// fakeResponse é a resposta para as linhas de comando que começam com prefix
+type fakeResponse struct {
+	prefix string
+	output string
+	err    error
+}
+
+func NewFakeRunner() *FakeRunner {
+	return &FakeRunner{missing: make(map[string]bool)}
+}
+
+// On cadastra a saída e o erro dos comandos cuja linha começa com cmdline
+// (palavras inteiras). Vale a resposta com o prefixo mais longo; entre
+// prefixos iguais, a cadastrada por último.
+func (r *FakeRunner) On(cmdline, output string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.responses = append(r.responses, fakeResponse{prefix: cmdline, output: output, err: err})
+}
+
+// OnInput registra uma função chamada depois de cada RunInput com o que foi
+// enviado na entrada padrão, para que o teste simule o efeito do script
+// atualizando as respostas com On
+func (r *FakeRunner) OnInput(fn func(input string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onInput = fn
+}
+
+// Missing marca comandos como não instalados para o LookPath
+func (r *FakeRunner) Missing(names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, name := range names {
+		r.missing[name] = true
+	}
+}
+
+// Calls retorna as linhas de comando executadas, na ordem
+func (r *FakeRunner) Calls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.calls...)
+}
+
+// Inputs retorna o que foi enviado na entrada padrão dos comandos
+// executados com RunInput, na ordem
+func (r *FakeRunner) Inputs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.inputs...)
+}
+
+// Reset descarta as chamadas registradas, mantendo as respostas
+func (r *FakeRunner) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls, r.inputs = nil, nil
+}
+
+func (r *FakeRunner) CombinedOutput(name string, args ...string) ([]byte, error) {
+	return r.run(name, args)
+}
+
+func (r *FakeRunner) Output(name string, args ...string) ([]byte, error) {
+	return r.run(name, args)
+}
+
+func (r *FakeRunner) RunInput(input string, name string, args ...string) ([]byte, error) {
+	r.mu.Lock()
+	r.inputs = append(r.inputs, input)
+	fn := r.onInput
+	r.mu.Unlock()
+
+	output, err := r.run(name, args)
+	if fn != nil {
+		fn(input)
+	}
+	return output, err
+}
+
+func (r *FakeRunner) LookPath(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.missing[name] {
+		return fmt.Errorf("exec: %q: executable file not found in $PATH", name)
+	}
+	return nil
+}
+
+// run registra a linha de comando e retorna a resposta cadastrada
+func (r *FakeRunner) run(name string, args []string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cmdline := strings.Join(append([]string{name}, args...), " ")
+	r.calls = append(r.calls, cmdline)
+
+	var match *fakeResponse
+	for i, resp := range r.responses {
+		if cmdline != resp.prefix && !strings.HasPrefix(cmdline, resp.prefix+" ") {
+			continue
+		}
+		if match == nil || len(resp.prefix) >= len(match.prefix) {
+			match = &r.responses[i]
+		}
+	}
+	if match == nil {
+		return nil, nil
+	}
+	return []byte(match.output), match.err
+}
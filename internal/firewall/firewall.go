@@ -1,9 +1,10 @@
 package firewall
 
 import (
-	"errors"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
-	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/mtm/guardian/internal/config"
@@ -14,56 +15,238 @@ type Firewall interface {
 	IsEnabled() (bool, error)
 	Enable() error
 	Disable() error
-	BanIP(ip string) error
+	// BanIP bane o IP com o escopo definido no perfil. Ações que o backend
+	// não suporta retornam um UnsupportedActionError.
+	BanIP(ip string, profile BanProfile) error
 	UnbanIP(ip string) error
+	// Batch aplica vários banimentos e desbanimentos com as mesmas validações
+	// do BanIP e do UnbanIP, de uma vez quando o backend permite. Retorna o
+	// erro de cada item, na ordem do lote (nil para os aplicados).
+	Batch(ops []BatchOp) []error
+	// CheckBanOrder confere, no caminho real dos pacotes, que o banimento do
+	// IP é avaliado antes de qualquer regra de liberação
+	CheckBanOrder(ip string) error
+	// ListBanned retorna os banimentos presentes no firewall, lidos das
+	// listagens do próprio backend
+	ListBanned() ([]Ban, error)
+	IsBanned(ip string) (bool, error)
+	// Counters retorna os pacotes e bytes bloqueados por cada banimento.
+	// Backends sem contadores na configuração atual retornam um
+	// UnsupportedCountersError.
+	Counters() ([]Counters, error)
+	// Snapshot salva o estado atual do firewall para que uma alteração
+	// possa ser desfeita com Restore
+	Snapshot() (*Snapshot, error)
+	Restore(s *Snapshot) error
 	Type() string
 }
 
-// New cria uma nova instância do firewall apropriado
-func New(cfg *config.Config) (Firewall, error) {
-	if cfg.FirewallType != "auto" {
-		return createFirewall(cfg.FirewallType)
-	}
+// BanProfile define as portas, os protocolos e a ação de um banimento
+type BanProfile = config.BanProfile
 
-	// Detectar automaticamente o firewall
-	firewallType, err := detectFirewall()
-	if err != nil {
-		return nil, err
+// Ban descreve um banimento presente no firewall
+type Ban struct {
+	IP        string   `json:"ip"`
+	Ports     []int    `json:"ports,omitempty"`     // Vazio quando todas as portas são bloqueadas
+	Protocols []string `json:"protocols,omitempty"` // Vazio quando todos os protocolos são bloqueados
+	Action    string   `json:"action"`
+	Family    string   `json:"family"`
+	Rules     []string `json:"rules"` // Identificadores das regras no backend
+
+	allPorts     bool
+	allProtocols bool
+}
+
+// Profile retorna o perfil equivalente às regras do banimento
+func (b Ban) Profile() BanProfile {
+	return BanProfile{Ports: b.Ports, Protocols: b.Protocols, Action: b.Action}
+}
+
+// UnsupportedActionError indica que o backend não implementa a ação de
+// bloqueio pedida no perfil
+type UnsupportedActionError struct {
+	Firewall string
+	Action   string
+}
+
+func (e *UnsupportedActionError) Error() string {
+	return fmt.Sprintf("a ação %s não é suportada pelo %s", e.Action, e.Firewall)
+}
+
+// FindBan procura o banimento do próprio alvo em uma lista de banimentos,
+// comparando as formas canônicas dos endereços
+func FindBan(bans []Ban, ip string) *Ban {
+	target, _ := NormalizeTarget(ip)
+	for i := range bans {
+		if bans[i].IP == ip {
+			return &bans[i]
+		}
+		if other, err := NormalizeTarget(bans[i].IP); err == nil && other == target {
+			return &bans[i]
+		}
 	}
+	return nil
+}
 
-	return createFirewall(firewallType)
+// addBanRule agrega uma regra ao banimento do IP, criando-o se necessário.
+// Uma porta zero indica uma regra que bloqueia todas as portas e um
+// protocolo vazio, uma regra que bloqueia todos os protocolos.
+func addBanRule(bans []Ban, ip, family string, port int, proto, action, rule string) []Ban {
+	ban := FindBan(bans, ip)
+	if ban == nil {
+		bans = append(bans, Ban{IP: ip, Family: family})
+		ban = &bans[len(bans)-1]
+	}
+	if port <= 0 {
+		ban.allPorts = true
+		ban.Ports = nil
+	} else if !ban.allPorts && !containsInt(ban.Ports, port) {
+		ban.Ports = append(ban.Ports, port)
+	}
+	if proto == "" {
+		ban.allProtocols = true
+		ban.Protocols = nil
+	} else if !ban.allProtocols && !containsString(ban.Protocols, proto) {
+		ban.Protocols = append(ban.Protocols, proto)
+	}
+	ban.Action = action
+	if !containsString(ban.Rules, rule) {
+		ban.Rules = append(ban.Rules, rule)
+	}
+	return bans
 }
 
-// detectFirewall detecta o tipo de firewall instalado no sistema
-func detectFirewall() (string, error) {
-	// Verificar UFW
-	if _, err := exec.LookPath("ufw"); err == nil {
-		return "ufw", nil
+// addProfileRule agrega ao banimento do IP uma regra que aplica o perfil
+// inteiro, como um set referenciado por uma regra com as portas do perfil
+func addProfileRule(bans []Ban, ip, family string, profile BanProfile, rule string) []Ban {
+	ports := profile.Ports
+	if len(ports) == 0 {
+		ports = []int{0}
+	}
+	protocols := profile.Protocols
+	if len(protocols) == 0 {
+		protocols = []string{""}
 	}
 
-	// Verificar iptables
-	if _, err := exec.LookPath("iptables"); err == nil {
-		return "iptables", nil
+	for _, port := range ports {
+		for _, proto := range protocols {
+			bans = addBanRule(bans, ip, family, port, proto, profile.Action, rule)
+		}
 	}
+	return bans
+}
 
-	// Verificar firewalld
-	if _, err := exec.LookPath("firewall-cmd"); err == nil {
-		return "firewalld", nil
+// ShadowedError indica que um banimento não teria efeito porque uma regra de
+// liberação é avaliada antes dele
+type ShadowedError struct {
+	IP   string
+	Rule string
+}
+
+func (e *ShadowedError) Error() string {
+	return fmt.Sprintf("banimento de %s seria ignorado: regra avaliada antes: %s", e.IP, e.Rule)
+}
+
+// New cria uma nova instância do firewall apropriado
+func New(cfg *config.Config) (Firewall, error) {
+	return NewWithRunner(cfg, ExecRunner{})
+}
+
+// NewWithRunner cria o firewall apropriado executando os comandos, inclusive
+// os da detecção automática, com o runner informado
+func NewWithRunner(cfg *config.Config, r Runner) (Firewall, error) {
+	fw, _, err := NewWithDetection(cfg, r)
+	return fw, err
+}
+
+// NewWithDetection cria o firewall apropriado e retorna também o diagnóstico
+// da detecção (ver Detect)
+func NewWithDetection(cfg *config.Config, r Runner) (Firewall, *Detection, error) {
+	d, err := Detect(cfg, r)
+	if err != nil {
+		return nil, d, err
 	}
 
-	return "", errors.New("nenhum firewall suportado encontrado")
+	fw, err := createFirewall(d.Selected, r, cfg.DockerBans)
+	return fw, d, err
 }
 
-// createFirewall cria uma instância do firewall baseado no tipo
-func createFirewall(firewallType string) (Firewall, error) {
+// ipFamily retorna "ipv4" ou "ipv6" para um endereço IP ou rede CIDR
+func ipFamily(ip string) (string, error) {
+	prefix, err := ParseTarget(ip)
+	if err != nil {
+		return "", err
+	}
+	if prefix.Addr().Is4() {
+		return "ipv4", nil
+	}
+	return "ipv6", nil
+}
+
+// createFirewall cria uma instância do firewall baseado no tipo, com o modo
+// de aplicação dos banimentos no Docker (GUARDIAN_DOCKER_BANS)
+func createFirewall(firewallType string, r Runner, dockerMode string) (Firewall, error) {
+	docker := dockerBans{runner: r, mode: dockerMode}
 	switch strings.ToLower(firewallType) {
 	case "ufw":
-		return &UFWFirewall{}, nil
+		return &UFWFirewall{runner: r, docker: docker}, nil
 	case "iptables":
-		return &IPTablesFirewall{}, nil
+		return &IPTablesFirewall{runner: r, docker: docker}, nil
 	case "firewalld":
-		return &FirewalldFirewall{}, nil
+		return &FirewalldFirewall{runner: r, docker: docker}, nil
+	case "nftables", "nft":
+		return &NFTablesFirewall{runner: r, docker: docker}, nil
 	default:
 		return nil, fmt.Errorf("tipo de firewall não suportado: %s", firewallType)
 	}
 }
+
+// profileID retorna um identificador curto e estável do perfil, usado para
+// nomear os sets de cada perfil de banimento
+func profileID(profile BanProfile) string {
+	sum := sha1.Sum([]byte(profile.String()))
+	return hex.EncodeToString(sum[:4])
+}
+
+// chunkPorts divide as portas em grupos de no máximo n, respeitando o limite
+// de portas por regra dos backends (15 no multiport do iptables e no UFW)
+func chunkPorts(ports []int, n int) [][]int {
+	var chunks [][]int
+	for len(ports) > n {
+		chunks = append(chunks, ports[:n])
+		ports = ports[n:]
+	}
+	if len(ports) > 0 {
+		chunks = append(chunks, ports)
+	}
+	return chunks
+}
+
+// joinPorts formata as portas separadas por vírgula
+func joinPorts(ports []int) string {
+	list := make([]string, len(ports))
+	for i, port := range ports {
+		list[i] = strconv.Itoa(port)
+	}
+	return strings.Join(list, ",")
+}
+
+// containsString verifica se a lista contém o valor
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// containsInt verifica se a lista contém o valor
+func containsInt(list []int, value int) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package firewall
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/mtm/guardian/internal/config"
@@ -22,6 +23,20 @@ func TestFirewallTypeDetection(t *testing.T) {
 		t.Errorf("Tipo de firewall esperado: ufw, obtido: %s", fw.Type())
 	}
 
+	// Teste com nftables
+	cfg = &config.Config{
+		FirewallType: "nftables",
+	}
+
+	fw, err = New(cfg)
+	if err != nil {
+		t.Fatalf("Erro ao criar firewall: %v", err)
+	}
+
+	if fw.Type() != "nftables" {
+		t.Errorf("Tipo de firewall esperado: nftables, obtido: %s", fw.Type())
+	}
+
 	// Teste com tipo inválido
 	cfg = &config.Config{
 		FirewallType: "invalid",
@@ -33,47 +48,6 @@ func TestFirewallTypeDetection(t *testing.T) {
 	}
 }
 
-// MockFirewall implementa a interface Firewall para testes
-type MockFirewall struct {
-	enabled bool
-	banned  map[string]bool
-}
-
-func NewMockFirewall() *MockFirewall {
-	return &MockFirewall{
-		enabled: false,
-		banned:  make(map[string]bool),
-	}
-}
-
-func (f *MockFirewall) IsEnabled() (bool, error) {
-	return f.enabled, nil
-}
-
-func (f *MockFirewall) Enable() error {
-	f.enabled = true
-	return nil
-}
-
-func (f *MockFirewall) Disable() error {
-	f.enabled = false
-	return nil
-}
-
-func (f *MockFirewall) BanIP(ip string) error {
-	f.banned[ip] = true
-	return nil
-}
-
-func (f *MockFirewall) UnbanIP(ip string) error {
-	delete(f.banned, ip)
-	return nil
-}
-
-func (f *MockFirewall) Type() string {
-	return "mock"
-}
-
 // TestMockFirewall testa a implementação do MockFirewall
 func TestMockFirewall(t *testing.T) {
 	fw := NewMockFirewall()
@@ -99,10 +73,10 @@ func TestMockFirewall(t *testing.T) {
 
 	// Testar banimento de IP
 	ip := "192.168.1.100"
-	if err := fw.BanIP(ip); err != nil {
+	if err := fw.BanIP(ip, config.DefaultBanProfile()); err != nil {
 		t.Fatalf("Erro ao banir IP: %v", err)
 	}
-	if !fw.banned[ip] {
+	if _, ok := fw.banned[ip]; !ok {
 		t.Errorf("IP %s deveria estar banido", ip)
 	}
 
@@ -110,7 +84,7 @@ func TestMockFirewall(t *testing.T) {
 	if err := fw.UnbanIP(ip); err != nil {
 		t.Fatalf("Erro ao desbanir IP: %v", err)
 	}
-	if fw.banned[ip] {
+	if _, ok := fw.banned[ip]; ok {
 		t.Errorf("IP %s não deveria estar banido após UnbanIP()", ip)
 	}
 
@@ -124,3 +98,325 @@ func TestMockFirewall(t *testing.T) {
 		t.Error("Firewall deveria estar desabilitado após Disable()")
 	}
 }
+
+// TestCheckBanOrder testa a verificação de ordem das regras de cada backend
+func TestCheckBanOrder(t *testing.T) {
+	ip := "203.0.113.7"
+
+	// iptables: salto para a GUARDIAN no topo e DROP antes das liberações
+	input := "-P INPUT ACCEPT\n-A INPUT -j GUARDIAN\n-A INPUT -p tcp --dport 22 -j ACCEPT\n"
+	chain := "-N GUARDIAN\n-A GUARDIAN -m set --match-set guardian-v4 src -p tcp -m multiport --dports 22,80,443,4554 -j DROP\n-A GUARDIAN -p tcp --dport 22 -j ACCEPT\n"
+	if err := checkIPTablesOrder(ip, "guardian-v4", input, chain); err != nil {
+		t.Errorf("iptables: ordem correta reportada como erro: %v", err)
+	}
+
+	input = "-P INPUT ACCEPT\n-A INPUT -p tcp --dport 22 -j ACCEPT\n-A INPUT -j GUARDIAN\n"
+	if _, ok := checkIPTablesOrder(ip, "guardian-v4", input, chain).(*ShadowedError); !ok {
+		t.Error("iptables: ACCEPT antes do salto deveria ser reportado")
+	}
+
+	// UFW: o deny precisa vir antes do ALLOW do SSH
+	status := "Status: active\n\n     To                         Action      From\n     --                         ------      ----\n[ 1] Anywhere                   DENY IN     203.0.113.7\n[ 2] 22/tcp                     ALLOW IN    Anywhere\n"
+	if err := checkUFWOrder(ip, "ipv4", status); err != nil {
+		t.Errorf("ufw: ordem correta reportada como erro: %v", err)
+	}
+
+	status = "[ 1] 22/tcp                     ALLOW IN    Anywhere\n[ 2] Anywhere                   DENY IN     203.0.113.7\n"
+	if _, ok := checkUFWOrder(ip, "ipv4", status).(*ShadowedError); !ok {
+		t.Error("ufw: ALLOW antes do deny deveria ser reportado")
+	}
+
+	// firewalld: accept com prioridade negativa é avaliado antes do reject
+	rules := "rule family=\"ipv4\" source address=\"203.0.113.7\" port port=\"22\" protocol=\"tcp\" reject\n"
+	if err := checkFirewalldOrder(ip, "ipv4", rules); err != nil {
+		t.Errorf("firewalld: ordem correta reportada como erro: %v", err)
+	}
+
+	rules += "rule priority=\"-10\" family=\"ipv4\" service name=\"ssh\" accept\n"
+	if _, ok := checkFirewalldOrder(ip, "ipv4", rules).(*ShadowedError); !ok {
+		t.Error("firewalld: accept com prioridade negativa deveria ser reportado")
+	}
+
+	// nftables: DROP do set antes dos accepts da chain
+	listing := "table inet guardian {\n\tchain input {\n\t\ttype filter hook input priority -10; policy drop;\n\t\tip saddr @banned4 drop\n\t\tct state established,related accept\n\t}\n}\n"
+	if err := checkNFTOrder(ip, "banned4", listing); err != nil {
+		t.Errorf("nftables: ordem correta reportada como erro: %v", err)
+	}
+
+	listing = "\t\tct state established,related accept\n\t\tip saddr @banned4 drop\n"
+	if _, ok := checkNFTOrder(ip, "banned4", listing).(*ShadowedError); !ok {
+		t.Error("nftables: accept antes do drop deveria ser reportado")
+	}
+}
+
+// TestRemainingRules testa a busca por regras que ainda referenciam um IP
+func TestRemainingRules(t *testing.T) {
+	ip := "203.0.113.7"
+
+	listing := "-A INPUT -s 203.0.113.7/32 -p tcp -m tcp --dport 22 -j DROP\n-A INPUT -s 203.0.113.70/32 -j DROP\n"
+	if rules := iptablesRulesFor(ip, listing); len(rules) != 1 {
+		t.Errorf("iptables: esperada 1 regra, obtidas %d: %v", len(rules), rules)
+	}
+
+	members := "Name: guardian-ip4\nType: hash:ip\nMembers:\n203.0.113.7\n198.51.100.1\n"
+	if !containsString(ipsetMembers(members), ip) {
+		t.Error("ipset: IP deveria estar entre os membros")
+	}
+
+	status := "Anywhere                   DENY        203.0.113.7\n22/tcp                     ALLOW       Anywhere\n"
+	if rules := ufwRulesFor(ip, status); len(rules) != 1 {
+		t.Errorf("ufw: esperada 1 regra, obtidas %d: %v", len(rules), rules)
+	}
+
+	rich := "rule family=\"ipv4\" source address=\"203.0.113.7\" port port=\"22\" protocol=\"tcp\" reject\n"
+	if rules := firewalldRulesFor(ip, rich); len(rules) != 1 {
+		t.Errorf("firewalld: esperada 1 regra, obtidas %d: %v", len(rules), rules)
+	}
+
+	table := "\tset banned4 {\n\t\ttype ipv4_addr\n\t\telements = { 198.51.100.1, 203.0.113.7 }\n\t}\n"
+	if rules := nftRulesFor(ip, table); len(rules) != 1 {
+		t.Errorf("nftables: esperada 1 regra, obtidas %d: %v", len(rules), rules)
+	}
+}
+
+// TestParseBans testa a leitura dos banimentos nas listagens de cada backend
+func TestParseBans(t *testing.T) {
+	status := "Status: active\n\nTo                         Action      From\n--                         ------      ----\n" +
+		"Anywhere                   DENY        203.0.113.7\n" +
+		"22/tcp                     REJECT      198.51.100.1\n" +
+		"22/tcp                     ALLOW       Anywhere\n" +
+		"Anywhere (v6)              DENY        2001:db8::1\n"
+	bans := parseUFWBans(status)
+	if len(bans) != 3 {
+		t.Fatalf("ufw: esperados 3 banimentos, obtidos %d: %+v", len(bans), bans)
+	}
+	if bans[0].Rules[0] != "deny from 203.0.113.7 to any" || len(bans[0].Ports) != 0 {
+		t.Errorf("ufw: banimento inesperado: %+v", bans[0])
+	}
+	if bans[1].Rules[0] != "reject from 198.51.100.1 to any port 22 proto tcp" || bans[1].Ports[0] != 22 {
+		t.Errorf("ufw: banimento inesperado: %+v", bans[1])
+	}
+	if bans[2].Family != "ipv6" {
+		t.Errorf("ufw: família esperada ipv6, obtida %s", bans[2].Family)
+	}
+
+	rich := "rule family=\"ipv4\" source address=\"203.0.113.7\" port port=\"22\" protocol=\"tcp\" reject\n" +
+		"rule family=\"ipv4\" source address=\"203.0.113.7\" port port=\"80\" protocol=\"tcp\" reject\n" +
+		"rule family=\"ipv4\" service name=\"ssh\" accept\n"
+	bans = parseFirewalldBans(rich)
+	if len(bans) != 1 || len(bans[0].Ports) != 2 || len(bans[0].Rules) != 2 {
+		t.Errorf("firewalld: banimento inesperado: %+v", bans)
+	}
+
+	legacy := "-P INPUT ACCEPT\n-A INPUT -j GUARDIAN\n-A INPUT -s 203.0.113.7/32 -p tcp -m tcp --dport 22 -j DROP\n"
+	bans = parseLegacyRules(nil, "ipv4", legacy)
+	if len(bans) != 1 || bans[0].IP != "203.0.113.7" || bans[0].Ports[0] != 22 {
+		t.Errorf("iptables: banimento inesperado: %+v", bans)
+	}
+
+	set := "table inet guardian {\n\tset banned4 {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t\telements = { 198.51.100.1, 203.0.113.0/24,\n\t\t\t     192.0.2.9 }\n\t}\n}\n"
+	if elems := nftSetElements(set); len(elems) != 3 || elems[1] != "203.0.113.0/24" {
+		t.Errorf("nftables: elementos inesperados: %v", elems)
+	}
+}
+
+// TestCIDRBans testa alvos em notação CIDR e o tratamento de sobreposições
+func TestCIDRBans(t *testing.T) {
+	targets := map[string]string{
+		"203.0.113.7":        "203.0.113.7",
+		"203.0.113.7/32":     "203.0.113.7",
+		"203.0.113.7/24":     "203.0.113.0/24",
+		"2001:db8::1/64":     "2001:db8::/64",
+		"2001:0db8:0::0001":  "2001:db8::1",
+		"::ffff:203.0.113.7": "203.0.113.7",
+	}
+	for in, want := range targets {
+		got, err := NormalizeTarget(in)
+		if err != nil || got != want {
+			t.Errorf("NormalizeTarget(%q) = %q, %v; esperado %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "203.0.113", "203.0.113.0/33", "invalid-ip"} {
+		if _, err := NormalizeTarget(in); err == nil {
+			t.Errorf("NormalizeTarget(%q) deveria retornar erro", in)
+		}
+	}
+	covers := map[[2]string]bool{
+		{"203.0.113.0/24", "203.0.113.7"}:          true,
+		{"203.0.113.0/24", "::ffff:203.0.113.7"}:   true,
+		{"203.0.113.0/24", "203.0.113.0/24"}:       true,
+		{"203.0.113.0/24", "203.0.112.0/23"}:       false,
+		{"203.0.113.7", "203.0.113.0/24"}:          false,
+		{"invalid-ip", "203.0.113.7"}:              false,
+		{"2001:db8::/32", "2001:0db8:0::0001/128"}: true,
+	}
+	for pair, want := range covers {
+		if got := Covers(pair[0], pair[1]); got != want {
+			t.Errorf("Covers(%q, %q) = %v; esperado %v", pair[0], pair[1], got, want)
+		}
+	}
+
+	fw := NewMockFirewall()
+	fw.BanIP("203.0.113.7", config.DefaultBanProfile())
+	fw.BanIP("203.0.113.8", config.DefaultBanProfile())
+	fw.BanIP("198.51.100.1", config.DefaultBanProfile())
+
+	// Banir a rede unifica os IPs contidos nela
+	if err := fw.BanIP("203.0.113.0/24", config.DefaultBanProfile()); err != nil {
+		t.Fatalf("Erro ao banir rede: %v", err)
+	}
+	bans, _ := fw.ListBanned()
+	if len(bans) != 2 {
+		t.Errorf("Banimentos esperados: 2, obtidos: %+v", bans)
+	}
+	if ban := FindBan(bans, "203.0.113.7/24"); ban == nil || ban.IP != "203.0.113.0/24" {
+		t.Errorf("Banimento da rede não encontrado pela forma não canônica: %+v", ban)
+	}
+	if ban := FindBan(bans, "203.0.113.7"); ban != nil {
+		t.Errorf("Endereço contido na rede não tem banimento próprio: %+v", ban)
+	}
+
+	// Um IP contido na rede já está banido e não pode ser banido ou desbanido isoladamente
+	if banned, _ := fw.IsBanned("203.0.113.9"); !banned {
+		t.Error("IP contido na rede banida deveria constar como banido")
+	}
+	if _, ok := fw.BanIP("203.0.113.9", config.DefaultBanProfile()).(*RangeError); !ok {
+		t.Error("Banir IP contido na rede banida deveria retornar RangeError")
+	}
+	if _, ok := fw.UnbanIP("203.0.113.7").(*RangeError); !ok {
+		t.Error("Desbanir IP contido na rede banida deveria retornar RangeError")
+	}
+
+	if err := fw.UnbanIP("203.0.113.0/24"); err != nil {
+		t.Fatalf("Erro ao desbanir rede: %v", err)
+	}
+	if banned, _ := fw.IsBanned("203.0.113.7"); banned {
+		t.Error("IP não deveria estar banido após desbanir a rede")
+	}
+}
+
+// TestUFWIPv6Enabled testa a leitura da opção IPV6 de /etc/default/ufw
+func TestUFWIPv6Enabled(t *testing.T) {
+	tests := map[string]bool{
+		"IPV6=yes\nDEFAULT_INPUT_POLICY=\"DROP\"\n": true,
+		"# IPV6=yes\nIPV6=no\n":                     false,
+		"IPV6=\"yes\"\n":                            true,
+		"DEFAULT_INPUT_POLICY=\"DROP\"\n":           false,
+	}
+	for defaults, want := range tests {
+		if got := ufwIPv6Enabled(defaults); got != want {
+			t.Errorf("ufwIPv6Enabled(%q) = %v, esperado %v", defaults, got, want)
+		}
+	}
+}
+
+// TestBanProfileRules testa a tradução do perfil de banimento nas regras de
+// cada backend
+func TestBanProfileRules(t *testing.T) {
+	ip := "203.0.113.7"
+	profile, err := config.DefaultBanProfile().With("22,80", "tcp,udp", "reject")
+	if err != nil {
+		t.Fatalf("Erro ao criar perfil: %v", err)
+	}
+
+	rules, err := ufwBanRules(ip, profile)
+	if err != nil || len(rules) != 2 || rules[0] != "reject from 203.0.113.7 to any port 22,80 proto tcp" {
+		t.Errorf("ufw: regras inesperadas: %v (%v)", rules, err)
+	}
+
+	rules, err = firewalldBanRules(ip, profile)
+	if err != nil || len(rules) != 4 || rules[3] != `rule family="ipv4" source address="203.0.113.7" port port="80" protocol="udp" reject` {
+		t.Errorf("firewalld: regras inesperadas: %v (%v)", rules, err)
+	}
+
+	g := ipsetGroupFor(ipsetV4, profile)
+	if g.legacy() || !strings.HasPrefix(g.list, "guardian-") || ipsetListOf(g.nets) != g.list {
+		t.Errorf("iptables: grupo inesperado: %+v", g)
+	}
+	specs := banRules(g)
+	if len(specs) != 2 || strings.Join(specs[1], " ") != "GUARDIAN -m set --match-set "+g.list+" src -p udp -m multiport --dports 22,80 -j REJECT" {
+		t.Errorf("iptables: regras inesperadas: %v", specs)
+	}
+	if legacy := ipsetGroupFor(ipsetV4, config.DefaultBanProfile()); legacy.list != "guardian-v4" || legacy.hosts != "guardian-ip4" {
+		t.Errorf("iptables: o perfil padrão deveria usar os sets legados: %+v", legacy)
+	}
+	// Portas e protocolos fora de ordem ou repetidos identificam o mesmo perfil
+	unordered := BanProfile{Ports: []int{80, 22, 80}, Protocols: []string{"udp", "tcp"}, Action: config.ActionReject}
+	if profileID(unordered) != profileID(profile) || ipsetGroupFor(ipsetV4, unordered).list != g.list {
+		t.Errorf("iptables: grupo inesperado para %s: %+v", unordered, ipsetGroupFor(ipsetV4, unordered))
+	}
+
+	if rule := nftProfileRule("ip", "b4_x", profile); rule != "ip saddr @b4_x meta l4proto { tcp, udp } th dport { 22, 80 } reject" {
+		t.Errorf("nftables: regra inesperada: %s", rule)
+	}
+
+	// Todo o tráfego, sem portas nem protocolos
+	all, _ := config.DefaultBanProfile().With("all", "all", "drop")
+	if rules, _ := ufwBanRules(ip, all); len(rules) != 1 || rules[0] != "deny from 203.0.113.7 to any" {
+		t.Errorf("ufw: regras inesperadas: %v", rules)
+	}
+	if specs := banRules(ipsetGroupFor(ipsetV6, all)); len(specs) != 1 || specs[0][len(specs[0])-1] != "DROP" {
+		t.Errorf("iptables: regras inesperadas: %v", specs)
+	}
+
+	// O TARPIT só existe no iptables
+	tarpit, _ := config.DefaultBanProfile().With("", "", "tarpit")
+	if _, err := ufwBanRules(ip, tarpit); err == nil {
+		t.Error("ufw: tarpit deveria retornar erro")
+	}
+	if _, err := firewalldBanRules(ip, tarpit); err == nil {
+		t.Error("firewalld: tarpit deveria retornar erro")
+	}
+	if _, ok := (&NFTablesFirewall{}).BanIP(ip, tarpit).(*UnsupportedActionError); !ok {
+		t.Error("nftables: tarpit deveria retornar UnsupportedActionError")
+	}
+}
+
+// TestParseProfiles testa a leitura do perfil dos banimentos nas listagens
+func TestParseProfiles(t *testing.T) {
+	members := "Name: guardian-0a1b2c3d-ip4\nType: hash:ip\nMembers:\n203.0.113.7 comment \"reject udp 53\"\n"
+	entries := ipsetEntries(members)
+	if len(entries) != 1 || entries[0].comment != "reject udp 53" {
+		t.Fatalf("ipset: elementos inesperados: %+v", entries)
+	}
+	profile, ok := ipsetEntryProfile(ipsetV4, "guardian-0a1b2c3d-ip4", entries[0])
+	if !ok || profile.Action != "reject" || profile.Ports[0] != 53 {
+		t.Errorf("ipset: perfil inesperado: %+v", profile)
+	}
+
+	table := "table inet guardian {\n" +
+		"\tset b4_0a1b2c3d {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t\tcomment \"drop tcp 22\"\n\t\telements = { 198.51.100.1 }\n\t}\n" +
+		"\tset banned6 {\n\t\ttype ipv6_addr\n\t\tflags interval\n\t\telements = { 2001:db8::1 }\n\t}\n" +
+		"\tchain bans {\n\t\tip saddr @b4_0a1b2c3d meta l4proto { tcp } th dport { 22 } drop\n\t}\n}\n"
+	sets := nftSets(table)
+	if len(sets) != 2 || sets[0].comment != "drop tcp 22" || sets[1].elements[0] != "2001:db8::1" {
+		t.Fatalf("nftables: sets inesperados: %+v", sets)
+	}
+	if profile, ok := nftSetProfile(sets[1]); !ok || !profile.AllPorts() || len(profile.Protocols) != 0 {
+		t.Errorf("nftables: perfil inesperado: %+v", profile)
+	}
+
+	status := "Anywhere/udp               DENY        203.0.113.7\n"
+	bans := parseUFWBans(status)
+	if len(bans) != 1 || bans[0].Protocols[0] != "udp" || len(bans[0].Ports) != 0 || bans[0].Rules[0] != "deny from 203.0.113.7 to any proto udp" {
+		t.Errorf("ufw: banimento inesperado: %+v", bans)
+	}
+}
+
+// TestProfileReplace testa a substituição do banimento de um IP já banido
+// com outro perfil
+func TestProfileReplace(t *testing.T) {
+	fw := NewMockFirewall()
+	ip := "203.0.113.7"
+
+	fw.BanIP(ip, config.DefaultBanProfile())
+	udp, _ := config.DefaultBanProfile().With("53", "udp", "reject")
+	if err := fw.BanIP(ip, udp); err != nil {
+		t.Fatalf("Erro ao substituir banimento: %v", err)
+	}
+
+	bans, _ := fw.ListBanned()
+	if len(bans) != 1 || !bans[0].Profile().Equal(udp) {
+		t.Errorf("Banimento esperado com o perfil %s, obtido: %+v", udp, bans)
+	}
+}
@@ -2,17 +2,22 @@ package firewall
 
 import (
 	"fmt"
-	"os/exec"
+	"strconv"
 	"strings"
+
+	"github.com/mtm/guardian/internal/config"
 )
 
 // FirewalldFirewall implementa a interface Firewall para o firewalld
-type FirewalldFirewall struct{}
+type FirewalldFirewall struct {
+	runner Runner
+	bans   banTracker
+	docker dockerBans
+}
 
 // IsEnabled verifica se o firewalld está habilitado
 func (f *FirewalldFirewall) IsEnabled() (bool, error) {
-	cmd := exec.Command("firewall-cmd", "--state")
-	output, err := cmd.CombinedOutput()
+	output, err := f.runner.CombinedOutput("firewall-cmd", "--state")
 	if err != nil {
 		return false, fmt.Errorf("erro ao verificar status do firewalld: %w", err)
 	}
@@ -29,17 +34,17 @@ func (f *FirewalldFirewall) Enable() error {
 		// Iniciar e habilitar o serviço
 		{"systemctl", []string{"start", "firewalld"}},
 		{"systemctl", []string{"enable", "firewalld"}},
-		
+
 		// Configurar regras básicas
 		{"firewall-cmd", []string{"--permanent", "--add-service=ssh"}},
 		{"firewall-cmd", []string{"--permanent", "--add-port=4554/tcp"}}, // Porta da API Guardian
-		
+
 		// Recarregar para aplicar as mudanças
 		{"firewall-cmd", []string{"--reload"}},
 	}
 
 	for _, cmd := range cmds {
-		if err := exec.Command(cmd.name, cmd.args...).Run(); err != nil {
+		if _, err := f.runner.CombinedOutput(cmd.name, cmd.args...); err != nil {
 			return fmt.Errorf("erro ao executar '%s %s': %w", cmd.name, strings.Join(cmd.args, " "), err)
 		}
 	}
@@ -47,7 +52,7 @@ func (f *FirewalldFirewall) Enable() error {
 	return nil
 }
 
-// Disable desativa o firewalld
+// Disable desativa o firewalld e remove as regras do Guardian da DOCKER-USER
 func (f *FirewalldFirewall) Disable() error {
 	cmds := []struct {
 		name string
@@ -58,53 +63,391 @@ func (f *FirewalldFirewall) Disable() error {
 	}
 
 	for _, cmd := range cmds {
-		if err := exec.Command(cmd.name, cmd.args...).Run(); err != nil {
+		if _, err := f.runner.CombinedOutput(cmd.name, cmd.args...); err != nil {
 			return fmt.Errorf("erro ao executar '%s %s': %w", cmd.name, strings.Join(cmd.args, " "), err)
 		}
 	}
 
-	return nil
+	return f.docker.removeAll()
 }
 
-// BanIP bane um endereço IP usando o firewalld
-func (f *FirewalldFirewall) BanIP(ip string) error {
-	ports := []string{"22", "80", "443", "4554"}
-	for _, port := range ports {
-		rule := fmt.Sprintf("rule family=\"ipv4\" source address=\"%s\" port port=\"%s\" protocol=\"tcp\" reject", ip, port)
-		cmd := exec.Command("firewall-cmd", "--permanent", "--add-rich-rule="+rule)
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("erro ao banir IP %s na porta %s: %w", ip, port, err)
+// Snapshot registra se o firewalld está em execução e habilitado no boot
+func (f *FirewalldFirewall) Snapshot() (*Snapshot, error) {
+	s := newSnapshot(f)
+	output, _ := f.runner.Output("systemctl", "is-enabled", "firewalld")
+	s.Data["is-enabled"] = []byte(strings.TrimSpace(string(output)))
+	return s, nil
+}
+
+// Restore para o firewalld se ele não estava em execução na cópia. As
+// liberações permanentes do SSH e da API feitas pelo Enable são mantidas,
+// já que apenas liberam tráfego.
+func (f *FirewalldFirewall) Restore(s *Snapshot) error {
+	if err := checkSnapshot(f, s); err != nil {
+		return err
+	}
+	if s.Enabled {
+		return nil
+	}
+
+	if err := runCmd(f.runner, "systemctl", "stop", "firewalld"); err != nil {
+		return fmt.Errorf("erro ao parar o firewalld: %w", err)
+	}
+	if string(s.Data["is-enabled"]) != "enabled" {
+		if err := runCmd(f.runner, "systemctl", "disable", "firewalld"); err != nil {
+			return fmt.Errorf("erro ao desabilitar o firewalld: %w", err)
 		}
-		// IPv6
-		rule6 := fmt.Sprintf("rule family=\"ipv6\" source address=\"%s\" port port=\"%s\" protocol=\"tcp\" reject", ip, port)
-		cmd6 := exec.Command("firewall-cmd", "--permanent", "--add-rich-rule="+rule6)
-		_ = cmd6.Run() // Ignorar erro para IPv6 se IP for só IPv4
 	}
-	cmdReload := exec.Command("firewall-cmd", "--reload")
-	_ = cmdReload.Run()
 	return nil
 }
 
-// UnbanIP remove o banimento de um endereço IP usando o firewalld
+// BanIP bane um endereço IP usando o firewalld, com rich rules na família
+// do endereço que aplicam o perfil de banimento. O tráfego encaminhado aos
+// containers do Docker não passa pelas zonas; para ele, as regras vão para a
+// DOCKER-USER.
+func (f *FirewalldFirewall) BanIP(ip string, profile BanProfile) error {
+	ip, err := prepareBan(f, ip, profile)
+	if err != nil {
+		return err
+	}
+
+	rules, err := firewalldBanRules(ip, profile)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if err := runCmd(f.runner, "firewall-cmd", "--permanent", "--add-rich-rule="+rule); err != nil {
+			return fmt.Errorf("erro ao banir IP %s: %w", ip, err)
+		}
+		f.bans.record(ip, []string{rule})
+	}
+
+	if err := runCmd(f.runner, "firewall-cmd", "--reload"); err != nil {
+		return fmt.Errorf("erro ao recarregar o firewalld: %w", err)
+	}
+	if err := f.docker.banIP(ip, profile); err != nil {
+		return fmt.Errorf("erro ao banir IP %s no Docker: %w", ip, err)
+	}
+
+	return f.CheckBanOrder(ip)
+}
+
+// UnbanIP remove as rich rules criadas pelo BanIP e confirma que nenhuma
+// regra referente ao IP permaneceu, na configuração permanente e em execução
 func (f *FirewalldFirewall) UnbanIP(ip string) error {
-	cmds := []struct {
-		name string
-		args []string
-	}{
-		{"firewall-cmd", []string{"--permanent", "--remove-rich-rule=rule family=\"ipv4\" source address=\"" + ip + "\" reject"}},
-		{"firewall-cmd", []string{"--reload"}},
+	ip, err := prepareUnban(f, ip)
+	if err != nil {
+		return err
 	}
 
-	for _, cmd := range cmds {
-		if err := exec.Command(cmd.name, cmd.args...).Run(); err != nil {
-			return fmt.Errorf("erro ao executar '%s %s': %w", cmd.name, strings.Join(cmd.args, " "), err)
+	// Sem registro desta execução, as regras são obtidas da listagem
+	var fallback []string
+	bans, err := f.ListBanned()
+	if err != nil {
+		return err
+	}
+	if ban := FindBan(bans, ip); ban != nil {
+		fallback = ban.Rules
+	}
+
+	for _, rule := range f.bans.lookup(ip, fallback) {
+		if err := runCmd(f.runner, "firewall-cmd", "--permanent", "--remove-rich-rule="+rule); err != nil {
+			return fmt.Errorf("erro ao desbanir IP %s: %w", ip, err)
 		}
 	}
+	f.bans.forget(ip)
+	if err := f.docker.unbanIP(ip); err != nil {
+		return fmt.Errorf("erro ao desbanir IP %s no Docker: %w", ip, err)
+	}
+
+	if err := runCmd(f.runner, "firewall-cmd", "--reload"); err != nil {
+		return fmt.Errorf("erro ao recarregar o firewalld: %w", err)
+	}
+
+	var remaining []string
+	for _, args := range [][]string{{"--permanent", "--list-rich-rules"}, {"--list-rich-rules"}} {
+		output, err := f.runner.CombinedOutput("firewall-cmd", args...)
+		if err != nil {
+			return fmt.Errorf("erro ao listar rich rules do firewalld: %w", err)
+		}
+		for _, rule := range firewalldRulesFor(ip, string(output)) {
+			if !containsString(remaining, rule) {
+				remaining = append(remaining, rule)
+			}
+		}
+	}
+	if len(remaining) > 0 {
+		return &RemainingRulesError{IP: ip, Rules: remaining}
+	}
 
 	return nil
 }
 
+// Batch aplica o lote na configuração permanente, com um firewall-cmd por
+// item para todas as suas rich rules, e recarrega o firewalld uma única vez
+func (f *FirewalldFirewall) Batch(ops []BatchOp) []error {
+	bans, err := f.ListBanned()
+	if err != nil {
+		return batchFailed(ops, err)
+	}
+	plan := planBatch(bans, ops, func(ip string, profile BanProfile) error {
+		_, err := firewalldBanRules(ip, profile)
+		return err
+	})
+	if len(plan.add) == 0 && len(plan.remove) == 0 {
+		return plan.errs
+	}
+
+	for _, c := range plan.remove {
+		args := []string{"--permanent"}
+		for _, rule := range f.bans.lookup(c.ip, c.rules) {
+			args = append(args, "--remove-rich-rule="+rule)
+		}
+		if len(args) > 1 {
+			if err := runCmd(f.runner, "firewall-cmd", args...); err != nil {
+				plan.fail(c.op, fmt.Errorf("erro ao desbanir IP %s: %w", c.ip, err))
+				continue
+			}
+		}
+		f.bans.forget(c.ip)
+		if err := f.docker.unbanIP(c.ip); err != nil {
+			plan.fail(c.op, fmt.Errorf("erro ao desbanir IP %s no Docker: %w", c.ip, err))
+		}
+	}
+
+	var added []batchChange
+	for _, c := range plan.add {
+		rules, _ := firewalldBanRules(c.ip, c.profile)
+		args := []string{"--permanent"}
+		for _, rule := range rules {
+			args = append(args, "--add-rich-rule="+rule)
+		}
+		if err := runCmd(f.runner, "firewall-cmd", args...); err != nil {
+			plan.fail(c.op, fmt.Errorf("erro ao banir IP %s: %w", c.ip, err))
+			continue
+		}
+		f.bans.record(c.ip, rules)
+		added = append(added, c)
+	}
+
+	if err := runCmd(f.runner, "firewall-cmd", "--reload"); err != nil {
+		plan.failAll(fmt.Errorf("erro ao recarregar o firewalld: %w", err))
+		return plan.errs
+	}
+
+	for _, c := range added {
+		if err := f.docker.banIP(c.ip, c.profile); err != nil {
+			plan.fail(c.op, fmt.Errorf("erro ao banir IP %s no Docker: %w", c.ip, err))
+			continue
+		}
+		if err := f.CheckBanOrder(c.ip); err != nil {
+			plan.fail(c.op, err)
+		}
+	}
+	if len(plan.remove) > 0 {
+		if bans, err := f.ListBanned(); err == nil {
+			plan.verifyRemoved(bans)
+		}
+	}
+
+	return plan.errs
+}
+
+// ListBanned lista as rich rules de reject/drop por origem da configuração
+// permanente. Os identificadores das regras são o texto das rich rules.
+func (f *FirewalldFirewall) ListBanned() ([]Ban, error) {
+	output, err := f.runner.CombinedOutput("firewall-cmd", "--permanent", "--list-rich-rules")
+	if err != nil {
+		return nil, fmt.Errorf("erro ao listar rich rules do firewalld: %w", err)
+	}
+	return parseFirewalldBans(string(output)), nil
+}
+
+// IsBanned verifica se o IP está banido no firewalld
+func (f *FirewalldFirewall) IsBanned(ip string) (bool, error) {
+	bans, err := f.ListBanned()
+	if err != nil {
+		return false, err
+	}
+	return BannedIn(bans, ip)
+}
+
+// Counters soma os contadores das rich rules de bloqueio, que o firewalld com
+// o backend iptables cria nas chains IN_<zona>_deny. Com o backend nftables
+// (padrão a partir do firewalld 0.6), as regras não têm contadores.
+func (f *FirewalldFirewall) Counters() ([]Counters, error) {
+	var counters []Counters
+	found := false
+	for _, cmd := range []string{"iptables", "ip6tables"} {
+		output, err := f.runner.CombinedOutput(cmd, "-S", "-v")
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "-N" && firewalldDenyChain(fields[1]) {
+				found = true
+			}
+		}
+		counters = parseRuleCounters(counters, string(output), firewalldDenyChain)
+	}
+	if !found {
+		return nil, &UnsupportedCountersError{Firewall: "firewalld", Reason: "as chains IN_<zona>_deny do backend iptables não existem (o backend nftables não mantém contadores por regra)"}
+	}
+	return sortCounters(counters), nil
+}
+
+// CheckBanOrder confere que o banimento do IP será avaliado. O firewalld
+// processa as rich rules de reject/drop antes das liberações de serviços e
+// portas da zona, mas o banimento é contornado quando o IP está vinculado
+// como source de outra zona ou quando há uma rich rule de accept com
+// prioridade negativa.
+func (f *FirewalldFirewall) CheckBanOrder(ip string) error {
+	family, err := ipFamily(ip)
+	if err != nil {
+		return err
+	}
+
+	if output, err := f.runner.CombinedOutput("firewall-cmd", "--get-zone-of-source="+ip); err == nil {
+		return &ShadowedError{IP: ip, Rule: "source vinculado à zona " + strings.TrimSpace(string(output))}
+	}
+
+	output, err := f.runner.CombinedOutput("firewall-cmd", "--list-rich-rules")
+	if err != nil {
+		return fmt.Errorf("erro ao listar rich rules do firewalld: %w", err)
+	}
+
+	return checkFirewalldOrder(ip, family, string(output))
+}
+
 // Type retorna o tipo do firewall
 func (f *FirewalldFirewall) Type() string {
 	return "firewalld"
 }
+
+// syncDocker reaplica os banimentos do firewalld na DOCKER-USER
+func (f *FirewalldFirewall) syncDocker() error {
+	return f.docker.syncBans(f)
+}
+
+// firewalldDenyChain verifica se a chain é uma das chains de bloqueio das
+// zonas do firewalld no backend iptables
+func firewalldDenyChain(chain string) bool {
+	return strings.HasPrefix(chain, "IN_") && strings.HasSuffix(chain, "_deny")
+}
+
+// firewalldBanRules retorna as rich rules de bloqueio do IP: uma por porta
+// e protocolo, uma por protocolo quando todas as portas são bloqueadas ou uma
+// única regra para todo o tráfego. O firewalld não tem um equivalente ao
+// TARPIT.
+func firewalldBanRules(ip string, profile BanProfile) ([]string, error) {
+	if profile.Action != config.ActionDrop && profile.Action != config.ActionReject {
+		return nil, &UnsupportedActionError{Firewall: "firewalld", Action: profile.Action}
+	}
+
+	family, err := ipFamily(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	base := fmt.Sprintf("rule family=\"%s\" source address=\"%s\"", family, ip)
+	if len(profile.Protocols) == 0 {
+		return []string{base + " " + profile.Action}, nil
+	}
+
+	var rules []string
+	for _, proto := range profile.Protocols {
+		if profile.AllPorts() {
+			rules = append(rules, fmt.Sprintf("%s protocol value=\"%s\" %s", base, proto, profile.Action))
+			continue
+		}
+		for _, port := range profile.Ports {
+			rules = append(rules, fmt.Sprintf("%s port port=\"%d\" protocol=\"%s\" %s", base, port, proto, profile.Action))
+		}
+	}
+	return rules, nil
+}
+
+// parseFirewalldBans interpreta a saída do --list-rich-rules
+func parseFirewalldBans(rules string) []Ban {
+	var bans []Ban
+	for _, rule := range strings.Split(rules, "\n") {
+		rule = strings.TrimSpace(rule)
+		if !strings.HasSuffix(rule, " drop") && !strings.Contains(rule, " reject") {
+			continue
+		}
+
+		source := richRuleValue(rule, "source address")
+		if source == "" || strings.Contains(rule, "source NOT") {
+			continue
+		}
+		family := richRuleValue(rule, "family")
+		if family == "" {
+			family, _ = ipFamily(source)
+		}
+
+		action := config.ActionDrop
+		if !strings.HasSuffix(rule, " drop") {
+			action = config.ActionReject
+		}
+		port, _ := strconv.Atoi(richRuleValue(rule, "port port"))
+		proto := richRuleValue(rule, "protocol")
+		if proto == "" {
+			proto = richRuleValue(rule, "protocol value")
+		}
+		bans = addBanRule(bans, source, family, port, proto, action, rule)
+	}
+	return bans
+}
+
+// richRuleValue retorna o valor de um atributo chave="valor" de uma rich rule
+func richRuleValue(rule, key string) string {
+	i := strings.Index(rule, key+`="`)
+	if i < 0 {
+		return ""
+	}
+	rest := rule[i+len(key)+2:]
+	if j := strings.Index(rest, `"`); j >= 0 {
+		return rest[:j]
+	}
+	return ""
+}
+
+// firewalldRulesFor retorna as rich rules que referenciam o IP
+func firewalldRulesFor(ip, rules string) []string {
+	var found []string
+	for _, rule := range strings.Split(rules, "\n") {
+		rule = strings.TrimSpace(rule)
+		if strings.Contains(rule, `address="`+ip+`"`) {
+			found = append(found, rule)
+		}
+	}
+	return found
+}
+
+// checkFirewalldOrder procura na lista de rich rules o bloqueio do IP e
+// qualquer accept com prioridade negativa que seria avaliado antes dele
+func checkFirewalldOrder(ip, family, rules string) error {
+	banned := false
+	for _, rule := range strings.Split(rules, "\n") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" || !strings.Contains(rule, `family="`+family+`"`) {
+			continue
+		}
+
+		source := strings.Contains(rule, `source address="`+ip+`"`)
+		if source && (strings.HasSuffix(rule, "reject") || strings.HasSuffix(rule, "drop")) {
+			banned = true
+			continue
+		}
+		if strings.HasSuffix(rule, "accept") && strings.Contains(rule, `priority="-`) &&
+			(source || !strings.Contains(rule, "source address=")) {
+			return &ShadowedError{IP: ip, Rule: rule}
+		}
+	}
+
+	if !banned {
+		return fmt.Errorf("rich rule de bloqueio para %s não encontrada no firewalld", ip)
+	}
+	return nil
+}
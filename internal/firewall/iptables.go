@@ -2,128 +2,947 @@ package firewall
 
 import (
 	"fmt"
-	"os/exec"
+	"strconv"
 	"strings"
+
+	"github.com/mtm/guardian/internal/config"
+)
+
+const (
+	// ipsetFile é onde os sets do Guardian são salvos, ao lado de rules.v4 e
+	// rules.v6 (mesmo caminho usado pelo plugin ipset do netfilter-persistent)
+	ipsetFile = "/etc/iptables/ipsets"
+	// ipsetPrefix é o prefixo dos nomes de todos os sets do Guardian
+	ipsetPrefix = "guardian-"
+)
+
+// ipsetFamily descreve uma família de endereços no iptables e no ipset
+type ipsetFamily struct {
+	iptables string // iptables ou ip6tables
+	family   string // inet ou inet6
+	suffix   string // 4 ou 6, no fim dos nomes dos sets
+	name     string // ipv4 ou ipv6
+}
+
+var (
+	ipsetV4 = ipsetFamily{"iptables", "inet", "4", "ipv4"}
+	ipsetV6 = ipsetFamily{"ip6tables", "inet6", "6", "ipv6"}
 )
 
-// IPTablesFirewall implementa a interface Firewall para o iptables
-type IPTablesFirewall struct{}
+// iptablesLegacyProfile é o perfil dos sets guardian-v4 e guardian-v6,
+// criados antes dos perfis de banimento
+var iptablesLegacyProfile = config.DefaultBanProfile()
 
-// IsEnabled verifica se o iptables está habilitado e configurado
+// ipsetGroup descreve os sets de um perfil de banimento em uma família. O set
+// "list" é um list:set que agrupa o hash:ip e o hash:net, de forma que uma
+// única regra por protocolo cobre IPs e redes. Fora do perfil legado, cada
+// elemento guarda o perfil no comentário, o que permite recriar as regras
+// a partir dos sets restaurados.
+type ipsetGroup struct {
+	fam     ipsetFamily
+	list    string
+	hosts   string
+	nets    string
+	profile BanProfile
+}
+
+// ipsetGroupFor retorna os sets do perfil na família
+func ipsetGroupFor(fam ipsetFamily, profile BanProfile) ipsetGroup {
+	prefix := ipsetPrefix
+	if !profile.Equal(iptablesLegacyProfile) {
+		prefix += profileID(profile) + "-"
+	}
+	return ipsetGroup{
+		fam:     fam,
+		list:    prefix + "v" + fam.suffix,
+		hosts:   prefix + "ip" + fam.suffix,
+		nets:    prefix + "net" + fam.suffix,
+		profile: profile,
+	}
+}
+
+// legacy indica se o grupo usa os sets criados antes dos perfis, sem comentários
+func (g ipsetGroup) legacy() bool {
+	return g.list == ipsetPrefix+"v"+g.fam.suffix
+}
+
+// guardianChain é a chain própria do Guardian, referenciada na posição 1
+// da INPUT. O Guardian só adiciona e remove regras dentro dela, preservando
+// as regras do Docker, Kubernetes ou do administrador.
+const guardianChain = "GUARDIAN"
+
+// guardianBaseRules são as regras de liberação criadas na chain GUARDIAN
+var guardianBaseRules = [][]string{
+	// Permitir conexões estabelecidas
+	{"-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "ACCEPT"},
+	// Permitir loopback
+	{"-i", "lo", "-j", "ACCEPT"},
+	// Permitir SSH
+	{"-p", "tcp", "--dport", "22", "-j", "ACCEPT"},
+	// Permitir porta da API Guardian
+	{"-p", "tcp", "--dport", "4554", "-j", "ACCEPT"},
+}
+
+// IPTablesFirewall implementa a interface Firewall para o iptables.
+// Os banimentos são mantidos em ipsets, um grupo por família e perfil de
+// banimento, referenciados por uma regra por protocolo, evitando uma chain
+// linear com milhares de regras.
+type IPTablesFirewall struct {
+	runner Runner
+	bans   banTracker
+	docker dockerBans
+}
+
+// IsEnabled verifica se a chain do Guardian está referenciada na INPUT
 func (f *IPTablesFirewall) IsEnabled() (bool, error) {
-	cmd := exec.Command("iptables", "-L")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
+	if err := f.runner.LookPath("iptables"); err != nil {
 		return false, fmt.Errorf("erro ao verificar status do iptables: %w", err)
 	}
 
-	// Verificar se há regras configuradas
-	lines := strings.Split(string(output), "\n")
-	if len(lines) <= 3 {
-		return false, nil // Sem regras configuradas
+	if !succeeds(f.runner, "iptables", "-C", "INPUT", "-j", guardianChain) {
+		return false, nil // Chain do Guardian não configurada
 	}
 
 	return true, nil
 }
 
-// Enable configura regras básicas no iptables
+// Enable cria (ou recria) a chain GUARDIAN com as regras básicas e as regras
+// de cada grupo de sets de banimento, sem alterar as demais regras nem as
+// políticas do host
 func (f *IPTablesFirewall) Enable() error {
-	cmds := []struct {
-		name string
-		args []string
-	}{
-		// Limpar regras existentes
-		{"iptables", []string{"-F"}},
-		{"iptables", []string{"-X"}},
-		
-		// Configurar política padrão
-		{"iptables", []string{"-P", "INPUT", "DROP"}},
-		{"iptables", []string{"-P", "FORWARD", "DROP"}},
-		{"iptables", []string{"-P", "OUTPUT", "ACCEPT"}},
-		
-		// Permitir conexões estabelecidas
-		{"iptables", []string{"-A", "INPUT", "-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "ACCEPT"}},
-		
-		// Permitir loopback
-		{"iptables", []string{"-A", "INPUT", "-i", "lo", "-j", "ACCEPT"}},
-		
-		// Permitir SSH
-		{"iptables", []string{"-A", "INPUT", "-p", "tcp", "--dport", "22", "-j", "ACCEPT"}},
-		
-		// Permitir porta da API Guardian
-		{"iptables", []string{"-A", "INPUT", "-p", "tcp", "--dport", "4554", "-j", "ACCEPT"}},
-		
-		// Salvar configuração
-		{"sh", []string{"-c", "iptables-save > /etc/iptables/rules.v4 || mkdir -p /etc/iptables && iptables-save > /etc/iptables/rules.v4"}},
-	}
-
-	for _, cmd := range cmds {
-		if err := exec.Command(cmd.name, cmd.args...).Run(); err != nil {
-			return fmt.Errorf("erro ao executar '%s %s': %w", cmd.name, strings.Join(cmd.args, " "), err)
+	// Recarregar os banimentos salvos antes de criar as regras que usam os sets
+	if err := f.restoreSets(); err != nil {
+		return err
+	}
+
+	for _, fam := range []ipsetFamily{ipsetV4, ipsetV6} {
+		if err := f.ensureSets(ipsetGroupFor(fam, iptablesLegacyProfile)); err != nil {
+			return err
+		}
+		groups, err := f.groups(fam)
+		if err != nil {
+			return err
+		}
+
+		if err := f.ensureChain(fam); err != nil {
+			return err
+		}
+		if err := runCmd(f.runner, fam.iptables, "-F", guardianChain); err != nil {
+			return fmt.Errorf("erro ao limpar a chain %s: %w", guardianChain, err)
+		}
+		// As regras dos banidos vêm antes de qualquer liberação
+		for _, g := range groups {
+			if err := f.ensureBanRules(g); err != nil {
+				return err
+			}
+		}
+		for _, rule := range guardianBaseRules {
+			if err := runCmd(f.runner, fam.iptables, append([]string{"-A", guardianChain}, rule...)...); err != nil {
+				return fmt.Errorf("erro ao configurar a chain %s: %w", guardianChain, err)
+			}
 		}
 	}
 
-	return nil
+	return f.save()
 }
 
-// Disable desativa as regras do iptables
+// Disable remove a chain GUARDIAN, o salto a partir da INPUT e as regras do
+// Guardian na DOCKER-USER. Os ipsets são mantidos para que os banimentos
+// voltem a valer num novo Enable.
 func (f *IPTablesFirewall) Disable() error {
-	cmds := []struct {
-		name string
-		args []string
-	}{
-		{"iptables", []string{"-F"}},
-		{"iptables", []string{"-X"}},
-		{"iptables", []string{"-P", "INPUT", "ACCEPT"}},
-		{"iptables", []string{"-P", "FORWARD", "ACCEPT"}},
-		{"iptables", []string{"-P", "OUTPUT", "ACCEPT"}},
-	}
+	for _, fam := range []ipsetFamily{ipsetV4, ipsetV6} {
+		for succeeds(f.runner, fam.iptables, "-C", "INPUT", "-j", guardianChain) {
+			if err := runCmd(f.runner, fam.iptables, "-D", "INPUT", "-j", guardianChain); err != nil {
+				return fmt.Errorf("erro ao remover a chain %s da INPUT: %w", guardianChain, err)
+			}
+		}
 
-	for _, cmd := range cmds {
-		if err := exec.Command(cmd.name, cmd.args...).Run(); err != nil {
-			return fmt.Errorf("erro ao executar '%s %s': %w", cmd.name, strings.Join(cmd.args, " "), err)
+		if !succeeds(f.runner, fam.iptables, "-n", "-L", guardianChain) {
+			continue // Chain não existe
+		}
+		if err := runCmd(f.runner, fam.iptables, "-F", guardianChain); err != nil {
+			return fmt.Errorf("erro ao limpar a chain %s: %w", guardianChain, err)
 		}
+		if err := runCmd(f.runner, fam.iptables, "-X", guardianChain); err != nil {
+			return fmt.Errorf("erro ao remover a chain %s: %w", guardianChain, err)
+		}
+	}
+	if err := f.docker.removeAll(); err != nil {
+		return err
 	}
 
-	return nil
+	return f.save()
 }
 
-// BanIP bane um endereço IP usando o iptables
-func (f *IPTablesFirewall) BanIP(ip string) error {
-	ports := []string{"22", "80", "443", "4554"}
-	for _, port := range ports {
-		cmd := exec.Command("iptables", "-A", "INPUT", "-s", ip, "-p", "tcp", "--dport", port, "-j", "DROP")
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("erro ao banir IP %s na porta %s: %w", ip, port, err)
+// Snapshot salva as tabelas do iptables e do ip6tables com iptables-save
+func (f *IPTablesFirewall) Snapshot() (*Snapshot, error) {
+	s := newSnapshot(f)
+	for _, save := range []string{"iptables-save", "ip6tables-save"} {
+		if err := f.runner.LookPath(save); err != nil {
+			continue // Sem suporte a IPv6
+		}
+		output, err := f.runner.Output(save)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao salvar as regras com %s: %w", save, err)
 		}
-		cmd6 := exec.Command("ip6tables", "-A", "INPUT", "-s", ip, "-p", "tcp", "--dport", port, "-j", "DROP")
-		_ = cmd6.Run() // Ignorar erro para IPv6 se IP for só IPv4
+		s.Data[save] = output
 	}
-	// Salvar configuração
-	saveCmd := exec.Command("sh", "-c", "iptables-save > /etc/iptables/rules.v4 || mkdir -p /etc/iptables && iptables-save > /etc/iptables/rules.v4")
-	_ = saveCmd.Run()
-	saveCmd6 := exec.Command("sh", "-c", "ip6tables-save > /etc/iptables/rules.v6 || mkdir -p /etc/iptables && ip6tables-save > /etc/iptables/rules.v6")
-	_ = saveCmd6.Run()
-	return nil
+	return s, nil
+}
+
+// Restore recarrega as tabelas salvas por Snapshot. Os ipsets são mantidos:
+// sem as regras que os referenciam, não bloqueiam nada.
+func (f *IPTablesFirewall) Restore(s *Snapshot) error {
+	if err := checkSnapshot(f, s); err != nil {
+		return err
+	}
+	for _, cmd := range [][2]string{{"iptables-save", "iptables-restore"}, {"ip6tables-save", "ip6tables-restore"}} {
+		data, ok := s.Data[cmd[0]]
+		if !ok {
+			continue
+		}
+		if err := runWithInput(f.runner, data, cmd[1]); err != nil {
+			return fmt.Errorf("erro ao restaurar as regras do iptables: %w", err)
+		}
+	}
+	return f.save()
+}
+
+// BanIP bane um endereço IP (ou rede em notação CIDR) adicionando-o ao
+// ipset da sua família e do perfil. O TARPIT depende do alvo de mesmo nome
+// do xtables-addons.
+func (f *IPTablesFirewall) BanIP(ip string, profile BanProfile) error {
+	ip, err := prepareBan(f, ip, profile)
+	if err != nil {
+		return err
+	}
+
+	fam, single, err := ipsetFamilyFor(ip)
+	if err != nil {
+		return err
+	}
+	g := ipsetGroupFor(fam, profile)
+	set := g.nets
+	if single {
+		set = g.hosts
+	}
+
+	if err := f.ensureSets(g); err != nil {
+		return err
+	}
+	if err := f.ensureChain(fam); err != nil {
+		return err
+	}
+	if err := f.ensureBanRules(g); err != nil {
+		return err
+	}
+
+	args := []string{"add", set, ip, "-exist"}
+	if !g.legacy() {
+		args = []string{"add", set, ip, "comment", profile.String(), "-exist"}
+	}
+	if err := runCmd(f.runner, "ipset", args...); err != nil {
+		return fmt.Errorf("erro ao banir IP %s: %w", ip, err)
+	}
+	f.bans.record(ip, []string{"ipset " + set})
+
+	if err := f.save(); err != nil {
+		return err
+	}
+
+	return f.CheckBanOrder(ip)
 }
 
-// UnbanIP remove o banimento de um endereço IP usando o iptables
+// UnbanIP remove o IP dos ipsets onde o BanIP o colocou, além das regras por
+// porta criadas por versões anteriores do Guardian, e confirma que nenhuma
+// regra referente ao IP permaneceu
 func (f *IPTablesFirewall) UnbanIP(ip string) error {
-	cmd := exec.Command("iptables", "-D", "INPUT", "-s", ip, "-j", "DROP")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("erro ao desbanir IP %s: %w", ip, err)
-	}
-	
-	// Salvar configuração
-	saveCmd := exec.Command("sh", "-c", "iptables-save > /etc/iptables/rules.v4 || mkdir -p /etc/iptables && iptables-save > /etc/iptables/rules.v4")
-	if err := saveCmd.Run(); err != nil {
+	ip, err := prepareUnban(f, ip)
+	if err != nil {
+		return err
+	}
+
+	fam, _, err := ipsetFamilyFor(ip)
+	if err != nil {
+		return err
+	}
+
+	// Sem registro desta execução, as regras são obtidas da listagem
+	var fallback []string
+	bans, err := f.ListBanned()
+	if err != nil {
+		return err
+	}
+	if ban := FindBan(bans, ip); ban != nil {
+		fallback = ban.Rules
+	}
+
+	for _, rule := range f.bans.lookup(ip, fallback) {
+		if err := f.deleteRule(fam, ip, rule); err != nil {
+			return fmt.Errorf("erro ao desbanir IP %s: %w", ip, err)
+		}
+	}
+	f.bans.forget(ip)
+
+	if err := f.save(); err != nil {
 		return fmt.Errorf("erro ao salvar regras do iptables: %w", err)
 	}
-	
-	return nil
+
+	return f.verifyUnbanned(fam, ip)
+}
+
+// Batch aplica o lote com um único "ipset restore". Os sets e as regras de
+// cada grupo são preparados uma vez, e a ordem dos banimentos é conferida
+// para um IP de cada grupo, já que a regra é a mesma para todo o set.
+func (f *IPTablesFirewall) Batch(ops []BatchOp) []error {
+	bans, err := f.ListBanned()
+	if err != nil {
+		return batchFailed(ops, err)
+	}
+	plan := planBatch(bans, ops, nil)
+	if len(plan.add) == 0 && len(plan.remove) == 0 {
+		return plan.errs
+	}
+
+	var script strings.Builder
+	for _, c := range plan.remove {
+		fam, _, _ := ipsetFamilyFor(c.ip)
+		for _, rule := range f.bans.lookup(c.ip, c.rules) {
+			if set := strings.TrimPrefix(rule, "ipset "); set != rule {
+				fmt.Fprintf(&script, "del %s %s\n", set, c.ip)
+				continue
+			}
+			// Regras por porta de versões anteriores são removidas uma a uma
+			if err := f.deleteRule(fam, c.ip, rule); err != nil {
+				plan.fail(c.op, fmt.Errorf("erro ao desbanir IP %s: %w", c.ip, err))
+			}
+		}
+	}
+
+	var checked [][]batchChange
+	sets := make(map[int]string)
+	for _, group := range groupChanges(plan.add, func(c batchChange) string {
+		fam, _, _ := ipsetFamilyFor(c.ip)
+		return ipsetGroupFor(fam, c.profile).list
+	}) {
+		fam, _, _ := ipsetFamilyFor(group[0].ip)
+		g := ipsetGroupFor(fam, group[0].profile)
+		err := f.ensureSets(g)
+		if err == nil {
+			err = f.ensureChain(fam)
+		}
+		if err == nil {
+			err = f.ensureBanRules(g)
+		}
+		if err != nil {
+			for _, c := range group {
+				plan.fail(c.op, err)
+			}
+			continue
+		}
+
+		for _, c := range group {
+			_, single, _ := ipsetFamilyFor(c.ip)
+			set := g.nets
+			if single {
+				set = g.hosts
+			}
+			sets[c.op] = set
+			if g.legacy() {
+				fmt.Fprintf(&script, "add %s %s\n", set, c.ip)
+			} else {
+				fmt.Fprintf(&script, "add %s %s comment \"%s\"\n", set, c.ip, c.profile.String())
+			}
+		}
+		checked = append(checked, group)
+	}
+
+	if script.Len() > 0 {
+		if err := runWithInput(f.runner, []byte(script.String()), "ipset", "restore", "-exist"); err != nil {
+			plan.failAll(fmt.Errorf("erro ao aplicar o lote no ipset: %w", err))
+			return plan.errs
+		}
+	}
+	for _, c := range plan.remove {
+		f.bans.forget(c.ip)
+	}
+	for _, c := range plan.add {
+		if set, ok := sets[c.op]; ok {
+			f.bans.record(c.ip, []string{"ipset " + set})
+		}
+	}
+
+	if err := f.save(); err != nil {
+		plan.failAll(err)
+		return plan.errs
+	}
+
+	for _, group := range checked {
+		if err := f.CheckBanOrder(group[0].ip); err != nil {
+			for _, c := range group {
+				plan.fail(c.op, err)
+			}
+		}
+	}
+	if len(plan.remove) > 0 {
+		if bans, err := f.ListBanned(); err == nil {
+			plan.verifyRemoved(bans)
+		}
+	}
+
+	return plan.errs
+}
+
+// ListBanned lista os elementos dos ipsets do Guardian, com o perfil de cada
+// grupo, e as regras de DROP por porta deixadas na INPUT por versões anteriores
+func (f *IPTablesFirewall) ListBanned() ([]Ban, error) {
+	if err := f.runner.LookPath("ipset"); err != nil {
+		return nil, fmt.Errorf("erro ao listar banimentos: %w", err)
+	}
+
+	var bans []Ban
+	for _, fam := range []ipsetFamily{ipsetV4, ipsetV6} {
+		sets, err := f.memberSets(fam)
+		if err != nil {
+			return nil, err
+		}
+		for _, set := range sets {
+			output, err := f.runner.CombinedOutput("ipset", "list", set)
+			if err != nil {
+				return nil, fmt.Errorf("erro ao listar o ipset %s: %w", set, err)
+			}
+			for _, entry := range ipsetEntries(string(output)) {
+				profile, ok := ipsetEntryProfile(fam, set, entry)
+				if !ok {
+					continue
+				}
+				bans = addProfileRule(bans, entry.addr, fam.name, profile, "ipset "+set)
+			}
+		}
+
+		output, err := f.runner.CombinedOutput(fam.iptables, "-S", "INPUT")
+		if err != nil {
+			return nil, fmt.Errorf("erro ao listar a INPUT do %s: %w", fam.iptables, err)
+		}
+		bans = parseLegacyRules(bans, fam.name, string(output))
+	}
+
+	return bans, nil
+}
+
+// IsBanned verifica se o IP está banido no iptables
+func (f *IPTablesFirewall) IsBanned(ip string) (bool, error) {
+	bans, err := f.ListBanned()
+	if err != nil {
+		return false, err
+	}
+	return BannedIn(bans, ip)
+}
+
+// Counters retorna os contadores dos elementos dos ipsets do Guardian e das
+// regras de DROP por porta deixadas na INPUT por versões anteriores. Os
+// sets criados antes dos contadores não os têm; seus elementos ficam de fora
+// até que o set seja recriado.
+func (f *IPTablesFirewall) Counters() ([]Counters, error) {
+	var counters []Counters
+	for _, fam := range []ipsetFamily{ipsetV4, ipsetV6} {
+		sets, err := f.memberSets(fam)
+		if err != nil {
+			return nil, err
+		}
+		for _, set := range sets {
+			output, err := f.runner.CombinedOutput("ipset", "list", set)
+			if err != nil {
+				return nil, fmt.Errorf("erro ao listar o ipset %s: %w", set, err)
+			}
+			for _, entry := range ipsetEntries(string(output)) {
+				if _, ok := ipsetEntryProfile(fam, set, entry); ok && entry.counted {
+					counters = addCounters(counters, entry.addr, entry.packets, entry.bytes)
+				}
+			}
+		}
+
+		output, err := f.runner.CombinedOutput(fam.iptables, "-S", "INPUT", "-v")
+		if err != nil {
+			return nil, fmt.Errorf("erro ao listar a INPUT do %s: %w", fam.iptables, err)
+		}
+		counters = parseRuleCounters(counters, string(output), func(chain string) bool { return chain == "INPUT" })
+	}
+	return sortCounters(counters), nil
+}
+
+// CheckBanOrder confere que o IP está em um ipset do Guardian e que, no
+// caminho dos pacotes, o salto para a GUARDIAN e a regra do set vêm antes de
+// qualquer ACCEPT
+func (f *IPTablesFirewall) CheckBanOrder(ip string) error {
+	fam, _, err := ipsetFamilyFor(ip)
+	if err != nil {
+		return err
+	}
+
+	bans, err := f.ListBanned()
+	if err != nil {
+		return err
+	}
+	var set string
+	if ban := FindBan(bans, ip); ban != nil {
+		for _, rule := range ban.Rules {
+			if s := strings.TrimPrefix(rule, "ipset "); s != rule {
+				set = s
+				break
+			}
+		}
+	}
+	if set == "" {
+		return fmt.Errorf("IP %s não encontrado nos ipsets do Guardian", ip)
+	}
+
+	if err := runCmd(f.runner, "ipset", "test", set, ip); err != nil {
+		return fmt.Errorf("IP %s não encontrado no ipset %s: %w", ip, set, err)
+	}
+
+	input, err := f.runner.CombinedOutput(fam.iptables, "-S", "INPUT")
+	if err != nil {
+		return fmt.Errorf("erro ao listar a INPUT do %s: %w", fam.iptables, err)
+	}
+	chain, err := f.runner.CombinedOutput(fam.iptables, "-S", guardianChain)
+	if err != nil {
+		return fmt.Errorf("erro ao listar a chain %s do %s: %w", guardianChain, fam.iptables, err)
+	}
+
+	return checkIPTablesOrder(ip, ipsetListOf(set), string(input), string(chain))
 }
 
 // Type retorna o tipo do firewall
 func (f *IPTablesFirewall) Type() string {
 	return "iptables"
 }
+
+// deleteRule remove uma regra de banimento a partir do seu identificador:
+// "ipset <set>" para elementos de ipset ou a linha "-A ..." do iptables -S
+func (f *IPTablesFirewall) deleteRule(fam ipsetFamily, ip, rule string) error {
+	if set := strings.TrimPrefix(rule, "ipset "); set != rule {
+		return runCmd(f.runner, "ipset", "del", set, ip, "-exist")
+	}
+	if strings.HasPrefix(rule, "-A ") {
+		return runCmd(f.runner, fam.iptables, append([]string{"-D"}, strings.Fields(rule)[1:]...)...)
+	}
+	return fmt.Errorf("regra desconhecida: %s", rule)
+}
+
+// verifyUnbanned confere que o IP não está em nenhum set do Guardian e que
+// nenhuma regra do iptables o referencia
+func (f *IPTablesFirewall) verifyUnbanned(fam ipsetFamily, ip string) error {
+	var remaining []string
+
+	sets, err := f.memberSets(fam)
+	if err != nil {
+		return err
+	}
+	for _, set := range sets {
+		output, err := f.runner.CombinedOutput("ipset", "list", set)
+		if err != nil {
+			return fmt.Errorf("erro ao listar o ipset %s: %w", set, err)
+		}
+		if containsString(ipsetMembers(string(output)), ip) {
+			remaining = append(remaining, "ipset "+set+" "+ip)
+		}
+	}
+
+	output, err := f.runner.CombinedOutput(fam.iptables, "-S")
+	if err != nil {
+		return fmt.Errorf("erro ao listar regras do %s: %w", fam.iptables, err)
+	}
+	remaining = append(remaining, iptablesRulesFor(ip, string(output))...)
+
+	if len(remaining) > 0 {
+		return &RemainingRulesError{IP: ip, Rules: remaining}
+	}
+	return nil
+}
+
+// ensureSets cria os sets do grupo caso ainda não existam. Os sets novos
+// têm contadores por elemento; os já existentes são mantidos como estão, já
+// que o -exist só aceita recriar um set com as mesmas opções.
+func (f *IPTablesFirewall) ensureSets(g ipsetGroup) error {
+	output, err := f.runner.CombinedOutput("ipset", "list", "-n")
+	if err != nil {
+		return fmt.Errorf("erro ao listar os ipsets: %w (%s)", err, strings.TrimSpace(string(output)))
+	}
+	existing := strings.Fields(string(output))
+
+	var cmds [][]string
+	for _, set := range []struct{ name, kind string }{{g.hosts, "hash:ip"}, {g.nets, "hash:net"}} {
+		args := []string{"create", set.name, set.kind, "family", g.fam.family}
+		if !g.legacy() {
+			args = append(args, "comment")
+		}
+		if !containsString(existing, set.name) {
+			args = append(args, "counters")
+		}
+		cmds = append(cmds, append(args, "-exist"))
+	}
+	cmds = append(cmds,
+		[]string{"create", g.list, "list:set", "-exist"},
+		[]string{"add", g.list, g.hosts, "-exist"},
+		[]string{"add", g.list, g.nets, "-exist"},
+	)
+
+	for _, args := range cmds {
+		if err := runCmd(f.runner, "ipset", args...); err != nil {
+			return fmt.Errorf("erro ao criar ipset %s: %w", g.list, err)
+		}
+	}
+
+	return nil
+}
+
+// memberSets retorna os sets hash:ip e hash:net do Guardian na família
+func (f *IPTablesFirewall) memberSets(fam ipsetFamily) ([]string, error) {
+	output, err := f.runner.CombinedOutput("ipset", "list", "-n")
+	if err != nil {
+		return nil, fmt.Errorf("erro ao listar os ipsets: %w (%s)", err, strings.TrimSpace(string(output)))
+	}
+
+	var sets []string
+	for _, name := range strings.Fields(string(output)) {
+		if strings.HasPrefix(name, ipsetPrefix) &&
+			(strings.HasSuffix(name, "ip"+fam.suffix) || strings.HasSuffix(name, "net"+fam.suffix)) {
+			sets = append(sets, name)
+		}
+	}
+	return sets, nil
+}
+
+// groups retorna os grupos de sets da família cujo perfil é conhecido: o
+// grupo legado e os grupos com ao menos um elemento comentado. Grupos
+// vazios não precisam de regras.
+func (f *IPTablesFirewall) groups(fam ipsetFamily) ([]ipsetGroup, error) {
+	groups := []ipsetGroup{ipsetGroupFor(fam, iptablesLegacyProfile)}
+
+	sets, err := f.memberSets(fam)
+	if err != nil {
+		return nil, err
+	}
+	for _, set := range sets {
+		output, err := f.runner.CombinedOutput("ipset", "list", set)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao listar o ipset %s: %w", set, err)
+		}
+		for _, entry := range ipsetEntries(string(output)) {
+			profile, ok := ipsetEntryProfile(fam, set, entry)
+			if !ok {
+				continue
+			}
+			g := ipsetGroupFor(fam, profile)
+			found := false
+			for _, other := range groups {
+				found = found || other.list == g.list
+			}
+			if !found {
+				groups = append(groups, g)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// ensureChain cria a chain GUARDIAN, caso ainda não exista, e garante o salto
+// na posição 1 da INPUT
+func (f *IPTablesFirewall) ensureChain(fam ipsetFamily) error {
+	if !succeeds(f.runner, fam.iptables, "-n", "-L", guardianChain) {
+		if err := runCmd(f.runner, fam.iptables, "-N", guardianChain); err != nil {
+			return fmt.Errorf("erro ao criar a chain %s no %s: %w", guardianChain, fam.iptables, err)
+		}
+	}
+
+	// O salto precisa ser a primeira regra da INPUT; se outra ferramenta
+	// inseriu regras antes dele, o salto é movido de volta para o topo
+	output, err := f.runner.CombinedOutput(fam.iptables, "-S", "INPUT")
+	if err != nil {
+		return fmt.Errorf("erro ao listar a INPUT do %s: %w", fam.iptables, err)
+	}
+	if firstRule(string(output)) == "-A INPUT -j "+guardianChain {
+		return nil
+	}
+
+	for succeeds(f.runner, fam.iptables, "-C", "INPUT", "-j", guardianChain) {
+		if err := runCmd(f.runner, fam.iptables, "-D", "INPUT", "-j", guardianChain); err != nil {
+			return fmt.Errorf("erro ao reposicionar a chain %s na INPUT: %w", guardianChain, err)
+		}
+	}
+	if err := runCmd(f.runner, fam.iptables, "-I", "INPUT", "1", "-j", guardianChain); err != nil {
+		return fmt.Errorf("erro ao referenciar a chain %s na INPUT: %w", guardianChain, err)
+	}
+
+	return nil
+}
+
+// ensureBanRules garante as regras que referenciam o set do grupo,
+// inseridas no topo da chain GUARDIAN e, para o tráfego dos containers do
+// Docker, da DOCKER-USER. Como as regras referenciam o set, o desbanimento
+// vale para as duas chains.
+func (f *IPTablesFirewall) ensureBanRules(g ipsetGroup) error {
+	for _, rule := range banRules(g) {
+		if succeeds(f.runner, g.fam.iptables, append([]string{"-C"}, rule...)...) {
+			continue
+		}
+		rule = append([]string{rule[0], "1"}, rule[1:]...)
+		if err := runCmd(f.runner, g.fam.iptables, append([]string{"-I"}, rule...)...); err != nil {
+			return fmt.Errorf("erro ao criar regra de banimento no %s: %w", g.fam.iptables, err)
+		}
+	}
+
+	on, err := f.docker.enabled(g.fam.iptables)
+	if err != nil || !on {
+		return err
+	}
+	return f.docker.ensureRules(g.fam.iptables, dockerRules([]string{"-m", "set", "--match-set", g.list, "src"}, g.profile))
+}
+
+// syncDocker garante as regras de cada grupo de sets na DOCKER-USER
+func (f *IPTablesFirewall) syncDocker() error {
+	for _, fam := range []ipsetFamily{ipsetV4, ipsetV6} {
+		on, err := f.docker.enabled(fam.iptables)
+		if err != nil {
+			return err
+		}
+		if !on {
+			continue
+		}
+		groups, err := f.groups(fam)
+		if err != nil {
+			return err
+		}
+		for _, g := range groups {
+			if err := f.docker.ensureRules(fam.iptables, dockerRules([]string{"-m", "set", "--match-set", g.list, "src"}, g.profile)); err != nil {
+				return err
+			}
+		}
+	}
+	return f.save()
+}
+
+// banRules retorna as especificações das regras que aplicam o perfil do grupo
+// aos elementos do set: uma por protocolo e grupo de até 15 portas (limite
+// do multiport), ou uma única regra para todo o tráfego
+func banRules(g ipsetGroup) [][]string {
+	target := strings.ToUpper(g.profile.Action)
+	base := []string{guardianChain, "-m", "set", "--match-set", g.list, "src"}
+	if len(g.profile.Protocols) == 0 {
+		return [][]string{append(base, "-j", target)}
+	}
+
+	var rules [][]string
+	for _, proto := range g.profile.Protocols {
+		rule := append(append([]string{}, base...), "-p", proto)
+		if g.profile.AllPorts() {
+			rules = append(rules, append(rule, "-j", target))
+			continue
+		}
+		for _, ports := range chunkPorts(g.profile.Ports, 15) {
+			rules = append(rules, append(append([]string{}, rule...), "-m", "multiport", "--dports", joinPorts(ports), "-j", target))
+		}
+	}
+	return rules
+}
+
+// restoreSets recarrega os sets salvos anteriormente, se existirem
+func (f *IPTablesFirewall) restoreSets() error {
+	script := fmt.Sprintf("[ ! -f %[1]s ] || ipset restore -exist -file %[1]s", ipsetFile)
+	if err := runCmd(f.runner, "sh", "-c", script); err != nil {
+		return fmt.Errorf("erro ao restaurar ipsets de %s: %w", ipsetFile, err)
+	}
+	return nil
+}
+
+// save persiste os ipsets e as regras de cada família em /etc/iptables
+func (f *IPTablesFirewall) save() error {
+	cmds := []string{
+		"mkdir -p /etc/iptables",
+		"ipset save > " + ipsetFile,
+		"iptables-save > /etc/iptables/rules.v4",
+		"ip6tables-save > /etc/iptables/rules.v6",
+	}
+
+	for _, script := range cmds {
+		if err := runCmd(f.runner, "sh", "-c", script); err != nil {
+			return fmt.Errorf("erro ao salvar regras do iptables: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ipsetFamilyFor retorna a família do endereço e se ele é um único IP (set
+// hash:ip) ou uma rede (set hash:net)
+func ipsetFamilyFor(ip string) (ipsetFamily, bool, error) {
+	prefix, err := ParseTarget(ip)
+	if err != nil {
+		return ipsetFamily{}, false, err
+	}
+
+	fam := ipsetV4
+	if prefix.Addr().Is6() {
+		fam = ipsetV6
+	}
+	return fam, prefix.IsSingleIP(), nil
+}
+
+// ipsetListOf retorna o list:set do grupo ao qual o set hash:ip ou hash:net
+// pertence
+func ipsetListOf(set string) string {
+	for _, kind := range []string{"ip", "net"} {
+		for _, suffix := range []string{"4", "6"} {
+			if strings.HasSuffix(set, kind+suffix) {
+				return strings.TrimSuffix(set, kind+suffix) + "v" + suffix
+			}
+		}
+	}
+	return set
+}
+
+// ipsetEntryProfile retorna o perfil de banimento de um elemento: o perfil
+// legado para os sets guardian-ip e guardian-net, ou o perfil registrado no
+// comentário do elemento
+func ipsetEntryProfile(fam ipsetFamily, set string, entry ipsetEntry) (BanProfile, bool) {
+	if set == ipsetPrefix+"ip"+fam.suffix || set == ipsetPrefix+"net"+fam.suffix {
+		return iptablesLegacyProfile, true
+	}
+	profile, err := config.ParseBanProfile(entry.comment)
+	if err != nil {
+		return BanProfile{}, false
+	}
+	return profile, true
+}
+
+// parseLegacyRules extrai de uma listagem do iptables -S as regras de DROP
+// por IP e porta criadas pelas versões anteriores do Guardian
+func parseLegacyRules(bans []Ban, family, listing string) []Ban {
+	for _, line := range strings.Split(listing, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "-A INPUT ") || !strings.HasSuffix(line, "-j DROP") {
+			continue
+		}
+
+		var ip string
+		port := 0
+		fields := strings.Fields(line)
+		for i := 0; i+1 < len(fields); i++ {
+			switch fields[i] {
+			case "-s":
+				ip = strings.TrimSuffix(strings.TrimSuffix(fields[i+1], "/32"), "/128")
+			case "--dport":
+				port, _ = strconv.Atoi(fields[i+1])
+			}
+		}
+		if ip != "" {
+			bans = addBanRule(bans, ip, family, port, "tcp", config.ActionDrop, line)
+		}
+	}
+	return bans
+}
+
+// ipsetEntry é um elemento de um ipset com seu comentário e, nos sets
+// criados com a opção counters, seus contadores
+type ipsetEntry struct {
+	addr    string
+	comment string
+	packets uint64
+	bytes   uint64
+	counted bool
+}
+
+// ipsetEntries retorna os elementos de uma listagem do "ipset list"
+func ipsetEntries(listing string) []ipsetEntry {
+	var entries []ipsetEntry
+	inMembers := false
+	for _, line := range strings.Split(listing, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "Members:" {
+			inMembers = true
+			continue
+		}
+		if !inMembers || line == "" {
+			continue
+		}
+
+		entry := ipsetEntry{addr: strings.Fields(line)[0]}
+		if i := strings.Index(line, ` comment "`); i >= 0 {
+			entry.comment = strings.TrimSuffix(line[i+len(` comment "`):], `"`)
+			line = line[:i]
+		}
+		entry.packets, entry.bytes, entry.counted = parseCounterFields(strings.Fields(line))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// ipsetMembers retorna os endereços de uma listagem do "ipset list"
+func ipsetMembers(listing string) []string {
+	var members []string
+	for _, entry := range ipsetEntries(listing) {
+		members = append(members, entry.addr)
+	}
+	return members
+}
+
+// iptablesRulesFor retorna as regras de uma listagem do iptables -S que têm o
+// IP como origem ou destino
+func iptablesRulesFor(ip, listing string) []string {
+	addrs := []string{ip}
+	if !strings.Contains(ip, "/") {
+		addrs = append(addrs, ip+"/32", ip+"/128")
+	}
+
+	var rules []string
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Fields(line)
+		for i := 0; i+1 < len(fields); i++ {
+			if (fields[i] == "-s" || fields[i] == "-d") && containsString(addrs, fields[i+1]) {
+				rules = append(rules, strings.TrimSpace(line))
+				break
+			}
+		}
+	}
+	return rules
+}
+
+// checkIPTablesOrder confere, nas listagens do iptables -S, que o salto para
+// a GUARDIAN vem antes de qualquer ACCEPT na INPUT e que o DROP do set vem
+// antes de qualquer ACCEPT na GUARDIAN
+func checkIPTablesOrder(ip, set, input, chain string) error {
+	if err := precedesAccept(ip, input, "-A INPUT -j "+guardianChain); err != nil {
+		return err
+	}
+	return precedesAccept(ip, chain, "--match-set "+set+" src")
+}
+
+// precedesAccept percorre uma listagem do iptables -S e confirma que a regra
+// que contém target aparece antes de qualquer regra com -j ACCEPT
+func precedesAccept(ip, listing, target string) error {
+	for _, line := range strings.Split(listing, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "-A ") {
+			continue
+		}
+		if strings.Contains(line, target) {
+			return nil
+		}
+		if strings.Contains(line, "-j ACCEPT") {
+			return &ShadowedError{IP: ip, Rule: line}
+		}
+	}
+	return fmt.Errorf("regra '%s' não encontrada", target)
+}
+
+// firstRule retorna a primeira regra (-A) de uma listagem do iptables -S
+func firstRule(listing string) string {
+	for _, line := range strings.Split(listing, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "-A ") {
+			return line
+		}
+	}
+	return ""
+}
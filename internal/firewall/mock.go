@@ -0,0 +1,146 @@
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MockFirewall implementa a interface Firewall para testes
+type MockFirewall struct {
+	mu       sync.Mutex
+	enabled  bool
+	banned   map[string]BanProfile
+	counters map[string]Counters
+}
+
+func NewMockFirewall() *MockFirewall {
+	return &MockFirewall{
+		enabled:  false,
+		banned:   make(map[string]BanProfile),
+		counters: make(map[string]Counters),
+	}
+}
+
+// SetCounters define os contadores retornados para o banimento do IP
+func (f *MockFirewall) SetCounters(ip string, packets, bytes uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counters[ip] = Counters{IP: ip, Packets: packets, Bytes: bytes}
+}
+
+func (f *MockFirewall) IsEnabled() (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.enabled, nil
+}
+
+func (f *MockFirewall) Enable() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.enabled = true
+	return nil
+}
+
+func (f *MockFirewall) Disable() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.enabled = false
+	return nil
+}
+
+func (f *MockFirewall) BanIP(ip string, profile BanProfile) error {
+	ip, err := prepareBan(f, ip, profile)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.banned[ip] = profile
+	return nil
+}
+
+func (f *MockFirewall) UnbanIP(ip string) error {
+	ip, err := prepareUnban(f, ip)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.banned, ip)
+	return nil
+}
+
+func (f *MockFirewall) Batch(ops []BatchOp) []error {
+	return batchEach(f, ops)
+}
+
+func (f *MockFirewall) CheckBanOrder(ip string) error {
+	if banned, _ := f.IsBanned(ip); !banned {
+		return fmt.Errorf("IP %s não está banido", ip)
+	}
+	return nil
+}
+
+func (f *MockFirewall) ListBanned() ([]Ban, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var bans []Ban
+	for ip, profile := range f.banned {
+		family, _ := ipFamily(ip)
+		bans = addProfileRule(bans, ip, family, profile, "mock "+ip)
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].IP < bans[j].IP })
+	return bans, nil
+}
+
+func (f *MockFirewall) IsBanned(ip string) (bool, error) {
+	bans, _ := f.ListBanned()
+	return BannedIn(bans, ip)
+}
+
+func (f *MockFirewall) Counters() ([]Counters, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var counters []Counters
+	for ip := range f.banned {
+		c := f.counters[ip]
+		c.IP = ip
+		counters = append(counters, c)
+	}
+	return sortCounters(counters), nil
+}
+
+func (f *MockFirewall) Snapshot() (*Snapshot, error) {
+	s := newSnapshot(f)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, err := json.Marshal(f.banned)
+	if err != nil {
+		return nil, err
+	}
+	s.Data["banned"] = data
+	return s, nil
+}
+
+func (f *MockFirewall) Restore(s *Snapshot) error {
+	if err := checkSnapshot(f, s); err != nil {
+		return err
+	}
+	banned := make(map[string]BanProfile)
+	if err := json.Unmarshal(s.Data["banned"], &banned); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.enabled, f.banned = s.Enabled, banned
+	return nil
+}
+
+func (f *MockFirewall) Type() string {
+	return "mock"
+}
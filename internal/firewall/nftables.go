@@ -0,0 +1,728 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mtm/guardian/internal/config"
+)
+
+const (
+	// nftTable é a tabela própria do Guardian no nftables
+	nftTable = "guardian"
+	// nftSetV4 e nftSetV6 guardam os endereços banidos de cada família com o
+	// perfil que bloqueia todo o tráfego (nftLegacyProfile)
+	nftSetV4 = "banned4"
+	nftSetV6 = "banned6"
+	// nftBansChain reúne as regras dos sets dos demais perfis de banimento.
+	// É chamada no início da chain input e não é recriada pelo Enable.
+	nftBansChain = "bans"
+	// nftForwardChain aplica os banimentos ao tráfego encaminhado aos
+	// containers do Docker. Só existe quando o Docker é detectado (ou com
+	// GUARDIAN_DOCKER_BANS=on) e é recriada a partir dos sets.
+	nftForwardChain = "forward"
+	// nftRulesFile é onde a tabela do Guardian é persistida. O arquivo não
+	// é incluído pelo nftables.conf; é o Enable que o recarrega quando a
+	// tabela não existe, como depois de um reboot.
+	nftRulesFile = "/etc/nftables.d/guardian.nft"
+)
+
+// nftLegacyProfile é o perfil dos sets banned4 e banned6, criados antes dos
+// perfis de banimento: DROP de todo o tráfego
+var nftLegacyProfile = BanProfile{Action: config.ActionDrop}
+
+// NFTablesFirewall implementa a interface Firewall para o nftables.
+// Todas as regras ficam na tabela "inet guardian"; os banimentos são
+// elementos de sets, um por família e perfil de banimento, cada um
+// referenciado por uma única regra. O perfil de cada set fica registrado no
+// seu comentário.
+type NFTablesFirewall struct {
+	runner Runner
+	bans   banTracker
+	docker dockerBans
+}
+
+// IsEnabled verifica se a tabela do Guardian existe no nftables
+func (f *NFTablesFirewall) IsEnabled() (bool, error) {
+	if err := f.runner.LookPath("nft"); err != nil {
+		return false, fmt.Errorf("erro ao verificar status do nftables: %w", err)
+	}
+
+	if !succeeds(f.runner, "nft", "list", "chain", "inet", nftTable, "input") {
+		return false, nil // Tabela ou chain ainda não criadas
+	}
+
+	return true, nil
+}
+
+// Enable cria a tabela do Guardian com os sets de banimento e as regras que
+// os aplicam. A chain input aceita o tráfego por padrão e apenas descarta os
+// banidos, como a chain GUARDIAN do iptables, deixando as liberações e a
+// política do host com as demais tabelas. Os sets existentes (e seus
+// elementos) são preservados; apenas as regras da chain input são recriadas.
+func (f *NFTablesFirewall) Enable() error {
+	// Recarregar a tabela salva antes de recriar as regras que usam os sets
+	if err := f.restoreTable(); err != nil {
+		return err
+	}
+
+	existing := f.existingSets()
+	var sets string
+	for _, s := range []struct{ name, addrType string }{{nftSetV4, "ipv4_addr"}, {nftSetV6, "ipv6_addr"}} {
+		if !existing[s.name] {
+			sets += fmt.Sprintf("\tset %s {\n\t\ttype %s\n\t\tflags interval\n\t\tcounter\n\t}\n", s.name, s.addrType)
+		}
+	}
+
+	script := fmt.Sprintf(`table inet %[1]s {
+%[5]s	chain %[4]s {
+	}
+	chain input {
+		type filter hook input priority -10; policy accept;
+	}
+}
+flush chain inet %[1]s input
+table inet %[1]s {
+	chain input {
+		jump %[4]s
+		ip saddr @%[2]s drop
+		ip6 saddr @%[3]s drop
+	}
+}
+`, nftTable, nftSetV4, nftSetV6, nftBansChain, sets)
+
+	if err := f.apply(script); err != nil {
+		return err
+	}
+	if err := f.ensureForward(); err != nil {
+		return err
+	}
+
+	return f.save()
+}
+
+// Disable remove a tabela do Guardian do nftables
+func (f *NFTablesFirewall) Disable() error {
+	if output, err := f.runner.CombinedOutput("nft", "delete", "table", "inet", nftTable); err != nil {
+		return fmt.Errorf("erro ao remover tabela %s do nftables: %w (%s)", nftTable, err, strings.TrimSpace(string(output)))
+	}
+
+	return f.save()
+}
+
+// Snapshot salva todo o conjunto de regras do nftables, não apenas a tabela
+// do Guardian
+func (f *NFTablesFirewall) Snapshot() (*Snapshot, error) {
+	s := newSnapshot(f)
+	output, err := f.runner.Output("nft", "list", "ruleset")
+	if err != nil {
+		return nil, fmt.Errorf("erro ao salvar as regras do nftables: %w", err)
+	}
+	s.Data["ruleset"] = output
+	return s, nil
+}
+
+// Restore substitui o conjunto de regras pelo salvo em Snapshot numa única
+// transação
+func (f *NFTablesFirewall) Restore(s *Snapshot) error {
+	if err := checkSnapshot(f, s); err != nil {
+		return err
+	}
+	if err := f.apply("flush ruleset\n" + string(s.Data["ruleset"])); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// BanIP bane um endereço IP adicionando-o ao set da sua família e do perfil.
+// O nftables não tem um equivalente ao TARPIT.
+func (f *NFTablesFirewall) BanIP(ip string, profile BanProfile) error {
+	if profile.Action != config.ActionDrop && profile.Action != config.ActionReject {
+		return &UnsupportedActionError{Firewall: "nftables", Action: profile.Action}
+	}
+
+	ip, err := prepareBan(f, ip, profile)
+	if err != nil {
+		return err
+	}
+
+	set, err := f.ensureProfileSet(ip, profile)
+	if err != nil {
+		return err
+	}
+
+	if output, err := f.runner.CombinedOutput("nft", "add", "element", "inet", nftTable, set, "{", ip, "}"); err != nil {
+		return fmt.Errorf("erro ao banir IP %s: %w (%s)", ip, err, strings.TrimSpace(string(output)))
+	}
+	f.bans.record(ip, []string{"set " + set})
+
+	if err := f.save(); err != nil {
+		return err
+	}
+
+	return f.CheckBanOrder(ip)
+}
+
+// UnbanIP remove o endereço IP dos sets onde o BanIP o colocou e confirma
+// que nenhuma regra da tabela do Guardian o referencia
+func (f *NFTablesFirewall) UnbanIP(ip string) error {
+	ip, err := prepareUnban(f, ip)
+	if err != nil {
+		return err
+	}
+
+	// Sem registro desta execução, os sets são obtidos da listagem
+	var fallback []string
+	bans, err := f.ListBanned()
+	if err != nil {
+		return err
+	}
+	if ban := FindBan(bans, ip); ban != nil {
+		fallback = ban.Rules
+	}
+
+	for _, rule := range f.bans.lookup(ip, fallback) {
+		s := strings.TrimPrefix(rule, "set ")
+		// O elemento pode já ter sido removido manualmente
+		if !succeeds(f.runner, "nft", "get", "element", "inet", nftTable, s, "{", ip, "}") {
+			continue
+		}
+		if output, err := f.runner.CombinedOutput("nft", "delete", "element", "inet", nftTable, s, "{", ip, "}"); err != nil {
+			return fmt.Errorf("erro ao desbanir IP %s: %w (%s)", ip, err, strings.TrimSpace(string(output)))
+		}
+	}
+	f.bans.forget(ip)
+
+	if err := f.save(); err != nil {
+		return err
+	}
+
+	output, err := f.runner.CombinedOutput("nft", "list", "table", "inet", nftTable)
+	if err != nil {
+		return fmt.Errorf("erro ao listar a tabela %s do nftables: %w", nftTable, err)
+	}
+	if remaining := nftRulesFor(ip, string(output)); len(remaining) > 0 {
+		return &RemainingRulesError{IP: ip, Rules: remaining}
+	}
+
+	return nil
+}
+
+// Batch aplica o lote numa única transação do nftables (nft -f -): os
+// elementos removidos e os adicionados a cada set vão no mesmo script, e a
+// ordem dos banimentos é conferida para um IP de cada set
+func (f *NFTablesFirewall) Batch(ops []BatchOp) []error {
+	bans, err := f.ListBanned()
+	if err != nil {
+		return batchFailed(ops, err)
+	}
+	plan := planBatch(bans, ops, func(ip string, profile BanProfile) error {
+		if profile.Action != config.ActionDrop && profile.Action != config.ActionReject {
+			return &UnsupportedActionError{Firewall: "nftables", Action: profile.Action}
+		}
+		return nil
+	})
+	if len(plan.add) == 0 && len(plan.remove) == 0 {
+		return plan.errs
+	}
+
+	var script strings.Builder
+	for _, c := range plan.remove {
+		for _, rule := range f.bans.lookup(c.ip, c.rules) {
+			fmt.Fprintf(&script, "delete element inet %s %s { %s }\n", nftTable, strings.TrimPrefix(rule, "set "), c.ip)
+		}
+	}
+
+	var checked [][]batchChange
+	sets := make(map[int]string)
+	for _, group := range groupChanges(plan.add, func(c batchChange) string {
+		family, _ := ipFamily(c.ip)
+		return family + " " + c.profile.String()
+	}) {
+		set, err := f.ensureProfileSet(group[0].ip, group[0].profile)
+		if err != nil {
+			for _, c := range group {
+				plan.fail(c.op, err)
+			}
+			continue
+		}
+
+		var ips []string
+		for _, c := range group {
+			ips = append(ips, c.ip)
+			sets[c.op] = set
+		}
+		fmt.Fprintf(&script, "add element inet %s %s { %s }\n", nftTable, set, strings.Join(ips, ", "))
+		checked = append(checked, group)
+	}
+
+	if script.Len() > 0 {
+		if err := f.apply(script.String()); err != nil {
+			plan.failAll(err)
+			return plan.errs
+		}
+	}
+	for _, c := range plan.remove {
+		f.bans.forget(c.ip)
+	}
+	for _, c := range plan.add {
+		if set, ok := sets[c.op]; ok {
+			f.bans.record(c.ip, []string{"set " + set})
+		}
+	}
+
+	if err := f.save(); err != nil {
+		plan.failAll(err)
+		return plan.errs
+	}
+
+	for _, group := range checked {
+		if err := f.CheckBanOrder(group[0].ip); err != nil {
+			for _, c := range group {
+				plan.fail(c.op, err)
+			}
+		}
+	}
+	if len(plan.remove) > 0 {
+		if bans, err := f.ListBanned(); err == nil {
+			plan.verifyRemoved(bans)
+		}
+	}
+
+	return plan.errs
+}
+
+// ListBanned lista os elementos dos sets de banimento da tabela do Guardian,
+// com o perfil registrado no comentário de cada set
+func (f *NFTablesFirewall) ListBanned() ([]Ban, error) {
+	output, err := f.listTable()
+	if err != nil {
+		return nil, err
+	}
+
+	var bans []Ban
+	for _, set := range nftSets(output) {
+		profile, ok := nftSetProfile(set)
+		if !ok {
+			continue // Set que não pertence a um perfil de banimento
+		}
+		for _, elem := range set.elements {
+			family, err := ipFamily(elem)
+			if err != nil {
+				continue
+			}
+			bans = addProfileRule(bans, elem, family, profile, "set "+set.name)
+		}
+	}
+	return bans, nil
+}
+
+// Counters retorna os contadores dos elementos dos sets de banimento. Os sets
+// criados antes dos contadores não os têm; seus elementos ficam de fora até
+// que o set seja recriado.
+func (f *NFTablesFirewall) Counters() ([]Counters, error) {
+	output, err := f.listTable()
+	if err != nil {
+		return nil, err
+	}
+
+	var counters []Counters
+	for _, set := range nftSets(output) {
+		if _, ok := nftSetProfile(set); !ok {
+			continue
+		}
+		for _, c := range set.counters {
+			counters = addCounters(counters, c.IP, c.Packets, c.Bytes)
+		}
+	}
+	return sortCounters(counters), nil
+}
+
+// IsBanned verifica se o IP está banido no nftables
+func (f *NFTablesFirewall) IsBanned(ip string) (bool, error) {
+	bans, err := f.ListBanned()
+	if err != nil {
+		return false, err
+	}
+	return BannedIn(bans, ip)
+}
+
+// CheckBanOrder confere que o IP está em um set de banimento e que a regra
+// do set é avaliada antes de qualquer accept na chain input: diretamente,
+// para os sets banned4 e banned6, ou pelo salto para a chain bans, que só
+// contém regras de bloqueio. Um accept em outra tabela não anula o drop do
+// Guardian, então basta verificar as próprias chains.
+func (f *NFTablesFirewall) CheckBanOrder(ip string) error {
+	bans, err := f.ListBanned()
+	if err != nil {
+		return err
+	}
+	ban := FindBan(bans, ip)
+	if ban == nil || len(ban.Rules) == 0 {
+		return fmt.Errorf("IP %s não encontrado nos sets do Guardian", ip)
+	}
+	set := strings.TrimPrefix(ban.Rules[0], "set ")
+
+	if output, err := f.runner.CombinedOutput("nft", "get", "element", "inet", nftTable, set, "{", ip, "}"); err != nil {
+		return fmt.Errorf("IP %s não encontrado no set %s: %w (%s)", ip, set, err, strings.TrimSpace(string(output)))
+	}
+
+	input, err := f.runner.CombinedOutput("nft", "list", "chain", "inet", nftTable, "input")
+	if err != nil {
+		return fmt.Errorf("erro ao listar a chain input do nftables: %w", err)
+	}
+	if set == nftSetV4 || set == nftSetV6 {
+		return checkNFTOrder(ip, set, string(input))
+	}
+
+	if err := nftPrecedesAccept(ip, string(input), "jump "+nftBansChain); err != nil {
+		return err
+	}
+	chain, err := f.runner.CombinedOutput("nft", "list", "chain", "inet", nftTable, nftBansChain)
+	if err != nil {
+		return fmt.Errorf("erro ao listar a chain %s do nftables: %w", nftBansChain, err)
+	}
+	return checkNFTOrder(ip, set, string(chain))
+}
+
+// Type retorna o tipo do firewall
+func (f *NFTablesFirewall) Type() string {
+	return "nftables"
+}
+
+// apply executa um script no nftables de forma atômica (nft -f -)
+func (f *NFTablesFirewall) apply(script string) error {
+	if output, err := f.runner.RunInput(script, "nft", "-f", "-"); err != nil {
+		return fmt.Errorf("erro ao aplicar regras do nftables: %w (%s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// restoreTable recarrega a tabela salva, se existir, quando ela ainda não
+// está no nftables. Com a tabela presente, o arquivo duplicaria as regras
+// das chains.
+func (f *NFTablesFirewall) restoreTable() error {
+	script := fmt.Sprintf("nft list table inet %[1]s >/dev/null 2>&1 || [ ! -f %[2]s ] || nft -f %[2]s", nftTable, nftRulesFile)
+	if err := runCmd(f.runner, "sh", "-c", script); err != nil {
+		return fmt.Errorf("erro ao restaurar a tabela %s de %s: %w", nftTable, nftRulesFile, err)
+	}
+	return nil
+}
+
+// save persiste a tabela do Guardian para ser recarregada pelo Enable
+func (f *NFTablesFirewall) save() error {
+	script := fmt.Sprintf("mkdir -p /etc/nftables.d && (nft list table inet %s > %s 2>/dev/null || rm -f %s)", nftTable, nftRulesFile, nftRulesFile)
+	if _, err := f.runner.CombinedOutput("sh", "-c", script); err != nil {
+		return fmt.Errorf("erro ao salvar regras do nftables: %w", err)
+	}
+	return nil
+}
+
+// ensureProfileSet garante o set da família do IP para o perfil e a regra
+// que o referencia, retornando o nome do set. O perfil que bloqueia todo o
+// tráfego usa os sets banned4 e banned6, referenciados na chain input.
+func (f *NFTablesFirewall) ensureProfileSet(ip string, profile BanProfile) (string, error) {
+	family, err := ipFamily(ip)
+	if err != nil {
+		return "", err
+	}
+
+	if profile.Equal(nftLegacyProfile) {
+		if family == "ipv4" {
+			return nftSetV4, nil
+		}
+		return nftSetV6, nil
+	}
+
+	addrType, match, set := "ipv4_addr", "ip", "b4_"+profileID(profile)
+	if family == "ipv6" {
+		addrType, match, set = "ipv6_addr", "ip6", "b6_"+profileID(profile)
+	}
+
+	// Os sets existentes são mantidos como estão: redeclarar um set com
+	// opções diferentes (como um set criado antes dos contadores) falha
+	declaration := ""
+	if !f.existingSets()[set] {
+		declaration = fmt.Sprintf("\tset %s {\n\t\ttype %s\n\t\tflags interval\n\t\tcounter\n\t\tcomment \"%s\"\n\t}\n", set, addrType, profile.String())
+	}
+	script := fmt.Sprintf(`table inet %s {
+%s	chain %s {
+	}
+}
+`, nftTable, declaration, nftBansChain)
+	if err := f.apply(script); err != nil {
+		return "", err
+	}
+
+	// Tabelas criadas antes dos perfis não têm o salto para a chain bans
+	input, err := f.runner.CombinedOutput("nft", "list", "chain", "inet", nftTable, "input")
+	if err != nil {
+		return "", fmt.Errorf("erro ao listar a chain input do nftables: %w", err)
+	}
+	if !strings.Contains(string(input), "jump "+nftBansChain) {
+		if err := f.apply(fmt.Sprintf("insert rule inet %s input jump %s\n", nftTable, nftBansChain)); err != nil {
+			return "", err
+		}
+	}
+
+	output, err := f.runner.CombinedOutput("nft", "list", "chain", "inet", nftTable, nftBansChain)
+	if err != nil {
+		return "", fmt.Errorf("erro ao listar a chain %s do nftables: %w", nftBansChain, err)
+	}
+	if strings.Contains(string(output), "@"+set+" ") {
+		return set, nil
+	}
+
+	rule := fmt.Sprintf("add rule inet %s %s %s\n", nftTable, nftBansChain, nftProfileRule(match, set, profile))
+	if err := f.apply(rule); err != nil {
+		return "", err
+	}
+	if err := f.ensureForward(); err != nil {
+		return "", err
+	}
+	return set, nil
+}
+
+// ensureForward recria, quando o Docker é detectado, a chain forward com uma
+// regra por set de banimento. As regras referenciam os sets, então o
+// desbanimento vale também para o tráfego dos containers.
+func (f *NFTablesFirewall) ensureForward() error {
+	if !f.docker.detected() {
+		return nil
+	}
+
+	output, err := f.runner.CombinedOutput("nft", "list", "table", "inet", nftTable)
+	if err != nil {
+		return fmt.Errorf("erro ao listar a tabela %s do nftables: %w", nftTable, err)
+	}
+
+	// Prioridade antes da filter do Docker (0), que aceita o tráfego das
+	// portas publicadas
+	script := fmt.Sprintf(`table inet %[1]s {
+	chain %[2]s {
+		type filter hook forward priority -10; policy accept;
+	}
+}
+flush chain inet %[1]s %[2]s
+`, nftTable, nftForwardChain)
+	for _, set := range nftSets(string(output)) {
+		profile, ok := nftSetProfile(set)
+		if !ok {
+			continue
+		}
+		match := "ip"
+		if set.name == nftSetV6 || strings.HasPrefix(set.name, "b6_") {
+			match = "ip6"
+		}
+		script += fmt.Sprintf("add rule inet %s %s %s\n", nftTable, nftForwardChain, nftForwardRule(match, set.name, profile))
+	}
+	return f.apply(script)
+}
+
+// syncDocker recria a chain forward a partir dos sets
+func (f *NFTablesFirewall) syncDocker() error {
+	if err := f.ensureForward(); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// listTable retorna a listagem da tabela do Guardian. Sem a tabela, ainda não
+// criada, não há banimentos e a listagem é vazia; as demais falhas do nft
+// (binário ausente, permissão, erro do kernel) são retornadas, para que não
+// sejam confundidas com a ausência de banimentos.
+func (f *NFTablesFirewall) listTable() (string, error) {
+	output, err := f.runner.CombinedOutput("nft", "list", "table", "inet", nftTable)
+	if err != nil {
+		if strings.Contains(string(output), "No such file or directory") {
+			return "", nil
+		}
+		return "", fmt.Errorf("erro ao listar a tabela %s do nftables: %w (%s)", nftTable, err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// existingSets retorna os nomes dos sets da tabela do Guardian
+func (f *NFTablesFirewall) existingSets() map[string]bool {
+	existing := make(map[string]bool)
+	output, err := f.runner.CombinedOutput("nft", "list", "table", "inet", nftTable)
+	if err != nil {
+		return existing // Tabela ainda não criada
+	}
+	for _, set := range nftSets(string(output)) {
+		existing[set.name] = true
+	}
+	return existing
+}
+
+// nftProfileRule retorna a regra que aplica o perfil aos endereços do set
+func nftProfileRule(match, set string, profile BanProfile) string {
+	rule := fmt.Sprintf("%s saddr @%s", match, set)
+	if len(profile.Protocols) > 0 {
+		rule += " meta l4proto { " + strings.Join(profile.Protocols, ", ") + " }"
+	}
+	if !profile.AllPorts() {
+		rule += " th dport { " + strings.Replace(joinPorts(profile.Ports), ",", ", ", -1) + " }"
+	}
+	return rule + " " + profile.Action
+}
+
+// nftForwardRule retorna a regra que aplica o perfil ao tráfego encaminhado
+// dos endereços do set. As portas do perfil são as publicadas no host,
+// comparadas com o destino original da conexão (antes do DNAT).
+func nftForwardRule(match, set string, profile BanProfile) string {
+	rule := fmt.Sprintf("%s saddr @%s", match, set)
+	if len(profile.Protocols) > 0 {
+		rule += " meta l4proto { " + strings.Join(profile.Protocols, ", ") + " }"
+	}
+	if !profile.AllPorts() {
+		rule += " ct original proto-dst { " + strings.Replace(joinPorts(profile.Ports), ",", ", ", -1) + " }"
+	}
+	return rule + " " + profile.Action
+}
+
+// nftSet descreve um set da tabela do Guardian
+type nftSet struct {
+	name     string
+	comment  string
+	elements []string
+	counters []Counters // Apenas nos sets declarados com counter
+}
+
+// nftSets extrai os sets de uma listagem do "nft list table"
+func nftSets(listing string) []nftSet {
+	var sets []nftSet
+	for {
+		start := strings.Index(listing, "set ")
+		if start < 0 {
+			return sets
+		}
+		listing = listing[start+len("set "):]
+		// O bloco do set termina no primeiro "}" que fecha uma linha sozinho
+		end := strings.Index(listing, "\n\t}")
+		if end < 0 {
+			end = len(listing)
+		}
+		block := listing[:end]
+		listing = listing[end:]
+
+		fields := strings.Fields(block)
+		if len(fields) < 2 || fields[1] != "{" {
+			continue // "set" em outro contexto, como em uma regra
+		}
+		set := nftSet{name: fields[0], elements: nftSetElements(block), counters: nftSetCounters(block)}
+		if i := strings.Index(block, `comment "`); i >= 0 {
+			rest := block[i+len(`comment "`):]
+			if j := strings.Index(rest, `"`); j >= 0 {
+				set.comment = rest[:j]
+			}
+		}
+		sets = append(sets, set)
+	}
+}
+
+// nftSetProfile retorna o perfil de banimento de um set do Guardian
+func nftSetProfile(set nftSet) (BanProfile, bool) {
+	if set.name == nftSetV4 || set.name == nftSetV6 {
+		return nftLegacyProfile, true
+	}
+	if !strings.HasPrefix(set.name, "b4_") && !strings.HasPrefix(set.name, "b6_") {
+		return BanProfile{}, false
+	}
+	profile, err := config.ParseBanProfile(set.comment)
+	if err != nil {
+		return BanProfile{}, false
+	}
+	return profile, true
+}
+
+// nftSetElements extrai os elementos de uma listagem do "nft list set",
+// que podem ocupar várias linhas
+func nftSetElements(listing string) []string {
+	start := strings.Index(listing, "elements = {")
+	if start < 0 {
+		return nil
+	}
+	rest := listing[start+len("elements = {"):]
+	if end := strings.Index(rest, "}"); end >= 0 {
+		rest = rest[:end]
+	}
+
+	var elems []string
+	for _, elem := range strings.Split(rest, ",") {
+		if fields := strings.Fields(elem); len(fields) > 0 {
+			elems = append(elems, fields[0])
+		}
+	}
+	return elems
+}
+
+// nftSetCounters extrai os contadores dos elementos de uma listagem do
+// "nft list set" ("203.0.113.7 counter packets 3 bytes 180")
+func nftSetCounters(listing string) []Counters {
+	start := strings.Index(listing, "elements = {")
+	if start < 0 {
+		return nil
+	}
+	rest := listing[start+len("elements = {"):]
+	if end := strings.Index(rest, "}"); end >= 0 {
+		rest = rest[:end]
+	}
+
+	var counters []Counters
+	for _, elem := range strings.Split(rest, ",") {
+		fields := strings.Fields(elem)
+		if len(fields) == 0 {
+			continue
+		}
+		if packets, bytes, ok := parseCounterFields(fields); ok {
+			counters = append(counters, Counters{IP: fields[0], Packets: packets, Bytes: bytes})
+		}
+	}
+	return counters
+}
+
+// nftRulesFor retorna as linhas de uma listagem do nftables (regras ou
+// elementos de set) que referenciam o IP
+func nftRulesFor(ip, listing string) []string {
+	var rules []string
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ',' || r == '{' || r == '}'
+		})
+		if containsString(fields, ip) {
+			rules = append(rules, strings.TrimSpace(line))
+		}
+	}
+	return rules
+}
+
+// checkNFTOrder percorre a listagem de uma chain e confirma que a regra de
+// bloqueio do set aparece antes de qualquer accept
+func checkNFTOrder(ip, set, listing string) error {
+	for _, line := range strings.Split(listing, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.Contains(line, "@"+set) && (strings.HasSuffix(line, "drop") || strings.HasSuffix(line, "reject")) {
+			return nil
+		}
+		if strings.HasSuffix(line, "accept") {
+			return &ShadowedError{IP: ip, Rule: line}
+		}
+	}
+	return fmt.Errorf("regra de banimento do set %s não encontrada", set)
+}
+
+// nftPrecedesAccept confirma que a regra que contém target aparece antes de
+// qualquer accept na listagem da chain
+func nftPrecedesAccept(ip, listing, target string) error {
+	for _, line := range strings.Split(listing, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.Contains(line, target) {
+			return nil
+		}
+		if strings.HasSuffix(line, "accept") {
+			return &ShadowedError{IP: ip, Rule: line}
+		}
+	}
+	return fmt.Errorf("regra '%s' não encontrada", target)
+}
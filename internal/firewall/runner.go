@@ -0,0 +1,68 @@
+package firewall
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Runner executa os comandos do sistema usados pelos backends. O ExecRunner
+// executa os comandos de fato; o FakeRunner os registra para testes.
+type Runner interface {
+	// CombinedOutput executa o comando e retorna a saída padrão e de erro
+	CombinedOutput(name string, args ...string) ([]byte, error)
+	// Output executa o comando e retorna apenas a saída padrão
+	Output(name string, args ...string) ([]byte, error)
+	// RunInput executa o comando enviando input na entrada padrão e retorna
+	// a saída padrão e de erro
+	RunInput(input string, name string, args ...string) ([]byte, error)
+	// LookPath verifica se o comando está instalado
+	LookPath(name string) error
+}
+
+// ExecRunner executa os comandos com os/exec
+type ExecRunner struct{}
+
+func (ExecRunner) CombinedOutput(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+func (ExecRunner) Output(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).Output()
+}
+
+func (ExecRunner) RunInput(input string, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	return cmd.CombinedOutput()
+}
+
+func (ExecRunner) LookPath(name string) error {
+	_, err := exec.LookPath(name)
+	return err
+}
+
+// runCmd executa um comando e inclui a saída na mensagem de erro
+func runCmd(r Runner, name string, args ...string) error {
+	output, err := r.CombinedOutput(name, args...)
+	if err != nil {
+		return fmt.Errorf("'%s %s': %w (%s)", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// runWithInput executa um comando enviando data na entrada padrão e inclui
+// a saída na mensagem de erro
+func runWithInput(r Runner, data []byte, name string, args ...string) error {
+	if output, err := r.RunInput(string(data), name, args...); err != nil {
+		return fmt.Errorf("'%s %s': %w (%s)", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// succeeds executa um comando de verificação (como "iptables -C") e indica
+// se ele terminou sem erro
+func succeeds(r Runner, name string, args ...string) bool {
+	_, err := r.CombinedOutput(name, args...)
+	return err == nil
+}
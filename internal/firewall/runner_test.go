@@ -0,0 +1,524 @@
+package firewall
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mtm/guardian/internal/config"
+)
+
+// errExit simula a saída com erro de um comando
+var errExit = errors.New("exit status 1")
+
+// assertCalls confere que as linhas de comando esperadas foram executadas,
+// nesta ordem (outras chamadas podem aparecer entre elas)
+func assertCalls(t *testing.T, r *FakeRunner, expected ...string) {
+	t.Helper()
+	calls := r.Calls()
+	i := 0
+	for _, call := range calls {
+		if i < len(expected) && call == expected[i] {
+			i++
+		}
+	}
+	if i < len(expected) {
+		t.Errorf("Comando não executado (ou fora de ordem): %q\nExecutados:\n  %s", expected[i], strings.Join(calls, "\n  "))
+	}
+}
+
+// TestDetectFirewallRunner testa a detecção automática com comandos simulados
+func TestDetectFirewallRunner(t *testing.T) {
+	cfg := &config.Config{FirewallType: "auto"}
+
+	r := NewFakeRunner()
+	r.Missing("ufw")
+	r.On("systemctl is-active --quiet", "", errExit)
+	r.On("nft list table inet guardian", "Error: No such file or directory", errExit)
+	r.On("iptables -C", "iptables: No chain/target/match by that name.", errExit)
+	fw, err := NewWithRunner(cfg, r)
+	if err != nil || fw.Type() != "nftables" {
+		t.Errorf("Esperado nftables com o nft instalado e nenhum gerenciador ativo, obtido %v (%v)", fw, err)
+	}
+
+	// Sem o nft, o iptables
+	r.Missing("nft")
+	if fw, err = NewWithRunner(cfg, r); err != nil || fw.Type() != "iptables" {
+		t.Errorf("Esperado iptables, obtido %v (%v)", fw, err)
+	}
+
+	r = NewFakeRunner()
+	r.Missing("ufw", "nft", "iptables", "firewall-cmd")
+	if _, err := NewWithRunner(cfg, r); err == nil {
+		t.Error("Deveria retornar erro sem nenhum firewall instalado")
+	}
+}
+
+// TestDetectActive testa a escolha do gerenciador em uso, e não apenas do
+// instalado
+func TestDetectActive(t *testing.T) {
+	cfg := &config.Config{FirewallType: "auto"}
+
+	// UFW instalado mas inativo, firewalld em execução
+	r := NewFakeRunner()
+	r.On("ufw status", "Status: inactive\n", nil)
+	r.On("firewall-cmd --state", "running\n", nil)
+	r.On("nft list tables", "table inet firewalld\n", nil)
+	r.On("systemctl is-active --quiet", "", errExit)
+	r.On("iptables -C", "iptables: No chain/target/match by that name.", errExit)
+	d, err := Detect(cfg, r)
+	if err != nil || d.Selected != "firewalld" || d.Ambiguous {
+		t.Errorf("Esperado firewalld sem ambiguidade, obtido %+v (%v)", d, err)
+	}
+
+	// UFW e firewalld ativos: ambíguo, com aviso
+	r.On("ufw status", "Status: active\n", nil)
+	d, err = Detect(cfg, r)
+	if err != nil || d.Selected != "firewalld" || !d.Ambiguous || len(d.Warnings) == 0 {
+		t.Errorf("Esperada detecção ambígua, obtido %+v (%v)", d, err)
+	}
+
+	// Tipo configurado inativo enquanto outro está ativo
+	d, err = Detect(&config.Config{FirewallType: "iptables"}, r)
+	if err != nil || d.Selected != "iptables" || len(d.Warnings) != 2 {
+		t.Errorf("Esperado iptables com avisos, obtido %+v (%v)", d, err)
+	}
+
+	// Nenhum ativo: primeiro instalado, como antes
+	r = NewFakeRunner()
+	r.On("ufw status", "Status: inactive\n", nil)
+	r.On("firewall-cmd --state", "not running\n", errExit)
+	r.On("systemctl is-active --quiet", "", errExit)
+	r.On("iptables -C", "", errExit)
+	d, err = Detect(cfg, r)
+	if err != nil || d.Selected != "ufw" || d.Ambiguous {
+		t.Errorf("Esperado ufw, obtido %+v (%v)", d, err)
+	}
+	if lines := d.Lines(); len(lines) != 5 || !strings.Contains(lines[1], "ufw: instalado, inativo") {
+		t.Errorf("Diagnóstico inesperado: %q", lines)
+	}
+}
+
+// TestUFWCommands testa os comandos executados pelo backend UFW
+func TestUFWCommands(t *testing.T) {
+	ip := "203.0.113.7"
+	r := NewFakeRunner()
+	fw := &UFWFirewall{runner: r}
+
+	r.On("ufw status numbered", "[ 1] Anywhere                   DENY IN     203.0.113.7\n[ 2] 22/tcp                     ALLOW IN    Anywhere\n", nil)
+	profile := BanProfile{Ports: []int{22, 80}, Protocols: []string{"tcp", "udp"}, Action: config.ActionReject}
+	if err := fw.BanIP(ip, profile); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	assertCalls(t, r,
+		"ufw status",
+		"ufw prepend reject from 203.0.113.7 to any port 22,80 proto tcp",
+		"ufw prepend reject from 203.0.113.7 to any port 22,80 proto udp",
+		"ufw status numbered",
+	)
+
+	// As regras usam o alvo normalizado
+	r.On("ufw status numbered", "[ 1] Anywhere                   DENY IN     198.51.100.5\n"+
+		"[ 2] Anywhere                   DENY IN     10.0.0.0/24\n"+
+		"[ 3] Anywhere                   DENY IN     198.51.100.6\n", nil)
+	for target, expected := range map[string]string{
+		"::ffff:198.51.100.5": "ufw prepend deny from 198.51.100.5 to any",
+		"10.0.0.5/24":         "ufw prepend deny from 10.0.0.0/24 to any",
+		"198.51.100.6/32":     "ufw prepend deny from 198.51.100.6 to any",
+	} {
+		r.Reset()
+		if err := fw.BanIP(target, BanProfile{Action: config.ActionDrop}); err != nil {
+			t.Fatalf("%s: erro inesperado: %v", target, err)
+		}
+		assertCalls(t, r, expected)
+	}
+
+	// A saída do comando que falhou aparece no erro
+	r.On("ufw prepend", "ERROR: Invalid syntax", errExit)
+	if err := fw.BanIP("198.51.100.1", config.DefaultBanProfile()); err == nil || !strings.Contains(err.Error(), "Invalid syntax") {
+		t.Errorf("Esperado erro com a saída do UFW, obtido %v", err)
+	}
+
+	// O desbanimento remove as regras listadas e reporta as que restaram
+	r = NewFakeRunner()
+	fw = &UFWFirewall{runner: r}
+	r.On("ufw status", "Status: active\n\nTo                         Action      From\n--                         ------      ----\n"+
+		"22/tcp                     DENY        203.0.113.7\n", nil)
+	err := fw.UnbanIP(ip)
+	assertCalls(t, r, "ufw status", "ufw delete deny from 203.0.113.7 to any port 22 proto tcp", "ufw status")
+	if _, ok := err.(*RemainingRulesError); !ok {
+		t.Errorf("Esperado RemainingRulesError, obtido %v", err)
+	}
+}
+
+// TestUFWFiles testa a leitura e a gravação dos arquivos do UFW a partir da
+// raiz configurada
+func TestUFWFiles(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(name string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	write("/etc/default/ufw", "IPV6=no\n")
+	write("/etc/ufw/user.rules", "*filter\nCOMMIT\n")
+
+	r := NewFakeRunner()
+	fw := &UFWFirewall{runner: r, root: root}
+
+	// Com IPv6 desabilitado, o banimento IPv6 é recusado sem regras
+	if err := fw.BanIP("2001:db8::1", config.DefaultBanProfile()); err == nil || !strings.Contains(err.Error(), "IPV6=yes") {
+		t.Errorf("Esperado erro de IPv6 desabilitado, obtido %v", err)
+	}
+	for _, call := range r.Calls() {
+		if strings.HasPrefix(call, "ufw prepend") {
+			t.Errorf("Nenhuma regra deveria ter sido criada: %s", call)
+		}
+	}
+
+	// A cópia inclui apenas os arquivos existentes e é regravada no Restore
+	r.On("ufw status", "Status: active\n", nil)
+	snapshot, err := fw.Snapshot()
+	if err != nil {
+		t.Fatalf("Erro ao salvar cópia: %v", err)
+	}
+	if len(snapshot.Data) != 2 || string(snapshot.Data["/etc/default/ufw"]) != "IPV6=no\n" {
+		t.Fatalf("Cópia inesperada: %v", snapshot.Data)
+	}
+	write("/etc/default/ufw", "IPV6=yes\n")
+	write("/etc/ufw/user.rules", "*filter\n-A ufw-user-input -j ACCEPT\nCOMMIT\n")
+	r.On("ufw status numbered", "[ 1] Anywhere (v6)              DENY IN     2001:db8::1\n", nil)
+	if err := fw.BanIP("2001:db8::1", config.DefaultBanProfile()); err != nil {
+		t.Errorf("Erro inesperado com IPv6 habilitado: %v", err)
+	}
+
+	r.Reset()
+	if err := fw.Restore(snapshot); err != nil {
+		t.Fatalf("Erro ao restaurar cópia: %v", err)
+	}
+	if read("/etc/default/ufw") != "IPV6=no\n" || read("/etc/ufw/user.rules") != "*filter\nCOMMIT\n" {
+		t.Error("Arquivos não foram restaurados")
+	}
+	assertCalls(t, r, "ufw reload")
+}
+
+// TestFirewalldCommands testa os comandos executados pelo backend firewalld
+func TestFirewalldCommands(t *testing.T) {
+	ip := "203.0.113.7"
+	r := NewFakeRunner()
+	fw := &FirewalldFirewall{runner: r}
+
+	r.On("firewall-cmd --get-zone-of-source="+ip, "no zone", errExit)
+	r.On("firewall-cmd --list-rich-rules", `rule family="ipv4" source address="203.0.113.7" port port="22" protocol="tcp" drop`+"\n", nil)
+	profile := BanProfile{Ports: []int{22}, Protocols: []string{"tcp"}, Action: config.ActionDrop}
+	if err := fw.BanIP(ip, profile); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	assertCalls(t, r,
+		"firewall-cmd --permanent --list-rich-rules",
+		`firewall-cmd --permanent --add-rich-rule=rule family="ipv4" source address="203.0.113.7" port port="22" protocol="tcp" drop`,
+		"firewall-cmd --reload",
+		"firewall-cmd --get-zone-of-source="+ip,
+		"firewall-cmd --list-rich-rules",
+	)
+
+	// IP vinculado a outra zona: o banimento seria ignorado
+	r.On("firewall-cmd --get-zone-of-source="+ip, "trusted", nil)
+	if _, ok := fw.CheckBanOrder(ip).(*ShadowedError); !ok {
+		t.Error("Esperado ShadowedError para IP vinculado a uma zona")
+	}
+
+	// Falha ao listar as regras interrompe o banimento antes de qualquer alteração
+	r = NewFakeRunner()
+	fw = &FirewalldFirewall{runner: r}
+	r.On("firewall-cmd --permanent --list-rich-rules", "FirewallD is not running", errExit)
+	if err := fw.BanIP(ip, profile); err == nil {
+		t.Fatal("Esperado erro com o firewalld parado")
+	}
+	for _, call := range r.Calls() {
+		if strings.Contains(call, "--add-rich-rule") {
+			t.Errorf("Nenhuma regra deveria ter sido adicionada: %s", call)
+		}
+	}
+}
+
+// TestIPTablesCommands testa os comandos executados pelo backend iptables
+func TestIPTablesCommands(t *testing.T) {
+	ip := "203.0.113.7"
+	r := NewFakeRunner()
+	fw := &IPTablesFirewall{runner: r}
+
+	r.On("ipset list -n", "guardian-ip4\nguardian-net4\nguardian-v4\n", nil)
+	r.On("ipset list guardian-ip4", "Name: guardian-ip4\nType: hash:ip\nMembers:\n203.0.113.7\n", nil)
+	r.On("iptables -S INPUT", "-P INPUT ACCEPT\n-A INPUT -j GUARDIAN\n-A INPUT -p tcp --dport 22 -j ACCEPT\n", nil)
+	r.On("iptables -S GUARDIAN", "-N GUARDIAN\n-A GUARDIAN -m set --match-set guardian-v4 src -p tcp -m multiport --dports 22,80,443,4554 -j DROP\n", nil)
+	r.On("iptables -C GUARDIAN", "iptables: Bad rule", errExit)
+
+	if err := fw.BanIP(ip, config.DefaultBanProfile()); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	assertCalls(t, r,
+		"ipset create guardian-ip4 hash:ip family inet -exist",
+		"ipset create guardian-v4 list:set -exist",
+		"iptables -I GUARDIAN 1 -m set --match-set guardian-v4 src -p tcp -m multiport --dports 22,80,443,4554 -j DROP",
+		"ipset add guardian-ip4 203.0.113.7 -exist",
+		"sh -c ipset save > /etc/iptables/ipsets",
+		"ipset test guardian-ip4 203.0.113.7",
+	)
+
+	// A saída do ipset aparece no erro
+	r.On("ipset add", "ipset v7.15: Kernel error received: set type not supported", errExit)
+	if err := fw.BanIP("198.51.100.1", config.DefaultBanProfile()); err == nil || !strings.Contains(err.Error(), "set type not supported") {
+		t.Errorf("Esperado erro com a saída do ipset, obtido %v", err)
+	}
+
+	// Um ACCEPT antes do salto para a GUARDIAN anula o banimento
+	r.On("iptables -S INPUT", "-P INPUT ACCEPT\n-A INPUT -p tcp --dport 22 -j ACCEPT\n-A INPUT -j GUARDIAN\n", nil)
+	if _, ok := fw.CheckBanOrder(ip).(*ShadowedError); !ok {
+		t.Error("Esperado ShadowedError com ACCEPT antes do salto")
+	}
+}
+
+// TestNFTablesCommands testa os comandos executados pelo backend nftables
+func TestNFTablesCommands(t *testing.T) {
+	ip := "203.0.113.7"
+	r := NewFakeRunner()
+	fw := &NFTablesFirewall{runner: r}
+
+	r.On("nft list table inet guardian", "table inet guardian {\n\tset banned4 {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t\telements = { 203.0.113.7 }\n\t}\n}\n", nil)
+	r.On("nft list chain inet guardian input", "table inet guardian {\n\tchain input {\n\t\tjump bans\n\t\tip saddr @banned4 drop\n\t\tct state established,related accept\n\t}\n}\n", nil)
+
+	if err := fw.BanIP(ip, BanProfile{Action: config.ActionDrop}); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	assertCalls(t, r,
+		"nft list table inet guardian",
+		"nft add element inet guardian banned4 { 203.0.113.7 }",
+		"nft get element inet guardian banned4 { 203.0.113.7 }",
+		"nft list chain inet guardian input",
+	)
+
+	// Outros perfis ganham um set comentado e uma regra na chain bans
+	r.Reset()
+	profile := BanProfile{Ports: []int{22}, Protocols: []string{"tcp"}, Action: config.ActionReject}
+	set, err := fw.ensureProfileSet(ip, profile)
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	if set != "b4_"+profileID(profile) {
+		t.Errorf("Set inesperado: %s", set)
+	}
+	inputs := r.Inputs()
+	if len(inputs) != 2 || !strings.Contains(inputs[0], `comment "reject tcp 22"`) ||
+		inputs[1] != "add rule inet guardian bans ip saddr @"+set+" meta l4proto { tcp } th dport { 22 } reject\n" {
+		t.Errorf("Scripts inesperados: %q", inputs)
+	}
+
+	// Tarpit é recusado sem executar comandos
+	r.Reset()
+	if _, ok := fw.BanIP(ip, BanProfile{Protocols: []string{"tcp"}, Action: config.ActionTarpit}).(*UnsupportedActionError); !ok {
+		t.Error("Esperado UnsupportedActionError para tarpit")
+	}
+	if calls := r.Calls(); len(calls) != 0 {
+		t.Errorf("Nenhum comando deveria ter sido executado: %v", calls)
+	}
+
+	// O Enable recarrega a tabela salva, cria só o set que falta e a chain
+	// input aceita o tráfego por padrão, apenas descartando os banidos
+	r.Reset()
+	if err := fw.Enable(); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	assertCalls(t, r,
+		"sh -c nft list table inet guardian >/dev/null 2>&1 || [ ! -f /etc/nftables.d/guardian.nft ] || nft -f /etc/nftables.d/guardian.nft",
+		"nft list table inet guardian",
+		"nft -f -",
+	)
+	inputs = r.Inputs()
+	if len(inputs) != 1 {
+		t.Fatalf("Esperado um único script, obtidos %q", inputs)
+	}
+	script := inputs[0]
+	for _, expected := range []string{
+		"set banned6 {",
+		"policy accept;",
+		"flush chain inet guardian input",
+		"\t\tjump bans\n\t\tip saddr @banned4 drop\n\t\tip6 saddr @banned6 drop\n\t}",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("Script do Enable sem %q:\n%s", expected, script)
+		}
+	}
+	for _, unexpected := range []string{"set banned4 {", "policy drop", "accept\n"} {
+		if strings.Contains(script, unexpected) {
+			t.Errorf("Script do Enable com %q:\n%s", unexpected, script)
+		}
+	}
+
+	// Sem a tabela não há banimentos; as demais falhas do nft são erros
+	r = NewFakeRunner()
+	fw = &NFTablesFirewall{runner: r}
+	r.On("nft list table inet guardian", "Error: No such file or directory\nlist table inet guardian\n", errExit)
+	if bans, err := fw.ListBanned(); err != nil || len(bans) != 0 {
+		t.Errorf("Esperada lista vazia sem a tabela, obtido %v, %v", bans, err)
+	}
+	r.On("nft list table inet guardian", "Error: Operation not permitted", errExit)
+	if _, err := fw.ListBanned(); err == nil || !strings.Contains(err.Error(), "Operation not permitted") {
+		t.Errorf("Esperado erro com a saída do nft, obtido %v", err)
+	}
+	if _, err := fw.Counters(); err == nil {
+		t.Error("Esperado erro nos contadores")
+	}
+}
+
+// TestCounters testa a leitura dos contadores por banimento em cada backend
+func TestCounters(t *testing.T) {
+	// iptables: elementos dos ipsets com contadores e regras antigas na INPUT
+	r := NewFakeRunner()
+	r.On("ipset list -n", "guardian-ip4\nguardian-net4\nguardian-v4\n", nil)
+	r.On("ipset list guardian-ip4", "Name: guardian-ip4\nType: hash:ip\nHeader: family inet hashsize 1024 maxelem 65536 counters\nMembers:\n203.0.113.7 packets 12 bytes 720\n", nil)
+	r.On("ipset list guardian-net4", "Name: guardian-net4\nType: hash:net\nMembers:\n198.51.100.0/24\n", nil)
+	r.On("iptables -S INPUT -v", "-P INPUT ACCEPT -c 0 0\n-A INPUT -s 203.0.113.9/32 -p tcp -m tcp --dport 22 -c 3 180 -j DROP\n-A INPUT -s 198.18.0.1/32 -c 9 540 -j ACCEPT\n", nil)
+	r.On("ip6tables -S INPUT -v", "-P INPUT ACCEPT -c 0 0\n", nil)
+	counters, err := (&IPTablesFirewall{runner: r}).Counters()
+	expected := []Counters{{IP: "203.0.113.7", Packets: 12, Bytes: 720}, {IP: "203.0.113.9", Packets: 3, Bytes: 180}}
+	if err != nil || len(counters) != len(expected) || counters[0] != expected[0] || counters[1] != expected[1] {
+		t.Errorf("Contadores do iptables inesperados: %+v (%v)", counters, err)
+	}
+
+	// Sets novos são criados com contadores; os existentes, como estão
+	r.Reset()
+	g := ipsetGroupFor(ipsetV4, BanProfile{Ports: []int{22}, Protocols: []string{"tcp"}, Action: config.ActionReject})
+	if err := (&IPTablesFirewall{runner: r}).ensureSets(g); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	assertCalls(t, r, "ipset create "+g.hosts+" hash:ip family inet comment counters -exist")
+
+	// nftables: contadores dos elementos dos sets declarados com counter
+	r = NewFakeRunner()
+	r.On("nft list table inet guardian", "table inet guardian {\n\tset banned4 {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t\tcounter\n"+
+		"\t\telements = { 203.0.113.7 counter packets 5 bytes 300, 198.51.100.0/24 counter packets 0 bytes 0 }\n\t}\n}\n", nil)
+	counters, err = (&NFTablesFirewall{runner: r}).Counters()
+	if err != nil || len(counters) != 2 || counters[0].IP != "198.51.100.0/24" || counters[1].Packets != 5 {
+		t.Errorf("Contadores do nftables inesperados: %+v (%v)", counters, err)
+	}
+
+	// UFW: regras de bloqueio por origem nas chains do usuário
+	r = NewFakeRunner()
+	r.On("iptables -S ufw-user-input -v", "-N ufw-user-input\n-A ufw-user-input -s 203.0.113.7/32 -p tcp -m multiport --dports 22,80 -c 4 240 -j DROP\n"+
+		"-A ufw-user-input -s 203.0.113.7/32 -p udp -m multiport --dports 22,80 -c 1 60 -j REJECT --reject-with icmp-port-unreachable\n", nil)
+	r.On("ip6tables -S ufw6-user-input -v", "", errExit)
+	counters, err = (&UFWFirewall{runner: r}).Counters()
+	if err != nil || len(counters) != 1 || counters[0] != (Counters{IP: "203.0.113.7", Packets: 5, Bytes: 300}) {
+		t.Errorf("Contadores do UFW inesperados: %+v (%v)", counters, err)
+	}
+
+	// firewalld: só com as chains do backend iptables
+	r = NewFakeRunner()
+	r.On("iptables -S -v", "-P INPUT ACCEPT -c 0 0\n", nil)
+	if _, err := (&FirewalldFirewall{runner: r}).Counters(); err == nil {
+		t.Error("Esperado UnsupportedCountersError sem as chains do firewalld")
+	} else if _, ok := err.(*UnsupportedCountersError); !ok {
+		t.Errorf("Esperado UnsupportedCountersError, obtido %v", err)
+	}
+	r.On("iptables -S -v", "-N IN_public_deny\n-A IN_public_deny -s 203.0.113.7/32 -p tcp -m tcp --dport 22 -m conntrack --ctstate NEW,UNTRACKED -c 7 420 -j DROP\n", nil)
+	counters, err = (&FirewalldFirewall{runner: r}).Counters()
+	if err != nil || len(counters) != 1 || counters[0].Packets != 7 {
+		t.Errorf("Contadores do firewalld inesperados: %+v (%v)", counters, err)
+	}
+}
+
+// TestDockerBans testa a aplicação dos banimentos na DOCKER-USER e a remoção
+// simétrica no desbanimento
+func TestDockerBans(t *testing.T) {
+	ip := "203.0.113.7"
+	profile := BanProfile{Ports: []int{80, 443}, Protocols: []string{"tcp"}, Action: config.ActionReject}
+
+	// UFW: regras por IP, com as portas publicadas como destino original
+	r := NewFakeRunner()
+	fw := &UFWFirewall{runner: r, docker: dockerBans{runner: r, mode: config.DockerAuto}}
+	r.On("ufw status numbered", "[ 1] 80,443/tcp                 REJECT IN   203.0.113.7\n", nil)
+	r.On("iptables -C DOCKER-USER", "iptables: Bad rule", errExit)
+	if err := fw.BanIP(ip, profile); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	assertCalls(t, r,
+		"ufw prepend reject from 203.0.113.7 to any port 80,443 proto tcp",
+		"iptables -n -L DOCKER-USER",
+		"iptables -I DOCKER-USER 1 -s 203.0.113.7 -p tcp -m conntrack --ctorigdstport 80 -m comment --comment guardian -j REJECT",
+		"iptables -I DOCKER-USER 1 -s 203.0.113.7 -p tcp -m conntrack --ctorigdstport 443 -m comment --comment guardian -j REJECT",
+	)
+
+	// O desbanimento remove apenas as regras do Guardian para o IP
+	r.Reset()
+	r.On("ufw status", "Status: active\n", nil)
+	r.On("iptables -S DOCKER-USER", "-N DOCKER-USER\n"+
+		"-A DOCKER-USER -s 203.0.113.7/32 -p tcp -m conntrack --ctorigdstport 80 -m comment --comment guardian -j REJECT --reject-with icmp-port-unreachable\n"+
+		"-A DOCKER-USER -s 203.0.113.7/32 -p tcp -m tcp --dport 8080 -j ACCEPT\n"+
+		"-A DOCKER-USER -j RETURN\n", nil)
+	if err := fw.UnbanIP(ip); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	assertCalls(t, r, "iptables -D DOCKER-USER -s 203.0.113.7/32 -p tcp -m conntrack --ctorigdstport 80 -m comment --comment guardian -j REJECT --reject-with icmp-port-unreachable")
+	for _, call := range r.Calls() {
+		if strings.Contains(call, "-D DOCKER-USER") && !strings.Contains(call, "--comment guardian") {
+			t.Errorf("Regra do administrador removida: %s", call)
+		}
+	}
+
+	// Sem Docker no modo auto, ou com o modo off, a DOCKER-USER não é tocada
+	for _, mode := range []string{config.DockerAuto, config.DockerOff} {
+		r = NewFakeRunner()
+		r.On("iptables -n -L DOCKER-USER", "iptables: No chain/target/match by that name.", errExit)
+		r.On("ufw status numbered", "[ 1] 80,443/tcp                 REJECT IN   203.0.113.7\n", nil)
+		fw = &UFWFirewall{runner: r, docker: dockerBans{runner: r, mode: mode}}
+		if err := fw.BanIP(ip, profile); err != nil {
+			t.Fatalf("Erro inesperado: %v", err)
+		}
+		for _, call := range r.Calls() {
+			if strings.Contains(call, "-I DOCKER-USER") || strings.Contains(call, "-N DOCKER-USER") {
+				t.Errorf("Modo %s: DOCKER-USER não deveria ser alterada: %s", mode, call)
+			}
+		}
+	}
+
+	// iptables: uma regra por grupo de sets, que acompanha os elementos do set
+	r = NewFakeRunner()
+	r.On("iptables -C", "iptables: Bad rule", errExit)
+	ipt := &IPTablesFirewall{runner: r, docker: dockerBans{runner: r, mode: config.DockerOn}}
+	g := ipsetGroupFor(ipsetV4, profile)
+	if err := ipt.ensureBanRules(g); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	assertCalls(t, r, "iptables -I DOCKER-USER 1 -m set --match-set "+g.list+" src -p tcp -m conntrack --ctorigdstport 80 -m comment --comment guardian -j REJECT")
+
+	// nftables: chain forward com uma regra por set
+	r = NewFakeRunner()
+	r.On("nft list table inet guardian", "table inet guardian {\n\tset banned4 {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t}\n"+
+		"\tset b4_"+profileID(profile)+" {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t\tcomment \"reject tcp 80,443\"\n\t}\n}\n", nil)
+	nft := &NFTablesFirewall{runner: r, docker: dockerBans{runner: r, mode: config.DockerAuto}}
+	if err := nft.ensureForward(); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	inputs := r.Inputs()
+	if len(inputs) != 1 || !strings.Contains(inputs[0], "hook forward priority -10") ||
+		!strings.Contains(inputs[0], "add rule inet guardian forward ip saddr @banned4 drop\n") ||
+		!strings.Contains(inputs[0], "meta l4proto { tcp } ct original proto-dst { 80, 443 } reject\n") {
+		t.Errorf("Script inesperado: %q", inputs)
+	}
+}
@@ -0,0 +1,36 @@
+package firewall
+
+import (
+	"fmt"
+	"time"
+)
+
+// Snapshot é uma cópia do estado do firewall tirada antes de uma alteração
+// arriscada (como o Enable), usada para desfazê-la com Restore
+type Snapshot struct {
+	Firewall string
+	Taken    time.Time
+	Enabled  bool // Resultado do IsEnabled no momento da cópia
+	// Data guarda o conteúdo salvo pelo backend, como a saída do
+	// iptables-save ou do "nft list ruleset", por nome
+	Data map[string][]byte
+}
+
+// newSnapshot cria uma cópia vazia com o estado atual do firewall
+func newSnapshot(f Firewall) *Snapshot {
+	enabled, err := f.IsEnabled()
+	return &Snapshot{
+		Firewall: f.Type(),
+		Taken:    time.Now().UTC(),
+		Enabled:  err == nil && enabled,
+		Data:     make(map[string][]byte),
+	}
+}
+
+// checkSnapshot confere se a cópia foi tirada pelo mesmo tipo de firewall
+func checkSnapshot(f Firewall, s *Snapshot) error {
+	if s == nil || s.Firewall != f.Type() {
+		return fmt.Errorf("cópia do firewall inválida para o %s", f.Type())
+	}
+	return nil
+}
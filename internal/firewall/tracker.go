@@ -0,0 +1,62 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// banTracker registra as regras que cada backend criou para cada banimento,
+// para que o UnbanIP remova exatamente o que o BanIP adicionou. O valor zero
+// está pronto para uso.
+type banTracker struct {
+	mu    sync.Mutex
+	rules map[string][]string
+}
+
+// record registra as regras criadas para o IP
+func (t *banTracker) record(ip string, rules []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.rules == nil {
+		t.rules = make(map[string][]string)
+	}
+	for _, rule := range rules {
+		if !containsString(t.rules[ip], rule) {
+			t.rules[ip] = append(t.rules[ip], rule)
+		}
+	}
+}
+
+// lookup retorna as regras registradas para o IP. Quando o banimento foi
+// feito por outra execução do Guardian, retorna as regras de fallback, que
+// são geradas da mesma forma que no BanIP.
+func (t *banTracker) lookup(ip string, fallback []string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if rules, ok := t.rules[ip]; ok {
+		return rules
+	}
+	return fallback
+}
+
+// forget descarta as regras registradas para o IP após o desbanimento
+func (t *banTracker) forget(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.rules, ip)
+}
+
+// RemainingRulesError indica que ainda há regras referentes ao IP depois
+// do UnbanIP
+type RemainingRulesError struct {
+	IP    string
+	Rules []string
+}
+
+func (e *RemainingRulesError) Error() string {
+	return fmt.Sprintf("IP %s ainda possui regras no firewall após o desbanimento: %s", e.IP, strings.Join(e.Rules, "; "))
+}
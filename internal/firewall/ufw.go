@@ -2,17 +2,39 @@ package firewall
 
 import (
 	"fmt"
-	"os/exec"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+
+	"github.com/mtm/guardian/internal/config"
 )
 
+// ufwColumns separa as colunas da saída do "ufw status"
+var ufwColumns = regexp.MustCompile(`\s{2,}`)
+
+// ufwDefaultsFile é onde o UFW define se as regras IPv6 são aplicadas
+const ufwDefaultsFile = "/etc/default/ufw"
+
+// ufwSnapshotFiles são os arquivos alterados pelo Enable do UFW
+var ufwSnapshotFiles = []string{ufwDefaultsFile, "/etc/ufw/user.rules", "/etc/ufw/user6.rules"}
+
 // UFWFirewall implementa a interface Firewall para o UFW
-type UFWFirewall struct{}
+type UFWFirewall struct {
+	runner Runner
+	bans   banTracker
+	docker dockerBans
+	// root é o diretório a partir do qual os arquivos do UFW são lidos e
+	// gravados (vazio usa a raiz do sistema), para que os testes não toquem
+	// nos arquivos do host
+	root string
+}
 
 // IsEnabled verifica se o UFW está habilitado
 func (f *UFWFirewall) IsEnabled() (bool, error) {
-	cmd := exec.Command("ufw", "status")
-	output, err := cmd.CombinedOutput()
+	output, err := f.runner.CombinedOutput("ufw", "status")
 	if err != nil {
 		return false, fmt.Errorf("erro ao verificar status do UFW: %w", err)
 	}
@@ -35,7 +57,7 @@ func (f *UFWFirewall) Enable() error {
 	}
 
 	for _, cmd := range cmds {
-		if err := exec.Command(cmd.name, cmd.args...).Run(); err != nil {
+		if _, err := f.runner.CombinedOutput(cmd.name, cmd.args...); err != nil {
 			return fmt.Errorf("erro ao executar '%s %s': %w", cmd.name, strings.Join(cmd.args, " "), err)
 		}
 	}
@@ -43,34 +65,363 @@ func (f *UFWFirewall) Enable() error {
 	return nil
 }
 
-// Disable desativa o UFW
+// Disable desativa o UFW e remove as regras do Guardian da DOCKER-USER
 func (f *UFWFirewall) Disable() error {
-	cmd := exec.Command("ufw", "--force", "disable")
-	if err := cmd.Run(); err != nil {
+	if _, err := f.runner.CombinedOutput("ufw", "--force", "disable"); err != nil {
 		return fmt.Errorf("erro ao desativar UFW: %w", err)
 	}
-	return nil
+	return f.docker.removeAll()
 }
 
-// BanIP bane um endereço IP usando o UFW
-func (f *UFWFirewall) BanIP(ip string) error {
-	cmd := exec.Command("ufw", "deny", "from", ip, "to", "any")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("erro ao banir IP %s: %w", ip, err)
+// Snapshot salva as regras do usuário e as políticas padrão do UFW
+func (f *UFWFirewall) Snapshot() (*Snapshot, error) {
+	s := newSnapshot(f)
+	for _, path := range ufwSnapshotFiles {
+		data, err := ioutil.ReadFile(f.path(path))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("erro ao salvar %s: %w", path, err)
+		}
+		s.Data[path] = data
 	}
-	return nil
+	return s, nil
+}
+
+// Restore regrava os arquivos salvos por Snapshot e recarrega o UFW, ou o
+// desativa se ele estava inativo na cópia
+func (f *UFWFirewall) Restore(s *Snapshot) error {
+	if err := checkSnapshot(f, s); err != nil {
+		return err
+	}
+	for path, data := range s.Data {
+		if err := ioutil.WriteFile(f.path(path), data, 0640); err != nil {
+			return fmt.Errorf("erro ao restaurar %s: %w", path, err)
+		}
+	}
+
+	if !s.Enabled {
+		return runCmd(f.runner, "ufw", "--force", "disable")
+	}
+	return runCmd(f.runner, "ufw", "reload")
+}
+
+// BanIP bane um endereço IP usando o UFW. As regras são inseridas no topo
+// (prepend) para serem avaliadas antes das liberações, como a do SSH. O
+// tráfego encaminhado aos containers do Docker não passa pelas regras do UFW;
+// para ele, as regras vão para a DOCKER-USER.
+func (f *UFWFirewall) BanIP(ip string, profile BanProfile) error {
+	if profile.Action != config.ActionDrop && profile.Action != config.ActionReject {
+		return &UnsupportedActionError{Firewall: "ufw", Action: profile.Action}
+	}
+
+	ip, err := prepareBan(f, ip, profile)
+	if err != nil {
+		return err
+	}
+
+	if err := f.checkFamily(ip); err != nil {
+		return err
+	}
+
+	rules, err := ufwBanRules(ip, profile)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if err := runCmd(f.runner, "ufw", append([]string{"prepend"}, strings.Fields(rule)...)...); err != nil {
+			return fmt.Errorf("erro ao banir IP %s: %w", ip, err)
+		}
+		f.bans.record(ip, []string{rule})
+	}
+	if err := f.docker.banIP(ip, profile); err != nil {
+		return fmt.Errorf("erro ao banir IP %s no Docker: %w", ip, err)
+	}
+
+	return f.CheckBanOrder(ip)
 }
 
-// UnbanIP remove o banimento de um endereço IP usando o UFW
+// UnbanIP remove as regras criadas pelo BanIP e confirma que nenhuma regra
+// referente ao IP permaneceu no UFW
 func (f *UFWFirewall) UnbanIP(ip string) error {
-	cmd := exec.Command("ufw", "delete", "deny", "from", ip, "to", "any")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("erro ao desbanir IP %s: %w", ip, err)
+	ip, err := prepareUnban(f, ip)
+	if err != nil {
+		return err
+	}
+
+	// Sem registro desta execução, as regras são obtidas da listagem
+	var fallback []string
+	bans, err := f.ListBanned()
+	if err != nil {
+		return err
+	}
+	if ban := FindBan(bans, ip); ban != nil {
+		fallback = ban.Rules
+	}
+
+	for _, rule := range f.bans.lookup(ip, fallback) {
+		if err := runCmd(f.runner, "ufw", append([]string{"delete"}, strings.Fields(rule)...)...); err != nil {
+			return fmt.Errorf("erro ao desbanir IP %s: %w", ip, err)
+		}
 	}
+	f.bans.forget(ip)
+	if err := f.docker.unbanIP(ip); err != nil {
+		return fmt.Errorf("erro ao desbanir IP %s no Docker: %w", ip, err)
+	}
+
+	output, err := f.runner.CombinedOutput("ufw", "status")
+	if err != nil {
+		return fmt.Errorf("erro ao listar regras do UFW: %w", err)
+	}
+	if remaining := ufwRulesFor(ip, string(output)); len(remaining) > 0 {
+		return &RemainingRulesError{IP: ip, Rules: remaining}
+	}
+
 	return nil
 }
 
+// Batch aplica o lote item a item: o UFW não tem como inserir ou remover
+// várias regras de uma vez
+func (f *UFWFirewall) Batch(ops []BatchOp) []error {
+	return batchEach(f, ops)
+}
+
+// ListBanned lista as regras de bloqueio (deny/reject) por origem do UFW.
+// Os identificadores das regras são especificações aceitas por "ufw delete".
+func (f *UFWFirewall) ListBanned() ([]Ban, error) {
+	output, err := f.runner.CombinedOutput("ufw", "status")
+	if err != nil {
+		return nil, fmt.Errorf("erro ao listar regras do UFW: %w", err)
+	}
+	return parseUFWBans(string(output)), nil
+}
+
+// IsBanned verifica se o IP está banido no UFW
+func (f *UFWFirewall) IsBanned(ip string) (bool, error) {
+	bans, err := f.ListBanned()
+	if err != nil {
+		return false, err
+	}
+	return BannedIn(bans, ip)
+}
+
+// Counters soma os contadores das regras de bloqueio por origem que o UFW
+// cria nas chains ufw-user-input e ufw6-user-input
+func (f *UFWFirewall) Counters() ([]Counters, error) {
+	var counters []Counters
+	for _, c := range []struct{ cmd, chain string }{{"iptables", "ufw-user-input"}, {"ip6tables", "ufw6-user-input"}} {
+		output, err := f.runner.CombinedOutput(c.cmd, "-S", c.chain, "-v")
+		if err != nil {
+			if c.cmd == "ip6tables" {
+				continue // IPv6 desabilitado no UFW
+			}
+			return nil, fmt.Errorf("erro ao ler contadores do UFW: %w (%s)", err, strings.TrimSpace(string(output)))
+		}
+		chain := c.chain
+		counters = parseRuleCounters(counters, string(output), func(name string) bool { return name == chain })
+	}
+	return sortCounters(counters), nil
+}
+
+// CheckBanOrder confere, na lista numerada do UFW, que a regra de bloqueio do
+// IP aparece antes de qualquer ALLOW que também se aplicaria a ele
+func (f *UFWFirewall) CheckBanOrder(ip string) error {
+	family, err := ipFamily(ip)
+	if err != nil {
+		return err
+	}
+
+	output, err := f.runner.CombinedOutput("ufw", "status", "numbered")
+	if err != nil {
+		return fmt.Errorf("erro ao listar regras do UFW: %w", err)
+	}
+
+	return checkUFWOrder(ip, family, string(output))
+}
+
 // Type retorna o tipo do firewall
 func (f *UFWFirewall) Type() string {
 	return "ufw"
 }
+
+// syncDocker reaplica os banimentos do UFW na DOCKER-USER
+func (f *UFWFirewall) syncDocker() error {
+	return f.docker.syncBans(f)
+}
+
+// path retorna o caminho de um arquivo do UFW a partir da raiz configurada
+func (f *UFWFirewall) path(name string) string {
+	return filepath.Join(f.root, name)
+}
+
+// checkFamily recusa banimentos IPv6 quando o UFW não aplica regras IPv6
+// (IPV6=no em /etc/default/ufw): a regra seria aceita, mas não bloquearia nada
+func (f *UFWFirewall) checkFamily(ip string) error {
+	family, err := ipFamily(ip)
+	if err != nil || family != "ipv6" {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(f.path(ufwDefaultsFile))
+	if err != nil {
+		return fmt.Errorf("erro ao verificar suporte a IPv6 do UFW: %w", err)
+	}
+	if !ufwIPv6Enabled(string(data)) {
+		return fmt.Errorf("não é possível banir %s: o UFW está com IPv6 desabilitado (defina IPV6=yes em %s)", ip, ufwDefaultsFile)
+	}
+	return nil
+}
+
+// ufwIPv6Enabled interpreta o conteúdo de /etc/default/ufw
+func ufwIPv6Enabled(defaults string) bool {
+	enabled := false
+	for _, line := range strings.Split(defaults, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "IPV6=") {
+			continue
+		}
+		value := strings.Trim(strings.TrimPrefix(line, "IPV6="), `"'`)
+		enabled = strings.EqualFold(value, "yes")
+	}
+	return enabled
+}
+
+// ufwBanRules retorna as especificações das regras de bloqueio do IP no UFW,
+// uma por protocolo. O UFW aceita até 15 portas por regra e não tem um
+// equivalente ao TARPIT.
+func ufwBanRules(ip string, profile BanProfile) ([]string, error) {
+	action := "deny"
+	switch profile.Action {
+	case config.ActionDrop:
+	case config.ActionReject:
+		action = "reject"
+	default:
+		return nil, &UnsupportedActionError{Firewall: "ufw", Action: profile.Action}
+	}
+
+	base := action + " from " + ip + " to any"
+	if len(profile.Protocols) == 0 {
+		return []string{base}, nil
+	}
+
+	var rules []string
+	for _, proto := range profile.Protocols {
+		if profile.AllPorts() {
+			rules = append(rules, base+" proto "+proto)
+			continue
+		}
+		for _, ports := range chunkPorts(profile.Ports, 15) {
+			rules = append(rules, base+" port "+joinPorts(ports)+" proto "+proto)
+		}
+	}
+	return rules, nil
+}
+
+// parseUFWBans interpreta a saída do "ufw status", cujas colunas (To, Action
+// e From) são separadas por dois ou mais espaços
+func parseUFWBans(status string) []Ban {
+	var bans []Ban
+	for _, line := range strings.Split(status, "\n") {
+		if i := strings.Index(line, " # "); i >= 0 {
+			line = line[:i] // Remover comentário
+		}
+		cols := ufwColumns.Split(strings.TrimSpace(line), -1)
+		if len(cols) != 3 {
+			continue
+		}
+
+		fields := strings.Fields(cols[1])
+		action := strings.ToLower(fields[0])
+		if (action != "deny" && action != "reject") || containsString(fields, "OUT") {
+			continue
+		}
+		profileAction := config.ActionDrop
+		if action == "reject" {
+			profileAction = config.ActionReject
+		}
+		from := cols[2]
+		family, err := ipFamily(from)
+		if err != nil {
+			continue // Origem "Anywhere" ou não é um endereço
+		}
+
+		to := strings.TrimSpace(strings.TrimSuffix(cols[0], "(v6)"))
+		ports, proto := to, ""
+		if i := strings.Index(to, "/"); i >= 0 {
+			ports, proto = to[:i], to[i+1:]
+		}
+
+		rule := fmt.Sprintf("%s from %s to any", action, from)
+		if ports == "Anywhere" {
+			if proto != "" {
+				rule += " proto " + proto
+			}
+			bans = addBanRule(bans, from, family, 0, proto, profileAction, rule)
+			continue
+		}
+
+		rule += " port " + ports
+		if proto != "" {
+			rule += " proto " + proto
+		}
+		for _, port := range strings.Split(ports, ",") {
+			p, err := strconv.Atoi(port)
+			if err != nil {
+				continue // Serviços nomeados ou intervalos
+			}
+			bans = addBanRule(bans, from, family, p, proto, profileAction, rule)
+		}
+	}
+	return bans
+}
+
+// ufwRulesFor retorna as regras da saída do "ufw status" que têm o IP como
+// origem ou destino
+func ufwRulesFor(ip, status string) []string {
+	var rules []string
+	for _, line := range strings.Split(status, "\n") {
+		for _, field := range strings.Fields(line) {
+			if field == ip {
+				rules = append(rules, strings.Join(strings.Fields(line), " "))
+				break
+			}
+		}
+	}
+	return rules
+}
+
+// checkUFWOrder percorre a saída do "ufw status numbered". As regras IPv6 são
+// avaliadas separadamente das IPv4, então apenas as da família do IP contam.
+func checkUFWOrder(ip, family, status string) error {
+	for _, line := range strings.Split(status, "\n") {
+		line = strings.TrimSpace(line)
+		end := strings.Index(line, "]")
+		if !strings.HasPrefix(line, "[") || end < 0 {
+			continue
+		}
+		rule := line[end+1:]
+		if i := strings.Index(rule, " # "); i >= 0 {
+			rule = rule[:i] // Remover comentário
+		}
+
+		if strings.Contains(rule, "(v6)") != (family == "ipv6") {
+			continue
+		}
+
+		fields := strings.Fields(rule)
+		if len(fields) == 0 {
+			continue
+		}
+		from := fields[len(fields)-1]
+
+		if (strings.Contains(rule, "DENY") || strings.Contains(rule, "REJECT")) && from == ip {
+			return nil
+		}
+		if strings.Contains(rule, "ALLOW") && (from == ip || from == "Anywhere" || from == "(v6)") {
+			return &ShadowedError{IP: ip, Rule: strings.Join(fields, " ")}
+		}
+	}
+	return fmt.Errorf("regra de bloqueio para %s não encontrada no UFW", ip)
+}
@@ -0,0 +1,177 @@
+package hits
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mtm/guardian/internal/firewall"
+)
+
+// sampleInterval é o intervalo entre as leituras dos contadores
+const sampleInterval = 5 * time.Minute
+
+// Stat reúne os contadores de um banimento e quando ele bloqueou tráfego pela
+// última vez
+type Stat struct {
+	IP      string    `json:"ip"`
+	Packets uint64    `json:"packets"`
+	Bytes   uint64    `json:"bytes"`
+	Since   time.Time `json:"since"` // Primeira leitura dos contadores do banimento
+	// LastHit é a última leitura em que os contadores aumentaram. Pacotes
+	// bloqueados antes da primeira leitura não têm data conhecida.
+	LastHit *time.Time `json:"last_hit,omitempty"`
+}
+
+// HitSince indica se o banimento bloqueou tráfego a partir de t
+func (s Stat) HitSince(t time.Time) bool {
+	return s.LastHit != nil && !s.LastHit.Before(t)
+}
+
+// NeverHit indica se o banimento nunca bloqueou tráfego. Contadores zerados
+// não bastam: eles voltam a zero quando as regras são recriadas (reinício,
+// reconciliação, importação), e o último bloqueio registrado é mantido.
+func (s Stat) NeverHit() bool {
+	return s.Packets == 0 && s.LastHit == nil
+}
+
+// Tracker lê periodicamente os contadores dos banimentos e registra quando
+// cada um bloqueou tráfego. O registro é persistido em um arquivo JSON para
+// sobreviver a reinícios do serviço.
+type Tracker struct {
+	mu    sync.Mutex
+	path  string
+	fw    firewall.Firewall
+	stats map[string]Stat
+}
+
+// NewTracker cria o registro, carregando o salvo em path
+func NewTracker(path string, fw firewall.Firewall) (*Tracker, error) {
+	t := &Tracker{
+		path:  path,
+		fw:    fw,
+		stats: make(map[string]Stat),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return t, nil
+		}
+		return nil, fmt.Errorf("erro ao ler contadores: %w", err)
+	}
+
+	var stats []Stat
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, fmt.Errorf("erro ao decodificar contadores %s: %w", path, err)
+	}
+	for _, s := range stats {
+		t.stats[s.IP] = s
+	}
+
+	return t, nil
+}
+
+// Start lê os contadores periodicamente. Bloqueia, então deve ser executado
+// em uma goroutine.
+func (t *Tracker) Start() {
+	if _, err := t.Sample(time.Now()); err != nil {
+		log.Printf("Contadores dos banimentos indisponíveis: %v", err)
+		var unsupported *firewall.UnsupportedCountersError
+		if errors.As(err, &unsupported) {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if _, err := t.Sample(now); err != nil {
+			log.Printf("Erro ao ler contadores dos banimentos: %v", err)
+		}
+	}
+}
+
+// Sample lê os contadores do firewall e atualiza o registro: um aumento
+// desde a leitura anterior marca now como o último bloqueio. Contadores
+// menores que os anteriores indicam que as regras foram recriadas e são
+// tratados como uma nova contagem. Banimentos removidos saem do registro.
+func (t *Tracker) Sample(now time.Time) ([]Stat, error) {
+	counters, err := t.fw.Counters()
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := make(map[string]Stat, len(counters))
+	for _, c := range counters {
+		prev, known := t.stats[c.IP]
+		s := Stat{IP: c.IP, Packets: c.Packets, Bytes: c.Bytes, Since: now}
+		if known {
+			s.Since, s.LastHit = prev.Since, prev.LastHit
+			if c.Packets > prev.Packets || (c.Packets < prev.Packets && c.Packets > 0) {
+				hit := now
+				s.LastHit = &hit
+			}
+		}
+		stats[c.IP] = s
+	}
+	t.stats = stats
+
+	if err := t.save(); err != nil {
+		return nil, err
+	}
+	return t.sorted(), nil
+}
+
+// Stats retorna o registro da última leitura, ordenado pelo IP
+func (t *Tracker) Stats() []Stat {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.sorted()
+}
+
+// sorted retorna o registro ordenado pelo IP. Deve ser chamado com o mutex
+// travado.
+func (t *Tracker) sorted() []Stat {
+	stats := make([]Stat, 0, len(t.stats))
+	for _, s := range t.stats {
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].IP < stats[j].IP })
+	return stats
+}
+
+// save grava o registro no disco de forma atômica. Deve ser chamado com o
+// mutex travado.
+func (t *Tracker) save() error {
+	data, err := json.MarshalIndent(t.sorted(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("erro ao serializar contadores: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return fmt.Errorf("erro ao criar diretório dos contadores: %w", err)
+	}
+
+	tmp := t.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("erro ao salvar contadores: %w", err)
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		return fmt.Errorf("erro ao salvar contadores: %w", err)
+	}
+
+	return nil
+}
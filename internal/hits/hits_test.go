@@ -0,0 +1,60 @@
+package hits
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mtm/guardian/internal/config"
+	"github.com/mtm/guardian/internal/firewall"
+)
+
+// TestTrackerSample testa o registro do último bloqueio de cada banimento
+func TestTrackerSample(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hits.json")
+	fw := firewall.NewMockFirewall()
+	fw.BanIP("203.0.113.7", config.DefaultBanProfile())
+	fw.BanIP("203.0.113.8", config.DefaultBanProfile())
+	fw.SetCounters("203.0.113.7", 10, 600)
+
+	tracker, err := NewTracker(path, fw)
+	if err != nil {
+		t.Fatalf("Erro ao criar registro: %v", err)
+	}
+
+	// Na primeira leitura, a data dos pacotes já contados é desconhecida
+	start := time.Now().UTC()
+	stats, err := tracker.Sample(start)
+	if err != nil || len(stats) != 2 {
+		t.Fatalf("Leitura inesperada: %+v (%v)", stats, err)
+	}
+	if stats[0].LastHit != nil || stats[0].Packets != 10 {
+		t.Errorf("Primeira leitura inesperada: %+v", stats[0])
+	}
+
+	// Um aumento marca o bloqueio; o registro sobrevive a um reinício
+	fw.SetCounters("203.0.113.7", 15, 900)
+	if tracker, err = NewTracker(path, fw); err != nil {
+		t.Fatalf("Erro ao recarregar registro: %v", err)
+	}
+	hitAt := start.Add(time.Hour)
+	stats, _ = tracker.Sample(hitAt)
+	if !stats[0].HitSince(hitAt) || stats[1].LastHit != nil {
+		t.Errorf("Bloqueio não registrado: %+v", stats)
+	}
+	if !stats[0].Since.Equal(start) {
+		t.Errorf("Início da contagem alterado: %+v", stats[0])
+	}
+
+	// Sem aumento, o último bloqueio é mantido
+	stats, _ = tracker.Sample(hitAt.Add(25 * time.Hour))
+	if stats[0].HitSince(hitAt.Add(time.Hour)) || !stats[0].HitSince(hitAt) {
+		t.Errorf("Último bloqueio alterado: %+v", stats[0])
+	}
+
+	// Banimentos removidos saem do registro
+	fw.UnbanIP("203.0.113.8")
+	if stats, _ = tracker.Sample(hitAt.Add(26 * time.Hour)); len(stats) != 1 {
+		t.Errorf("Banimento removido continua no registro: %+v", stats)
+	}
+}
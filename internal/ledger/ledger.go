@@ -0,0 +1,236 @@
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mtm/guardian/internal/firewall"
+)
+
+// Origens dos banimentos
+const (
+	SourceAPI      = "api"
+	SourceDetector = "detector"
+	// SourceFeed marca banimentos enviados à API por importadores de listas
+	// de bloqueio
+	SourceFeed   = "feed"
+	SourceImport = "import"
+	// SourceReconcile marca banimentos encontrados no firewall sem registro
+	// e adotados pela reconciliação
+	SourceReconcile = "reconcile"
+)
+
+// Sources são as origens conhecidas, na ordem em que são documentadas
+var Sources = []string{SourceAPI, SourceDetector, SourceFeed, SourceImport, SourceReconcile}
+
+// ValidSource indica se a origem é uma das conhecidas
+func ValidSource(source string) bool {
+	for _, s := range Sources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// legacySourceReconcile é o valor de SourceReconcile gravado pelas primeiras
+// versões do registro, convertido ao abrir o arquivo
+const legacySourceReconcile = "reconciliacao"
+
+// Situações de um registro
+const (
+	StatusActive   = "ativo"
+	StatusExpired  = "expirado"
+	StatusRemoved  = "removido"
+	StatusReplaced = "substituido" // Por um novo banimento do IP ou de uma rede que o contém
+)
+
+// Record é o registro de um banimento feito pelo Guardian
+type Record struct {
+	ID        int64      `json:"id"`
+	IP        string     `json:"ip"`
+	Ports     []int      `json:"ports,omitempty"`     // Vazio quando todas as portas são bloqueadas
+	Protocols []string   `json:"protocols,omitempty"` // Vazio quando todos os protocolos são bloqueados
+	Action    string     `json:"action"`
+	Source    string     `json:"source"`
+	Reason    string     `json:"reason,omitempty"`
+	Creator   string     `json:"creator,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Ausente para banimentos permanentes
+	Status    string     `json:"status"`
+	// ClosedAt é quando o banimento deixou de estar ativo
+	ClosedAt *time.Time `json:"closed_at,omitempty"`
+}
+
+// Profile retorna o perfil do banimento
+func (r Record) Profile() firewall.BanProfile {
+	return firewall.BanProfile{Ports: r.Ports, Protocols: r.Protocols, Action: r.Action}
+}
+
+// Ledger é o registro local dos banimentos feitos pela API e pelo detector,
+// com origem, motivo, autor, datas e situação. Fica em um único arquivo
+// JSON e não depende de nenhum serviço externo. Cada IP tem no máximo um
+// registro ativo; os anteriores são mantidos como histórico.
+type Ledger struct {
+	mu      sync.Mutex
+	path    string
+	records []Record
+	nextID  int64
+}
+
+// Open abre o registro salvo em path, que é criado no primeiro banimento
+func Open(path string) (*Ledger, error) {
+	l := &Ledger{path: path, nextID: 1}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return l, nil
+		}
+		return nil, fmt.Errorf("erro ao ler registro de banimentos: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &l.records); err != nil {
+		return nil, fmt.Errorf("erro ao decodificar registro de banimentos %s: %w", path, err)
+	}
+	for i, r := range l.records {
+		if r.ID >= l.nextID {
+			l.nextID = r.ID + 1
+		}
+		if r.Source == legacySourceReconcile {
+			l.records[i].Source = SourceReconcile
+		}
+	}
+
+	return l, nil
+}
+
+// Add registra um banimento aplicado ao firewall. Os registros ativos do
+// mesmo IP ou de IPs e redes contidos nele passam a substituídos, como no
+// firewall, onde o banimento de uma rede unifica os menores. Sem CreatedAt,
+// é usado o horário atual.
+func (l *Ledger) Add(r Record) (Record, error) {
+	prefix, err := firewall.ParseTarget(r.IP)
+	if err != nil {
+		return Record{}, err
+	}
+	r.IP = firewall.FormatTarget(prefix)
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now().UTC()
+	}
+	r.Status = StatusActive
+	r.ClosedAt = nil
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, other := range l.records {
+		if other.Status != StatusActive {
+			continue
+		}
+		if p, err := firewall.ParseTarget(other.IP); err == nil && prefix.Bits() <= p.Bits() && prefix.Contains(p.Addr()) {
+			l.close(i, StatusReplaced, r.CreatedAt)
+		}
+	}
+
+	r.ID = l.nextID
+	l.nextID++
+	l.records = append(l.records, r)
+	return r, l.save()
+}
+
+// Close encerra o registro ativo do IP com a situação informada (expirado
+// ou removido). Um IP sem registro ativo é ignorado.
+func (l *Ledger) Close(ip, status string) error {
+	target, err := firewall.NormalizeTarget(ip)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, r := range l.records {
+		if r.Status == StatusActive && r.IP == target {
+			l.close(i, status, time.Now().UTC())
+			return l.save()
+		}
+	}
+	return nil
+}
+
+// Get retorna o registro ativo do IP
+func (l *Ledger) Get(ip string) (Record, bool) {
+	target, err := firewall.NormalizeTarget(ip)
+	if err != nil {
+		return Record{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, r := range l.records {
+		if r.Status == StatusActive && r.IP == target {
+			return r, true
+		}
+	}
+	return Record{}, false
+}
+
+// Active retorna os registros ativos, na ordem em que foram criados
+func (l *Ledger) Active() []Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var active []Record
+	for _, r := range l.records {
+		if r.Status == StatusActive {
+			active = append(active, r)
+		}
+	}
+	return active
+}
+
+// Records retorna todos os registros, inclusive o histórico, na ordem em
+// que foram criados
+func (l *Ledger) Records() []Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]Record(nil), l.records...)
+}
+
+// close encerra o registro de índice i. Deve ser chamado com o mutex
+// travado.
+func (l *Ledger) close(i int, status string, at time.Time) {
+	l.records[i].Status = status
+	l.records[i].ClosedAt = &at
+}
+
+// save grava o registro no disco de forma atômica. Deve ser chamado com o
+// mutex travado.
+func (l *Ledger) save() error {
+	data, err := json.MarshalIndent(l.records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("erro ao serializar registro de banimentos: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("erro ao criar diretório do registro de banimentos: %w", err)
+	}
+
+	tmp := l.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("erro ao salvar registro de banimentos: %w", err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return fmt.Errorf("erro ao salvar registro de banimentos: %w", err)
+	}
+
+	return nil
+}
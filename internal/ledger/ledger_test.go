@@ -0,0 +1,80 @@
+package ledger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mtm/guardian/internal/config"
+)
+
+// TestLedger testa o registro dos banimentos e do histórico
+func TestLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("Erro ao abrir registro: %v", err)
+	}
+
+	profile := config.DefaultBanProfile()
+	expiresAt := time.Now().Add(time.Hour).UTC()
+	if _, err := l.Add(Record{IP: "203.0.113.7", Action: profile.Action, Source: SourceDetector, Reason: "5 tentativas", ExpiresAt: &expiresAt}); err != nil {
+		t.Fatalf("Erro ao registrar banimento: %v", err)
+	}
+	if _, err := l.Add(Record{IP: "2001:DB8::1", Action: profile.Action, Source: SourceAPI, Creator: "198.51.100.2"}); err != nil {
+		t.Fatalf("Erro ao registrar banimento: %v", err)
+	}
+
+	// O registro deve sobreviver a um reinício
+	l, err = Open(path)
+	if err != nil {
+		t.Fatalf("Erro ao reabrir registro: %v", err)
+	}
+	r, ok := l.Get("2001:db8::1")
+	if !ok || r.Source != SourceAPI || r.Creator != "198.51.100.2" || r.Status != StatusActive {
+		t.Errorf("Registro inesperado: %+v", r)
+	}
+
+	// Um banimento da rede substitui o do IP contido nela
+	if _, err := l.Add(Record{IP: "203.0.113.0/24", Action: profile.Action, Source: SourceAPI}); err != nil {
+		t.Fatalf("Erro ao registrar banimento: %v", err)
+	}
+	if _, ok := l.Get("203.0.113.7"); ok {
+		t.Error("Registro do IP deveria ter sido substituído pelo da rede")
+	}
+
+	if err := l.Close("2001:db8::1", StatusRemoved); err != nil {
+		t.Fatalf("Erro ao encerrar registro: %v", err)
+	}
+	active := l.Active()
+	if len(active) != 1 || active[0].IP != "203.0.113.0/24" {
+		t.Errorf("Registros ativos inesperados: %+v", active)
+	}
+
+	records := l.Records()
+	if len(records) != 3 || records[0].Status != StatusReplaced || records[1].Status != StatusRemoved || records[1].ClosedAt == nil {
+		t.Errorf("Histórico inesperado: %+v", records)
+	}
+	if records[2].ID != 3 {
+		t.Errorf("ID esperado: 3, obtido: %d", records[2].ID)
+	}
+}
+
+// TestLegacySource testa a conversão da origem da reconciliação gravada
+// pelas primeiras versões do registro
+func TestLegacySource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	data := `[{"id": 1, "ip": "203.0.113.7", "action": "drop", "source": "reconciliacao", "created_at": "2024-01-01T00:00:00Z", "status": "ativo"}]`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("Erro ao abrir registro: %v", err)
+	}
+	if r, ok := l.Get("203.0.113.7"); !ok || r.Source != SourceReconcile {
+		t.Errorf("Registro inesperado: %+v", r)
+	}
+}
@@ -0,0 +1,256 @@
+package reconcile
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mtm/guardian/internal/config"
+	"github.com/mtm/guardian/internal/expiry"
+	"github.com/mtm/guardian/internal/firewall"
+	"github.com/mtm/guardian/internal/ledger"
+)
+
+// Tipos de divergência entre o registro de banimentos e o firewall
+const (
+	KindMissing   = "ausente"    // Ativo no registro, ausente do firewall
+	KindDivergent = "divergente" // No firewall com um perfil diferente do registrado
+	KindOrphan    = "orfao"      // No firewall, sem registro ativo
+)
+
+// Como cada divergência foi tratada
+const (
+	ResolutionReapplied = "reaplicado"
+	ResolutionRemoved   = "removido"
+	ResolutionAdopted   = "adotado"
+	ResolutionFlagged   = "sinalizado"
+	ResolutionFailed    = "falhou"
+)
+
+// Drift é uma divergência encontrada na reconciliação
+type Drift struct {
+	IP         string `json:"ip"`
+	Kind       string `json:"kind"`
+	Expected   string `json:"expected,omitempty"` // Perfil registrado
+	Actual     string `json:"actual,omitempty"`   // Perfil no firewall
+	Resolution string `json:"resolution"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Report é o resultado de uma reconciliação
+type Report struct {
+	Time     time.Time `json:"time"`
+	Firewall string    `json:"firewall"`
+	Orphans  string    `json:"orphans"`  // Tratamento dos órfãos (GUARDIAN_RECONCILE_ORPHANS)
+	Expected int       `json:"expected"` // Banimentos ativos no registro
+	Actual   int       `json:"actual"`   // Banimentos no firewall antes da reconciliação
+	Drifts   []Drift   `json:"drifts"`
+}
+
+// InSync indica se o firewall estava de acordo com o registro
+func (r *Report) InSync() bool {
+	return len(r.Drifts) == 0
+}
+
+// Reconciler compara os banimentos ativos no registro com os que o firewall
+// reporta e corrige as divergências: reaplica os banimentos ausentes ou com
+// outro perfil (depois de um reinício do host ou de um "ufw reset", por
+// exemplo) e trata os órfãos conforme o modo configurado.
+type Reconciler struct {
+	mu      sync.Mutex
+	fw      firewall.Firewall
+	ledger  *ledger.Ledger
+	expiry  *expiry.Scheduler
+	orphans string
+	last    *Report
+}
+
+// New cria a reconciliação. fw deve ser o firewall protegido pela lista de
+// permitidos, para que nada permitido seja reaplicado. O agendador de
+// expiração é opcional.
+func New(fw firewall.Firewall, l *ledger.Ledger, sched *expiry.Scheduler, orphans string) *Reconciler {
+	return &Reconciler{fw: fw, ledger: l, expiry: sched, orphans: orphans}
+}
+
+// Start reconcilia periodicamente. Bloqueia, então deve ser executado em uma
+// goroutine.
+func (r *Reconciler) Start(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		r.RunAndLog(now)
+	}
+}
+
+// RunAndLog reconcilia e registra o resultado no log
+func (r *Reconciler) RunAndLog(now time.Time) {
+	report, err := r.Run(now)
+	if err != nil {
+		log.Printf("Erro na reconciliação dos banimentos: %v", err)
+		return
+	}
+	if report.InSync() {
+		return
+	}
+	log.Printf("Reconciliação dos banimentos: %d divergência(s) entre o registro (%d ativos) e o firewall (%d)",
+		len(report.Drifts), report.Expected, report.Actual)
+	for _, d := range report.Drifts {
+		if d.Error != "" {
+			log.Printf("Reconciliação: %s %s: %s (%s)", d.IP, d.Kind, d.Resolution, d.Error)
+		} else {
+			log.Printf("Reconciliação: %s %s: %s", d.IP, d.Kind, d.Resolution)
+		}
+	}
+}
+
+// Run executa uma reconciliação e guarda o relatório, retornado também por
+// Last. Banimentos registrados cuja expiração já passou não são reaplicados
+// nem tratados como órfãos: ficam para o agendador.
+func (r *Reconciler) Run(now time.Time) (*Report, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// O firewall é lido antes do registro, para que um banimento em andamento
+	// (já no firewall, ainda não registrado) não seja tomado por órfão. Um
+	// desbanimento em andamento apareceria como ausente; por isso o registro
+	// é conferido de novo antes de cada alteração.
+	bans, err := r.fw.ListBanned()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao listar banimentos: %w", err)
+	}
+
+	active := r.ledger.Active()
+	var records []ledger.Record
+	for _, rec := range active {
+		if rec.ExpiresAt == nil || rec.ExpiresAt.After(now) {
+			records = append(records, rec)
+		}
+	}
+
+	report := &Report{
+		Time:     now.UTC(),
+		Firewall: r.fw.Type(),
+		Orphans:  r.orphans,
+		Expected: len(records),
+		Actual:   len(bans),
+		Drifts:   []Drift{},
+	}
+
+	for _, rec := range records {
+		ban := firewall.FindBan(bans, rec.IP)
+		covered, _ := firewall.BannedIn(bans, rec.IP)
+		switch {
+		case ban == nil && covered:
+			// Unificado ao banimento de uma rede que o contém
+		case ban == nil:
+			report.Drifts = append(report.Drifts, r.reapply(rec, Drift{IP: rec.IP, Kind: KindMissing, Expected: rec.Profile().String()}))
+		case !ban.Profile().Equal(rec.Profile()):
+			report.Drifts = append(report.Drifts, r.reapply(rec, Drift{IP: rec.IP, Kind: KindDivergent, Expected: rec.Profile().String(), Actual: ban.Profile().String()}))
+		}
+	}
+
+	for _, ban := range bans {
+		if ip, err := firewall.NormalizeTarget(ban.IP); err != nil || recorded(active, ip) {
+			continue
+		}
+		report.Drifts = append(report.Drifts, r.orphan(ban, now))
+	}
+
+	r.last = report
+	return report, nil
+}
+
+// Last retorna o relatório da última reconciliação, ou nil se nenhuma foi
+// executada
+func (r *Reconciler) Last() *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.last
+}
+
+// reapply aplica novamente o banimento registrado, com o perfil e a
+// expiração do registro
+func (r *Reconciler) reapply(rec ledger.Record, d Drift) Drift {
+	if _, ok := r.ledger.Get(rec.IP); !ok {
+		d.Resolution = ResolutionFlagged
+		d.Error = "registro encerrado durante a reconciliação"
+		return d
+	}
+
+	if err := r.fw.BanIP(rec.IP, rec.Profile()); err != nil {
+		d.Resolution, d.Error = ResolutionFailed, err.Error()
+		return d
+	}
+	if r.expiry != nil && rec.ExpiresAt != nil {
+		if _, ok := r.expiry.ExpiresAt(rec.IP); !ok {
+			if err := r.expiry.Schedule(rec.IP, *rec.ExpiresAt); err != nil {
+				d.Resolution, d.Error = ResolutionFailed, err.Error()
+				return d
+			}
+		}
+	}
+	d.Resolution = ResolutionReapplied
+	return d
+}
+
+// orphan trata um banimento do firewall sem registro ativo conforme o modo
+// configurado
+func (r *Reconciler) orphan(ban firewall.Ban, now time.Time) Drift {
+	ip, _ := firewall.NormalizeTarget(ban.IP)
+	d := Drift{IP: ip, Kind: KindOrphan, Actual: ban.Profile().String(), Resolution: ResolutionFlagged}
+
+	switch r.orphans {
+	case config.OrphansRemove:
+		if _, ok := r.ledger.Get(ip); ok {
+			d.Error = "registrado durante a reconciliação"
+			return d
+		}
+		if err := r.fw.UnbanIP(ip); err != nil {
+			d.Resolution, d.Error = ResolutionFailed, err.Error()
+			return d
+		}
+		if r.expiry != nil {
+			if err := r.expiry.Cancel(ip); err != nil {
+				d.Resolution, d.Error = ResolutionFailed, err.Error()
+				return d
+			}
+		}
+		d.Resolution = ResolutionRemoved
+	case config.OrphansAdopt:
+		rec := ledger.Record{
+			IP:        ip,
+			Ports:     ban.Ports,
+			Protocols: ban.Protocols,
+			Action:    ban.Action,
+			Source:    ledger.SourceReconcile,
+			Reason:    "encontrado no firewall sem registro",
+			Creator:   "guardian",
+			CreatedAt: now.UTC(),
+		}
+		if r.expiry != nil {
+			if at, ok := r.expiry.ExpiresAt(ip); ok {
+				rec.ExpiresAt = &at
+			}
+		}
+		if _, err := r.ledger.Add(rec); err != nil {
+			d.Resolution, d.Error = ResolutionFailed, err.Error()
+			return d
+		}
+		d.Resolution = ResolutionAdopted
+	}
+	return d
+}
+
+// recorded indica se o banimento do firewall tem um registro ativo: o do
+// próprio IP ou o de uma rede que o contém
+func recorded(records []ledger.Record, ip string) bool {
+	for _, rec := range records {
+		if firewall.Covers(rec.IP, ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package reconcile
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mtm/guardian/internal/config"
+	"github.com/mtm/guardian/internal/firewall"
+	"github.com/mtm/guardian/internal/ledger"
+)
+
+// newLedger cria um registro de banimentos vazio em um diretório temporário
+func newLedger(t *testing.T) *ledger.Ledger {
+	t.Helper()
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.json"))
+	if err != nil {
+		t.Fatalf("Erro ao abrir registro: %v", err)
+	}
+	return l
+}
+
+// TestReconcile testa a reaplicação dos banimentos ausentes e divergentes e
+// a sinalização dos órfãos
+func TestReconcile(t *testing.T) {
+	profile := config.DefaultBanProfile()
+	fw := firewall.NewMockFirewall()
+	l := newLedger(t)
+
+	// Registrado e presente: em dia
+	l.Add(ledger.Record{IP: "203.0.113.1", Action: profile.Action, Ports: profile.Ports, Protocols: profile.Protocols, Source: ledger.SourceAPI})
+	fw.BanIP("203.0.113.1", profile)
+	// Registrado e ausente, como depois de um "ufw reset"
+	l.Add(ledger.Record{IP: "203.0.113.2", Action: profile.Action, Ports: profile.Ports, Protocols: profile.Protocols, Source: ledger.SourceDetector})
+	// Registrado com outro perfil
+	l.Add(ledger.Record{IP: "203.0.113.3", Action: config.ActionReject, Source: ledger.SourceAPI})
+	fw.BanIP("203.0.113.3", profile)
+	// Expirado: fica para o agendador
+	past := time.Now().Add(-time.Minute)
+	l.Add(ledger.Record{IP: "203.0.113.4", Action: profile.Action, Source: ledger.SourceDetector, ExpiresAt: &past})
+	// No firewall, sem registro
+	fw.BanIP("198.51.100.9", profile)
+
+	r := New(fw, l, nil, config.OrphansFlag)
+	report, err := r.Run(time.Now())
+	if err != nil {
+		t.Fatalf("Erro na reconciliação: %v", err)
+	}
+
+	expected := map[string]string{
+		"203.0.113.2":  KindMissing + " " + ResolutionReapplied,
+		"203.0.113.3":  KindDivergent + " " + ResolutionReapplied,
+		"198.51.100.9": KindOrphan + " " + ResolutionFlagged,
+	}
+	if len(report.Drifts) != len(expected) || report.Expected != 3 || report.Actual != 3 {
+		t.Fatalf("Relatório inesperado: %+v", report)
+	}
+	for _, d := range report.Drifts {
+		if expected[d.IP] != d.Kind+" "+d.Resolution {
+			t.Errorf("Divergência inesperada para %s: %s %s", d.IP, d.Kind, d.Resolution)
+		}
+	}
+	if banned, _ := fw.IsBanned("203.0.113.2"); !banned {
+		t.Error("Banimento ausente deveria ter sido reaplicado")
+	}
+	bans, _ := fw.ListBanned()
+	for _, ban := range bans {
+		if ban.IP == "203.0.113.3" && ban.Action != config.ActionReject {
+			t.Errorf("Perfil registrado deveria ter sido reaplicado: %+v", ban)
+		}
+	}
+	if r.Last() != report {
+		t.Error("Last deveria retornar o último relatório")
+	}
+
+	// Depois da correção, só resta o órfão sinalizado
+	if report, _ = r.Run(time.Now()); len(report.Drifts) != 1 {
+		t.Errorf("Divergências inesperadas: %+v", report.Drifts)
+	}
+}
+
+// TestReconcileOrphans testa a remoção e a adoção dos banimentos órfãos
+func TestReconcileOrphans(t *testing.T) {
+	profile := config.DefaultBanProfile()
+
+	fw := firewall.NewMockFirewall()
+	fw.BanIP("198.51.100.9", profile)
+	report, err := New(fw, newLedger(t), nil, config.OrphansRemove).Run(time.Now())
+	if err != nil || len(report.Drifts) != 1 || report.Drifts[0].Resolution != ResolutionRemoved {
+		t.Fatalf("Relatório inesperado: %+v (%v)", report, err)
+	}
+	if banned, _ := fw.IsBanned("198.51.100.9"); banned {
+		t.Error("Órfão deveria ter sido removido")
+	}
+
+	fw.BanIP("198.51.100.9", profile)
+	l := newLedger(t)
+	report, err = New(fw, l, nil, config.OrphansAdopt).Run(time.Now())
+	if err != nil || len(report.Drifts) != 1 || report.Drifts[0].Resolution != ResolutionAdopted {
+		t.Fatalf("Relatório inesperado: %+v (%v)", report, err)
+	}
+	if rec, ok := l.Get("198.51.100.9"); !ok || rec.Source != ledger.SourceReconcile || !rec.Profile().Equal(profile) {
+		t.Errorf("Órfão deveria ter sido adotado com o perfil do firewall: %+v", rec)
+	}
+}
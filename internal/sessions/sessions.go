@@ -0,0 +1,120 @@
+package sessions
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tcpEstablished é o estado TCP_ESTABLISHED em /proc/net/tcp
+const tcpEstablished = "01"
+
+// procFiles são as tabelas de conexões TCP do kernel, IPv4 e IPv6
+var procFiles = []string{"/proc/net/tcp", "/proc/net/tcp6"}
+
+// Session é uma conexão TCP estabelecida com o servidor
+type Session struct {
+	Local  netip.AddrPort
+	Remote netip.AddrPort
+}
+
+func (s Session) String() string {
+	return fmt.Sprintf("%s -> porta %d", s.Remote, s.Local.Port())
+}
+
+// Established retorna as conexões TCP estabelecidas cuja porta local está em
+// ports, como as sessões SSH abertas no servidor. A tabela IPv6 é ignorada
+// quando não existe.
+func Established(ports []int) ([]Session, error) {
+	var sessions []Session
+	for _, path := range procFiles {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("erro ao ler %s: %w", path, err)
+		}
+
+		found, err := Parse(data, ports)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao interpretar %s: %w", path, err)
+		}
+		sessions = append(sessions, found...)
+	}
+	return sessions, nil
+}
+
+// Parse interpreta o conteúdo de /proc/net/tcp ou /proc/net/tcp6 e retorna as
+// conexões estabelecidas cuja porta local está em ports
+func Parse(data []byte, ports []int) ([]Session, error) {
+	var sessions []Session
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Cabeçalho: "sl local_address rem_address st ..."
+		if len(fields) < 4 || fields[0] == "sl" || fields[3] != tcpEstablished {
+			continue
+		}
+
+		local, err := parseAddrPort(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		if !containsPort(ports, local.Port()) {
+			continue
+		}
+		remote, err := parseAddrPort(fields[2])
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, Session{Local: local, Remote: remote})
+	}
+
+	return sessions, scanner.Err()
+}
+
+// parseAddrPort interpreta um endereço no formato do kernel, "0100007F:0016".
+// O endereço é uma sequência de palavras de 32 bits na ordem de bytes do
+// host (little-endian); endereços IPv4 mapeados em IPv6 são convertidos
+// para IPv4.
+func parseAddrPort(s string) (netip.AddrPort, error) {
+	addrHex, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return netip.AddrPort{}, fmt.Errorf("endereço inválido: %s", s)
+	}
+
+	raw, err := hex.DecodeString(addrHex)
+	if err != nil || (len(raw) != 4 && len(raw) != 16) {
+		return netip.AddrPort{}, fmt.Errorf("endereço inválido: %s", s)
+	}
+	for i := 0; i < len(raw); i += 4 {
+		raw[i], raw[i+1], raw[i+2], raw[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("porta inválida: %s", s)
+	}
+
+	addr, _ := netip.AddrFromSlice(raw)
+	return netip.AddrPortFrom(addr.Unmap(), uint16(port)), nil
+}
+
+// containsPort verifica se a lista contém a porta
+func containsPort(ports []int, port uint16) bool {
+	for _, p := range ports {
+		if p == int(port) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package sessions
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParse testa a leitura das tabelas /proc/net/tcp e /proc/net/tcp6
+func TestParse(t *testing.T) {
+	tcp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100000A:0016 057100CB:C822 01 00000000:00000000 02:00051F4A 00000000     0        0 1002 4 0000000000000000 20 4 29 10 -1
+   2: 0100000A:11CA 057100CB:C823 01 00000000:00000000 00:00000000 00000000     0        0 1003 1 0000000000000000 20 4 30 10 -1
+   3: 0100000A:0016 0A7100CB:C824 06 00000000:00000000 03:00000A2B 00000000     0        0 0 3 0000000000000000
+`
+	tcp6 := `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: B80D0120000000000000000001000000:0016 B80D0120000000000000000005000000:D431 01 00000000:00000000 02:00051F4A 00000000     0        0 2001 4 0000000000000000 20 4 29 10 -1
+   1: 0000000000000000FFFF00000100000A:0016 0000000000000000FFFF0000057100CB:D432 01 00000000:00000000 02:00051F4A 00000000     0        0 2002 4 0000000000000000 20 4 29 10 -1
+`
+
+	var remotes []string
+	for _, data := range []string{tcp, tcp6} {
+		found, err := Parse([]byte(data), []int{22})
+		if err != nil {
+			t.Fatalf("Erro inesperado: %v", err)
+		}
+		for _, s := range found {
+			remotes = append(remotes, s.Remote.String())
+		}
+	}
+
+	// Apenas conexões estabelecidas (01) na porta 22; 4in6 vira IPv4
+	expected := []string{"203.0.113.5:51234", "[2001:db8::5]:54321", "203.0.113.5:54322"}
+	if !reflect.DeepEqual(remotes, expected) {
+		t.Errorf("Sessões: %v; esperado %v", remotes, expected)
+	}
+
+	if _, err := Parse([]byte("   0: XYZ:0016 00000000:0000 01\n"), []int{22}); err == nil {
+		t.Error("Esperado erro para endereço inválido")
+	}
+}
@@ -25,9 +25,10 @@ fi
 
 log "Executando detector de força bruta..."
 
-# Executar lastb e processar saída
+# Executar lastb e processar saída (-w e -i evitam que endereços IPv6 sejam
+# truncados ou resolvidos para nomes)
 log "Executando comando lastb..."
-LASTB_OUTPUT=$(sudo lastb 2>&1)
+LASTB_OUTPUT=$(sudo lastb -w -i 2>&1)
 
 if [ $? -ne 0 ]; then
     log "ERRO ao executar lastb: $LASTB_OUTPUT"
@@ -36,7 +37,7 @@ fi
 
 # Processar a saída para obter IPs e contagens
 log "Processando saída do lastb..."
-PROCESSED_OUTPUT=$(echo "$LASTB_OUTPUT" | awk '{ print $3 }' | sort | uniq -c | sort -nr)
+PROCESSED_OUTPUT=$(echo "$LASTB_OUTPUT" | awk '$3 ~ /^[0-9a-fA-F.:]+$/ && $3 != "0.0.0.0" && $3 != "::" { print $3 }' | sort | uniq -c | sort -nr)
 
 if [ -z "$PROCESSED_OUTPUT" ]; then
     log "Nenhuma tentativa de login malsucedida encontrada."
@@ -236,28 +236,16 @@ case "$FIREWALL" in
 
     iptables)
         log "Configurando iptables..."
-        iptables -F
-        iptables -X
-        iptables -P INPUT DROP
-        iptables -P FORWARD DROP
-        iptables -P OUTPUT ACCEPT
-        iptables -A INPUT -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT
-        iptables -A INPUT -i lo -j ACCEPT
-        iptables -A INPUT -p tcp --dport 22 -j ACCEPT
-        iptables -A INPUT -p tcp --dport 4554 -j ACCEPT
-        iptables -A INPUT -p tcp --dport 80 -j ACCEPT
-        iptables -A INPUT -p tcp --dport 443 -j ACCEPT
-        ip6tables -A INPUT -p tcp --dport 22 -j ACCEPT
-        ip6tables -A INPUT -p tcp --dport 4554 -j ACCEPT
-        ip6tables -A INPUT -p tcp --dport 80 -j ACCEPT
-        ip6tables -A INPUT -p tcp --dport 443 -j ACCEPT
-        iptables-save > /etc/iptables/rules.v4 || (mkdir -p /etc/iptables && iptables-save > /etc/iptables/rules.v4)
-        ip6tables-save > /etc/iptables/rules.v6 || (mkdir -p /etc/iptables && ip6tables-save > /etc/iptables/rules.v6)
-        # Checar se iptables está ativo (INPUT default DROP)
-        IPT_STATUS=$(iptables -L | grep 'Chain INPUT (policy DROP)')
-        if [ -z "$IPT_STATUS" ]; then
-            error "iptables não foi ativado corretamente!"
+        # Os banimentos do Guardian ficam em ipsets
+        if ! command -v ipset >/dev/null 2>&1; then
+            log "ipset não encontrado. Instalando..."
+            apt-get install -y ipset
         fi
+        # As regras e políticas existentes (Docker, Kubernetes, regras
+        # manuais) são mantidas: o Guardian cria a chain GUARDIAN e o salto a
+        # partir da INPUT ao iniciar, com as liberações de conexões
+        # estabelecidas, loopback, SSH e da API
+        mkdir -p /etc/iptables
         iptables -L -v -n
         ;;
     firewalld)